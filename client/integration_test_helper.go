@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"testing"
 	"time"
 	"user/service/api"
@@ -26,3 +27,26 @@ func CloseConnection(t *testing.T, cancel context.CancelFunc, conn *grpc.ClientC
 		t.Fatalf("failed to close connection - %s", err.Error())
 	}
 }
+
+// Credentials of the admin created at startup, see ADMIN_EMAIL and ADMIN_PASSWORD in docker-compose.yml
+const (
+	adminEmail    = "admin@email.com"
+	adminPassword = "admin_test_password"
+)
+
+// AuthenticateAdmin returns a context carrying the access token of the admin
+func AuthenticateAdmin(t *testing.T, client api.UserServiceClient, ctx context.Context) context.Context {
+	response, err := client.Authenticate(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: adminEmail},
+		Password: adminPassword,
+	})
+	if err != nil {
+		t.Fatalf("admin authentication failed: %v", err)
+	}
+	return WithBearerToken(ctx, response.Tokens.AccessToken)
+}
+
+// WithBearerToken returns a context sending the given access token in the authorization metadata
+func WithBearerToken(ctx context.Context, accessToken string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+accessToken)
+}
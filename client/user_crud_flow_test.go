@@ -2,6 +2,8 @@ package client
 
 import (
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"testing"
 	"user/service/api"
 )
@@ -38,20 +40,66 @@ func TestUserServiceCrudFlow(t *testing.T) {
 	assert.Equal(t, createdUser.Email, response.User.Email)
 	assert.Equal(t, createdUser.Country, response.User.Country)
 	user1Id := response.User.Id
-	// Get users
+	// Email and nickname are unique, regardless of the case
+	duplicateRequest := &api.CreateUserRequest{
+		Firstname: "firstname",
+		Lastname:  "lastname",
+		Nickname:  "other_nickname",
+		Email:     "Test@Email.com",
+		Password:  "my_test_password",
+		Country:   api.Country_EN,
+	}
+	_, err = client.CreateUser(ctx, duplicateRequest)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	availableNickname := "other_nickname"
+	availabilityResponse, err := client.CheckAvailability(ctx, &api.CheckAvailabilityRequest{
+		Email:    &request.Email,
+		Nickname: &availableNickname,
+	})
+	if err != nil {
+		t.Fatalf("Check availability GRPC call failed: %v", err)
+	}
+	assert.False(t, availabilityResponse.GetEmailAvailable())
+	assert.True(t, availabilityResponse.GetNicknameAvailable())
+	// Authenticate the user
+	authResponse, err := client.Authenticate(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: "test@email.com"},
+		Password: "my_test_password",
+	})
+	if err != nil {
+		t.Fatalf("Authenticate GRPC call failed: %v", err)
+	}
+	assert.Equal(t, user1Id, authResponse.User.Id)
+	userCtx := WithBearerToken(ctx, authResponse.Tokens.AccessToken)
+	// Get the user itself
+	getUserResponse, err := client.GetUser(userCtx, &api.GetUserRequest{Id: user1Id})
+	if err != nil {
+		t.Fatalf("Get user GRPC call failed: %v", err)
+	}
+	assert.Equal(t, createdUser.Email, getUserResponse.Email)
+	// Get users requires the users.read permission
 	country := api.Country_EN
 	getRequest := &api.GetUsersRequest{
 		FilterCountry: &country,
 		Page:          0,
 		PageSize:      10,
 	}
-	getResponse, getError := client.GetUsers(ctx, getRequest)
+	_, getError := client.GetUsers(userCtx, getRequest)
+	assert.Equal(t, codes.PermissionDenied, status.Code(getError))
+	adminCtx := AuthenticateAdmin(t, client, ctx)
+	getResponse, getError := client.GetUsers(adminCtx, getRequest)
 	if getError != nil {
-		t.Fatalf("Get GRPC call failed: %v", err)
+		t.Fatalf("Get GRPC call failed: %v", getError)
 	}
 	assert.NotNil(t, getResponse)
 	assert.Equal(t, 1, len(getResponse.Results))
 	assert.Equal(t, user1Id, getResponse.Results[0].Id)
+	assert.Equal(t, []string{"user"}, getResponse.Results[0].Roles)
+	lookupResponse, err := client.LookupUser(adminCtx, &api.LookupUserRequest{Key: &api.LookupUserRequest_Nickname{Nickname: "nickname"}})
+	if err != nil {
+		t.Fatalf("Lookup user GRPC call failed: %v", err)
+	}
+	assert.Equal(t, user1Id, lookupResponse.Id)
 	// Update the user
 	updatedFirstname := "Ufirstname"
 	updatedLastname := "Ulastname"
@@ -68,75 +116,138 @@ func TestUserServiceCrudFlow(t *testing.T) {
 		Password:  &updatedPassword,
 		Country:   &updatedCountry,
 	}
-	updateResponse, err := client.UpdateUser(ctx, updateRequest)
+	_, err = client.UpdateUser(ctx, updateRequest)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	updateResponse, err := client.UpdateUser(userCtx, updateRequest)
 	if err != nil {
 		t.Fatalf("GRPC call failed: %v", err)
 	}
 	assert.NotNil(t, updateResponse)
+	// Old credentials are no longer valid
+	_, err = client.Authenticate(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: "test@email.com"},
+		Password: "my_test_password",
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// Authenticate with the updated credentials
+	updatedAuthResponse, err := client.Authenticate(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Nickname{Nickname: updatedNickname},
+		Password: updatedPassword,
+	})
+	if err != nil {
+		t.Fatalf("Authenticate GRPC call failed: %v", err)
+	}
+	assert.Equal(t, user1Id, updatedAuthResponse.User.Id)
+	assert.Equal(t, updatedFirstname, updatedAuthResponse.User.Firstname)
+	assert.Equal(t, updatedLastname, updatedAuthResponse.User.Lastname)
+	assert.Equal(t, updatedEmail, updatedAuthResponse.User.Email)
+	assert.Equal(t, updatedNickname, updatedAuthResponse.User.Nickname)
+	assert.Equal(t, updatedCountry, updatedAuthResponse.User.Country)
 	// Get the updated user with filter on language = IT
-	countryIT := api.Country_IT
-	getRequestWithItFilterRequest := &api.GetUsersRequest{
-		FilterCountry: &countryIT,
-		Page:          0,
-		PageSize:      10,
-	}
-	getResponseItFilterResponse, getErrorItFilter := client.GetUsers(ctx, getRequestWithItFilterRequest)
-	if getErrorItFilter != nil {
-		t.Fatalf("Get with IT filter GRPC call failed: %v", err)
-	}
-	assert.NotNil(t, getResponseItFilterResponse)
-	assert.Equal(t, int64(10), getResponseItFilterResponse.PageSize)
-	assert.Equal(t, 1, len(getResponseItFilterResponse.Results))
-	assert.Equal(t, user1Id, getResponseItFilterResponse.Results[0].Id)
-	assert.Equal(t, updatedFirstname, getResponseItFilterResponse.Results[0].Firstname)
-	assert.Equal(t, updatedLastname, getResponseItFilterResponse.Results[0].Lastname)
-	assert.Equal(t, updatedEmail, getResponseItFilterResponse.Results[0].Email)
-	assert.Equal(t, updatedNickname, getResponseItFilterResponse.Results[0].Nickname)
-	assert.Equal(t, countryIT, getResponseItFilterResponse.Results[0].Country)
-	// Get empty list with filter on language = EN
-	getRequestWithEnFilter := &api.GetUsersRequest{
-		FilterCountry: &country,
+	getResponseItFilter, getError := client.GetUsers(adminCtx, &api.GetUsersRequest{
+		FilterCountry: &updatedCountry,
 		Page:          0,
 		PageSize:      10,
-	}
-	getResponseWithFilterEN, getError := client.GetUsers(ctx, getRequestWithEnFilter)
+	})
 	if getError != nil {
-		t.Fatalf("Get GRPC call failed: %v", err)
-	}
-	assert.NotNil(t, getResponseWithFilterEN)
-	assert.Nil(t, getResponseWithFilterEN.Results)
-	// Get the updated user with no filter
-	getRequestWithNoFilterRequest := &api.GetUsersRequest{
+		t.Fatalf("Get with IT filter GRPC call failed: %v", getError)
+	}
+	assert.Equal(t, int64(10), getResponseItFilter.PageSize)
+	assert.Equal(t, 1, len(getResponseItFilter.Results))
+	assert.Equal(t, user1Id, getResponseItFilter.Results[0].Id)
+	assert.Equal(t, updatedFirstname, getResponseItFilter.Results[0].Firstname)
+	assert.Equal(t, updatedLastname, getResponseItFilter.Results[0].Lastname)
+	assert.Equal(t, updatedEmail, getResponseItFilter.Results[0].Email)
+	assert.Equal(t, updatedNickname, getResponseItFilter.Results[0].Nickname)
+	assert.Equal(t, updatedCountry, getResponseItFilter.Results[0].Country)
+	// Get the updated user with no filter, the admin account is listed too
+	getResponseNoFilter, getError := client.GetUsers(adminCtx, &api.GetUsersRequest{
 		Page:     0,
 		PageSize: 10,
+	})
+	if getError != nil {
+		t.Fatalf("Get with no filter GRPC call failed: %v", getError)
 	}
-	getResponseNoFilter, getErrorItFilter := client.GetUsers(ctx, getRequestWithNoFilterRequest)
-	if getErrorItFilter != nil {
-		t.Fatalf("Get with IT filter GRPC call failed: %v", err)
-	}
-	assert.NotNil(t, getResponseNoFilter)
 	assert.Equal(t, int64(10), getResponseNoFilter.PageSize)
-	assert.Equal(t, 1, len(getResponseNoFilter.Results))
-	assert.Equal(t, user1Id, getResponseNoFilter.Results[0].Id)
-	assert.Equal(t, updatedFirstname, getResponseNoFilter.Results[0].Firstname)
-	assert.Equal(t, updatedLastname, getResponseNoFilter.Results[0].Lastname)
-	assert.Equal(t, updatedEmail, getResponseNoFilter.Results[0].Email)
-	assert.Equal(t, updatedNickname, getResponseNoFilter.Results[0].Nickname)
-	assert.Equal(t, countryIT, getResponseNoFilter.Results[0].Country)
+	assert.Equal(t, 2, len(getResponseNoFilter.Results))
+	var unfilteredUser *api.User
+	for _, user := range getResponseNoFilter.Results {
+		if user.Id == user1Id {
+			unfilteredUser = user
+		} else {
+			assert.Equal(t, adminEmail, user.Email)
+		}
+	}
+	if assert.NotNil(t, unfilteredUser) {
+		assert.Equal(t, updatedFirstname, unfilteredUser.Firstname)
+		assert.Equal(t, updatedLastname, unfilteredUser.Lastname)
+		assert.Equal(t, updatedEmail, unfilteredUser.Email)
+		assert.Equal(t, updatedNickname, unfilteredUser.Nickname)
+		assert.Equal(t, updatedCountry, unfilteredUser.Country)
+	}
+	// Get empty list with filter on language = EN
+	getResponseEnFilter, getError := client.GetUsers(adminCtx, getRequest)
+	if getError != nil {
+		t.Fatalf("Get GRPC call failed: %v", getError)
+	}
+	assert.Nil(t, getResponseEnFilter.Results)
+	// Rotate the refresh token, the old one cannot be used again
+	refreshResponse, err := client.RefreshToken(ctx, &api.RefreshTokenRequest{RefreshToken: updatedAuthResponse.Tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("Refresh GRPC call failed: %v", err)
+	}
+	assert.NotEqual(t, updatedAuthResponse.Tokens.RefreshToken, refreshResponse.Tokens.RefreshToken)
+	_, err = client.RefreshToken(ctx, &api.RefreshTokenRequest{RefreshToken: updatedAuthResponse.Tokens.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
 	// Delete the user
 	deleteRequest := &api.DeleteUserRequest{
 		Id: user1Id,
 	}
-	deleteResponse, deleteErr := client.DeleteUser(ctx, deleteRequest)
+	deleteResponse, deleteErr := client.DeleteUser(WithBearerToken(ctx, refreshResponse.Tokens.AccessToken), deleteRequest)
 	if deleteErr != nil {
-		t.Fatalf("Delete GRPC call failed: %v", err)
+		t.Fatalf("Delete GRPC call failed: %v", deleteErr)
 	}
 	assert.NotNil(t, deleteResponse)
 	// Get users empty after delete
-	getResponseAfter, getError := client.GetUsers(ctx, getRequest)
+	getResponseAfter, getError := client.GetUsers(adminCtx, &api.GetUsersRequest{
+		FilterCountry: &updatedCountry,
+		Page:          0,
+		PageSize:      10,
+	})
 	if getError != nil {
-		t.Fatalf("Get GRPC call failed: %v", err)
+		t.Fatalf("Get GRPC call failed: %v", getError)
 	}
-	assert.NotNil(t, getResponseAfter)
 	assert.Nil(t, getResponseAfter.Results)
+	getResponseNoFilterAfter, getError := client.GetUsers(adminCtx, &api.GetUsersRequest{
+		Page:     0,
+		PageSize: 10,
+	})
+	if getError != nil {
+		t.Fatalf("Get GRPC call failed: %v", getError)
+	}
+	for _, user := range getResponseNoFilterAfter.Results {
+		assert.NotEqual(t, user1Id, user.Id)
+	}
+	_, err = client.GetUser(adminCtx, &api.GetUserRequest{Id: user1Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	// User cannot authenticate after delete
+	_, err = client.Authenticate(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Nickname{Nickname: updatedNickname},
+		Password: updatedPassword,
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// The deleted user can be restored during the grace period
+	restoredUser, err := client.RestoreUser(adminCtx, &api.RestoreUserRequest{Id: user1Id})
+	if err != nil {
+		t.Fatalf("Restore GRPC call failed: %v", err)
+	}
+	assert.Equal(t, user1Id, restoredUser.Id)
+	assert.Nil(t, restoredUser.DeleteTime)
+	// The forced delete purges the user, so the email and the nickname can be used again
+	_, err = client.DeleteUser(adminCtx, &api.DeleteUserRequest{Id: user1Id, Force: true})
+	if err != nil {
+		t.Fatalf("Delete GRPC call failed: %v", err)
+	}
+	_, err = client.RestoreUser(adminCtx, &api.RestoreUserRequest{Id: user1Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
 }
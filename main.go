@@ -2,21 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 	"user/service"
 	"user/service/api"
 	"user/service/config"
+	"user/service/model"
 	"user/service/producer"
 	"user/service/repository"
 	"user/service/utility"
@@ -36,82 +46,420 @@ func init() {
 	}
 }
 
-//InitializeService create a new instance of server service
-func InitializeService(cfg config.Config, ctx context.Context) (*service.Service, error) {
+//InitializeService create a new instance of server service, the returned function stops the background components on shutdown.
+//The dependencies are awaited until STARTUP_TIMEOUT or until ctx is done, then the health checker reports them
+func InitializeService(cfg config.Config, ctx context.Context, healthChecker *service.HealthChecker) (*service.Service, func(), error) {
 	log.Info("Initializing user service")
-	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	startupCtx, cancel := context.WithTimeout(ctx, cfg.StartupTimeout)
 	defer cancel()
-	utility := utility.New(cfg.SecretKey)
-	mongoClient, _ := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoHost))
-	err := mongoClient.Ping(ctx, readpref.Primary())
+	startup := service.StartupConfig{
+		AttemptTimeout: cfg.StartupAttemptTimeout,
+		MinBackoff:     cfg.StartupMinBackoff,
+		MaxBackoff:     cfg.StartupMaxBackoff,
+	}
+	hasher, err := newHasher(cfg)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	tokenConfig, err := loadTokenConfig(cfg)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	repo, err := newStorage(startupCtx, cfg, hasher, startup)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeStorage := func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := repo.Close(closeCtx); err != nil {
+			log.Error("Error while closing the ", cfg.Storage, " storage ", err)
+		}
+	}
+	tokenService := service.NewTokenService(repo, tokenConfig)
+	err = repo.SeedRoles(startupCtx, model.DefaultRoles)
+	if err != nil {
+		closeStorage()
+		return nil, nil, err
+	}
+	authorizer := service.NewAuthorizer(repo, cfg.RolesCacheTTL)
+	purger, err := service.NewPurger(repo, service.PurgeConfig{
+		GracePeriod: cfg.DeletionGracePeriod,
+		Interval:    cfg.PurgeInterval,
+		BatchSize:   cfg.PurgeBatchSize,
+	})
+	if err != nil {
+		closeStorage()
+		return nil, nil, err
+	}
+	s := service.New(repo, tokenService, authorizer)
+	s.DeletionGracePeriod = cfg.DeletionGracePeriod
+	s.HealthChecker = healthChecker
+	if cfg.AdminEmail != "" && cfg.AdminPassword != "" {
+		err = s.BootstrapAdmin(startupCtx, cfg.AdminEmail, cfg.AdminNickname, cfg.AdminPassword)
+		var duplicateError *model.DuplicateError
+		if errors.As(err, &duplicateError) {
+			// the service can still be managed by setting a different admin email or nickname
+			log.Error("Cannot create the admin user, the ", duplicateError.Field, " is used by another account")
+		} else if err != nil {
+			closeStorage()
+			return nil, nil, err
+		}
+	}
+	eventBus, err := producer.NewEventBus(producer.BusConfig{
+		Backend: cfg.EventBus,
+		Kafka: producer.Config{
+			BootstrapServers: cfg.KafkaServer,
+			Topic:            cfg.KafkaTopic,
+			Acks:             cfg.KafkaAcks,
+			Idempotence:      cfg.KafkaIdempotence,
+			Linger:           cfg.KafkaLinger,
+			BatchSize:        cfg.KafkaBatchSize,
+			MessageTimeout:   cfg.KafkaMessageTimeout,
+		},
+		FilePath: cfg.EventBusFile,
+	})
+	if err != nil {
+		log.Error("Failed to create event bus ", err)
+		closeStorage()
+		return nil, nil, err
+	}
+	if err = service.WaitFor(startupCtx, startup, cfg.EventBus, eventBus.Ping); err != nil {
+		eventBus.Close()
+		closeStorage()
+		return nil, nil, err
+	}
+	log.Info("Publishing events with the ", cfg.EventBus, " event bus")
+	relay, err := producer.NewRelay(repo, eventBus, producer.RelayConfig{
+		PollInterval: cfg.OutboxPollInterval,
+		BatchSize:    cfg.OutboxBatchSize,
+		MinBackoff:   cfg.OutboxMinBackoff,
+		MaxBackoff:   cfg.OutboxMaxBackoff,
+		Lease:        cfg.OutboxLease,
+		MaxAttempts:  cfg.OutboxMaxAttempts,
+	})
+	if err != nil {
+		eventBus.Close()
+		closeStorage()
+		return nil, nil, err
+	}
+	// the background components run until shutdown, ctx only bounds the initialization
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		relay.Run(relayCtx)
+		close(relayDone)
+	}()
+	purgerCtx, stopPurger := context.WithCancel(context.Background())
+	purgerDone := make(chan struct{})
+	go func() {
+		purger.Run(purgerCtx)
+		close(purgerDone)
+	}()
+	healthChecker.SetChecks(service.HealthCheck{Name: cfg.Storage, Check: repo.Ping}, service.HealthCheck{Name: cfg.EventBus, Check: eventBus.Ping})
+	healthCtx, stopHealthChecker := context.WithCancel(context.Background())
+	healthDone := make(chan struct{})
+	go func() {
+		healthChecker.Run(healthCtx)
+		close(healthDone)
+	}()
+	shutdown := func() {
+		stopHealthChecker()
+		<-healthDone
+		stopPurger()
+		<-purgerDone
+		stopRelay()
+		<-relayDone
+		// events not delivered in time stay in the outbox and are published again on next start
+		if pending := eventBus.Flush(cfg.KafkaFlushTimeout); pending > 0 {
+			log.Warn("Event bus closed with ", pending, " events not delivered")
+		}
+		eventBus.Close()
+		closeStorage()
+	}
+	log.Info("Created account service")
+	return s, shutdown, nil
+}
+
+// newHasher returns the password hasher configured for new hashes, legacy Hmac256 hashes are still verified and upgraded on next login
+func newHasher(cfg config.Config) (utility.PasswordHasher, error) {
+	argon2id, err := utility.NewArgon2idHasher(cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism)
 	if err != nil {
-		log.Fatal("Error while connecting to MongoDB instance")
 		return nil, err
 	}
-	userCollection := mongoClient.Database("users_collection").Collection("users")
-	// Clean db on start if DEV mode
-	if cfg.Mode == "DEV" {
-		err = userCollection.Drop(ctx)
+	bcrypt, err := utility.NewBcryptHasher(cfg.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+	return utility.NewHasher(cfg.PasswordHashAlgorithm, argon2id, bcrypt, utility.New(cfg.SecretKey))
+}
+
+// storage is the data layer of the service, implemented with MongoDB, PostgreSQL or in memory
+type storage interface {
+	service.RepositoryInterface
+	service.TokenRepositoryInterface
+	service.RoleRepositoryInterface
+	producer.OutboxInterface
+	SeedRoles(ctx context.Context, roles []model.Role) error
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// newStorage returns the data layer selected by the STORAGE setting, the database is retried until the context is done
+func newStorage(ctx context.Context, cfg config.Config, hasher utility.PasswordHasher, startup service.StartupConfig) (storage, error) {
+	switch cfg.Storage {
+	case repository.StorageMemory:
+		log.Warn("Storing the users in memory, they are lost on restart")
+		return repository.NewMemoryRepository(hasher), nil
+	case repository.StorageMongo:
+		var mongoClient *mongo.Client
+		err := service.WaitFor(ctx, startup, cfg.Storage, func(ctx context.Context) (err error) {
+			mongoClient, err = connectMongo(ctx, cfg)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		repo := repository.New(mongoClient, hasher)
+		if err = migrateStorage(ctx, cfg, repo); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case repository.StoragePostgres:
+		var db *sql.DB
+		err := service.WaitFor(ctx, startup, cfg.Storage, func(ctx context.Context) (err error) {
+			db, err = connectPostgres(ctx, cfg)
+			return err
+		})
 		if err != nil {
-			log.Error(err)
 			return nil, err
 		}
+		repo := repository.NewPostgresRepository(db, hasher)
+		if err = migrateStorage(ctx, cfg, repo); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %s", cfg.Storage)
 	}
-	repo := repository.New(mongoClient, utility)
-	broker, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": cfg.KafkaServer})
+}
+
+// migrateStorage applies the pending migrations if MIGRATE_ON_START is enabled, otherwise it fails if some are pending
+func migrateStorage(ctx context.Context, cfg config.Config, repo migrator) error {
+	if cfg.MigrateOnStart {
+		return repo.Migrate(ctx)
+	}
+	pending, err := pendingMigrations(ctx, repo)
 	if err != nil {
-		fmt.Println("Failed to create producer due to ", err)
-		os.Exit(1)
+		return err
 	}
-	kafkaProducer := producer.New(broker, cfg.KafkaTopic)
-	s := service.New(repo, kafkaProducer)
-	log.Info("Created account service")
-	return s, nil
+	if len(pending) > 0 {
+		return fmt.Errorf("%d migrations are pending, apply them with the migrate command", len(pending))
+	}
+	return nil
+}
+
+// connectMongo returns a client connected to the configured MongoDB instance
+func connectMongo(ctx context.Context, cfg config.Config) (*mongo.Client, error) {
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoHost))
+	if err != nil {
+		return nil, err
+	}
+	if err = mongoClient.Ping(ctx, readpref.Primary()); err != nil {
+		_ = mongoClient.Disconnect(context.Background())
+		return nil, err
+	}
+	return mongoClient, nil
+}
+
+// connectPostgres returns a connection pool to the configured PostgreSQL database
+func connectPostgres(ctx context.Context, cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.PostgresDSN)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// loadTokenConfig reads the signing keys from the configured files, in DEV mode a temporary key is generated if none is configured
+func loadTokenConfig(cfg config.Config) (service.TokenConfig, error) {
+	tokenConfig := service.TokenConfig{
+		Issuer:          cfg.TokenIssuer,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+	}
+	if cfg.SigningKeyFile == "" {
+		if cfg.Mode != "DEV" {
+			return tokenConfig, errors.New("SIGNING_KEY_FILE is required outside DEV mode")
+		}
+		log.Warn("No signing key configured, generating a temporary one")
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return tokenConfig, err
+		}
+		tokenConfig.SigningKey = service.SigningKey{ID: "dev", PrivateKey: privateKey}
+		return tokenConfig, nil
+	}
+	signingKey, err := readSigningKey(cfg.SigningKeyID, cfg.SigningKeyFile)
+	if err != nil {
+		return tokenConfig, err
+	}
+	tokenConfig.SigningKey = signingKey
+	if cfg.PreviousSigningKeyFile != "" {
+		previousKey, err := readSigningKey(cfg.PreviousSigningKeyID, cfg.PreviousSigningKeyFile)
+		if err != nil {
+			return tokenConfig, err
+		}
+		tokenConfig.PreviousKeys = append(tokenConfig.PreviousKeys, previousKey)
+	}
+	return tokenConfig, nil
+}
+
+func readSigningKey(id string, file string) (service.SigningKey, error) {
+	if id == "" {
+		return service.SigningKey{}, fmt.Errorf("missing key id for signing key %s", file)
+	}
+	pemData, err := os.ReadFile(file)
+	if err != nil {
+		return service.SigningKey{}, err
+	}
+	return service.ParseSigningKey(id, pemData)
 }
 
 // runServer runs gRPC server and HTTP gateway
 func runServer() error {
 	log.Info("Starting gRPC server")
-	ctx := context.Background()
+	// the signals are handled from the start, so that they also cancel the wait for the dependencies
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 	cfg := config.New(ctx)
-	if cfg.Mode == "DEV" {
-		log.Info("Waiting for Kafka instance to be ready...")
-		time.Sleep(18 * time.Second)
+	healthChecker, err := service.NewHealthChecker(service.HealthConfig{
+		Interval: cfg.HealthCheckInterval,
+		Timeout:  cfg.HealthCheckTimeout,
+	})
+	if err != nil {
+		return err
 	}
-	s, err := InitializeService(*cfg, ctx)
+	s, shutdown, err := initializeWithHealth(*cfg, ctx, healthChecker)
 	if err != nil {
+		if ctx.Err() != nil {
+			log.Info("Interrupted while waiting for the dependencies")
+			return nil
+		}
 		return err
 	}
+	defer shutdown()
+	go serveMetrics(cfg.MetricsPort)
 	listen, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.GrpcPort))
 	if err != nil {
 		return err
 	}
 
-	server := grpc.NewServer()
+	authInterceptor := service.NewAuthInterceptor(s.TokenService, s.Authorizer)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(authInterceptor.Unary()),
+		grpc.StreamInterceptor(authInterceptor.Stream()),
+	)
 
 	api.RegisterUserServiceServer(server, s)
+	healthpb.RegisterHealthServer(server, s.HealthChecker)
 
-	// graceful shutdown
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// the REST gateway calls the gRPC server, so that the requests go through the interceptors
+	gatewayCtx, stopGateway := context.WithCancel(context.Background())
+	defer stopGateway()
+	gateway, err := service.NewGateway(gatewayCtx, fmt.Sprintf("localhost:%s", cfg.GrpcPort))
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%s", cfg.HttpPort),
+		Handler:           gateway,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go serveGateway(httpServer)
+
+	// start gRPC server
+	log.Printf("starting gRPC server on port %s...\n", cfg.GrpcPort)
+	served := make(chan error, 1)
 	go func() {
-		for range c {
-			// sig is a ^C, handle it
-			log.Info("shutting down gRPC server...")
+		served <- server.Serve(listen)
+	}()
+	select {
+	case err = <-served:
+		log.Error("gRPC server stopped ", err)
+	case <-ctx.Done():
+		log.Info("Received termination signal, shutting down gRPC server...")
+	}
+	// a second signal kills the service without waiting for the shutdown
+	stopSignals()
 
-			server.GracefulStop()
+	// graceful shutdown, the load balancers stop sending requests while the in-flight ones are completed, then the
+	// deferred shutdown flushes the event bus and closes the storage
+	s.HealthChecker.Shutdown()
+	stopCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(stopCtx); err != nil {
+		log.Error("Error while shutting down the HTTP gateway ", err)
+	}
+	stopGateway()
+	if !service.StopServer(stopCtx, server) && err == nil {
+		err = errors.New("in-flight requests cancelled on shutdown")
+	}
+	return err
+}
 
-			<-ctx.Done()
-		}
+// initializeWithHealth initializes the service while a gRPC server with only the health api runs on the gRPC port, so
+// that the probes see the service live but not ready while the dependencies are awaited
+func initializeWithHealth(cfg config.Config, ctx context.Context, healthChecker *service.HealthChecker) (*service.Service, func(), error) {
+	listen, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.GrpcPort))
+	if err != nil {
+		return nil, nil, err
+	}
+	startupServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(startupServer, healthChecker)
+	go func() {
+		_ = startupServer.Serve(listen)
 	}()
+	// the port is released for the service server, the probes retry meanwhile
+	defer startupServer.Stop()
+	return InitializeService(cfg, ctx, healthChecker)
+}
 
-	// start gRPC server
-	log.Printf("starting gRPC server on port %s...\n", cfg.GrpcPort)
-	return server.Serve(listen)
+// serveGateway serves the REST gateway until the server is shut down
+func serveGateway(server *http.Server) {
+	log.Info("starting HTTP gateway on port ", strings.TrimPrefix(server.Addr, ":"))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("HTTP gateway stopped ", err)
+	}
+}
+
+// serveMetrics exposes the Prometheus metrics on /metrics
+func serveMetrics(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Info("starting metrics server on port ", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%s", port), mux); err != nil {
+		log.Error("Metrics server stopped ", err)
+	}
 }
 
 func main() {
-	if err := runServer(); err != nil {
+	run := runServer
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "outbox":
+			run = func() error { return runOutboxCommand(os.Args[2:]) }
+		case "migrate":
+			run = func() error { return runMigrateCommand(os.Args[2:]) }
+		}
+	}
+	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,122 @@
+package main
+
+// This file implements the migrate subcommand, used to apply the schema migrations or list them without applying them
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"user/service/config"
+	"user/service/model"
+	"user/service/repository"
+)
+
+// migrator applies the versioned schema migrations of the persistent storages
+type migrator interface {
+	Migrate(ctx context.Context) error
+	MigrationStatus(ctx context.Context) ([]model.MigrationStatus, error)
+}
+
+// persistentStorage is the data layer inspected and migrated by the subcommands
+type persistentStorage interface {
+	migrator
+	outboxInspector
+}
+
+// runMigrateCommand applies the pending migrations and prints them, e.g.
+// user-service migrate -dry-run
+func runMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := flags.Bool("status", false, "list all the migrations with their application time, without applying them")
+	dryRun := flags.Bool("dry-run", false, "list the pending migrations without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	cfg := config.New(ctx)
+	repo, closeStorage, err := openPersistentStorage(ctx, *cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	if *status {
+		statuses, err := repo.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		return printMigrations(statuses)
+	}
+	pending, err := pendingMigrations(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+	if *dryRun {
+		fmt.Printf("Pending migrations: %d\n", len(pending))
+		return printMigrations(pending)
+	}
+	if err = repo.Migrate(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Applied migrations: %d\n", len(pending))
+	return printMigrations(pending)
+}
+
+// pendingMigrations returns the migrations not applied yet
+func pendingMigrations(ctx context.Context, repo migrator) ([]model.MigrationStatus, error) {
+	statuses, err := repo.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []model.MigrationStatus
+	for _, status := range statuses {
+		if status.Pending() {
+			pending = append(pending, status)
+		}
+	}
+	return pending, nil
+}
+
+func printMigrations(statuses []model.MigrationStatus) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if !status.Pending() {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return writer.Flush()
+}
+
+// openPersistentStorage connects to the mongo or postgres storage without migrating it, the returned function closes the connection
+func openPersistentStorage(ctx context.Context, cfg config.Config) (persistentStorage, func(), error) {
+	hasher, err := newHasher(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch cfg.Storage {
+	case repository.StorageMongo:
+		mongoClient, err := connectMongo(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repository.New(mongoClient, hasher), func() { _ = mongoClient.Disconnect(context.Background()) }, nil
+	case repository.StoragePostgres:
+		db, err := connectPostgres(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repository.NewPostgresRepository(db, hasher), func() { _ = db.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("the command requires the %s or %s storage", repository.StorageMongo, repository.StoragePostgres)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// This file implements the outbox subcommand, used to inspect the events the relay cannot publish
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"user/service/config"
+	"user/service/model"
+)
+
+// outboxInspector reads the outbox of the persistent storages
+type outboxInspector interface {
+	GetOutboxStats(ctx context.Context) (*model.OutboxStats, error)
+	ListStuckEvents(ctx context.Context, minAttempts int, limit int) ([]model.OutboxEntry, error)
+}
+
+// runOutboxCommand prints the outbox entries, pending or parked, that failed at least the given number of attempts, e.g.
+// user-service outbox -min-attempts 3 -limit 20
+func runOutboxCommand(args []string) error {
+	flags := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	minAttempts := flags.Int("min-attempts", 1, "minimum number of failed attempts of the listed entries")
+	limit := flags.Int("limit", 50, "maximum number of listed entries")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cfg := config.New(ctx)
+	repo, closeStorage, err := openPersistentStorage(ctx, *cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	stats, err := repo.GetOutboxStats(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pending events: %d\n", stats.Pending)
+	fmt.Printf("Parked events: %d\n", stats.Parked)
+	if stats.Pending > 0 {
+		fmt.Printf("Oldest pending event created at: %s\n", stats.OldestCreatedAt.Format(time.RFC3339))
+	}
+	entries, err := repo.ListStuckEvents(ctx, *minAttempts, *limit)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "EVENT ID\tUSER ID\tTYPE\tCREATED AT\tATTEMPTS\tNEXT ATTEMPT AT\tPARKED AT\tLAST ERROR")
+	for _, entry := range entries {
+		parkedAt := "-"
+		if entry.ParkedAt != nil {
+			parkedAt = entry.ParkedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", entry.ID, entry.UserID, entry.MessageType,
+			entry.CreatedAt.Format(time.RFC3339), entry.Attempts, entry.NextAttemptAt.Format(time.RFC3339), parkedAt, entry.LastError)
+	}
+	return writer.Flush()
+}
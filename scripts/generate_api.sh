@@ -2,3 +2,9 @@
 cd ..
 ## protobuf
 protoc --proto_path=api/v1 --proto_path=api/v1/third_party --go_out=plugins=grpc,paths=source_relative:service/api user_service.proto
+## events published on the event bus, no services
+protoc --proto_path=api/v1 --proto_path=api/v1/third_party --go_out=paths=source_relative:service/api user_events.proto
+## REST gateway, requires protoc-gen-grpc-gateway v2
+protoc --proto_path=api/v1 --proto_path=api/v1/third_party --grpc-gateway_out=paths=source_relative:service/api user_service.proto
+## OpenAPI specification, requires protoc-gen-openapiv2 v2
+protoc --proto_path=api/v1 --proto_path=api/v1/third_party --openapiv2_out=json_names_for_fields=false,disable_default_errors=true:service/api user_service.proto
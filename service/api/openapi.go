@@ -0,0 +1,22 @@
+package api
+
+import (
+	_ "embed"
+)
+
+// OpenAPI is the OpenAPI v2 specification of the REST gateway, generated from user_service.proto with protoc-gen-openapiv2
+//
+//go:embed user_service.swagger.json
+var OpenAPI []byte
+
+// DocsPage is the HTML page rendering the OpenAPI specification, that it loads from openapi.json
+//
+//go:embed docs.html
+var DocsPage []byte
+
+// DocsSecurityPolicy is the Content-Security-Policy of DocsPage: it runs only the pinned Redoc bundle of the page, which
+// renders with inline styles and a search worker, and it fetches only the specification served next to the page
+const DocsSecurityPolicy = "default-src 'none'; " +
+	"script-src https://cdn.jsdelivr.net/npm/redoc@2.0.0/bundles/redoc.standalone.js; " +
+	"style-src 'unsafe-inline'; img-src data: https:; font-src https:; connect-src 'self'; worker-src blob:; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
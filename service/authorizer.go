@@ -0,0 +1,80 @@
+package service
+
+// This file implements the resolution of the permissions granted to the callers by their roles
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+	"user/service/model"
+)
+
+// RoleRepositoryInterface defines the operations exposed by the data layer regarding roles
+type RoleRepositoryInterface interface {
+	ListRoles(ctx context.Context) ([]model.Role, error)
+	GetRole(ctx context.Context, name string) (*model.Role, error)
+	PutRole(ctx context.Context, role *model.Role) error
+}
+
+// Authorizer checks the permissions of the callers. Role definitions are stored in the data layer
+// and cached for cacheTTL, so that changes to the roles are applied without restarting the service
+type Authorizer struct {
+	RoleRepositoryInterface RoleRepositoryInterface
+	cacheTTL                time.Duration
+	mutex                   sync.Mutex
+	roles                   map[string]model.Role
+	loadedAt                time.Time
+}
+
+// NewAuthorizer allows to create a new instance of the Authorizer
+func NewAuthorizer(repository RoleRepositoryInterface, cacheTTL time.Duration) *Authorizer {
+	return &Authorizer{RoleRepositoryInterface: repository, cacheTTL: cacheTTL}
+}
+
+// HasPermission returns true if any role of the identity grants the permission, users.admin grants every permission
+func (authorizer *Authorizer) HasPermission(ctx context.Context, identity *Identity, permission string) (bool, error) {
+	roles, err := authorizer.loadRoles(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, roleName := range identity.Roles {
+		role, ok := roles[roleName]
+		if !ok {
+			continue
+		}
+		for _, granted := range role.Permissions {
+			if granted == permission || granted == model.PermissionUsersAdmin {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Invalidate drops the cached role definitions, they are reloaded on next check
+func (authorizer *Authorizer) Invalidate() {
+	authorizer.mutex.Lock()
+	defer authorizer.mutex.Unlock()
+	authorizer.roles = nil
+}
+
+func (authorizer *Authorizer) loadRoles(ctx context.Context) (map[string]model.Role, error) {
+	authorizer.mutex.Lock()
+	defer authorizer.mutex.Unlock()
+	if authorizer.roles != nil && time.Since(authorizer.loadedAt) < authorizer.cacheTTL {
+		return authorizer.roles, nil
+	}
+	log.Debug("Loading role definitions")
+	roles, err := authorizer.RoleRepositoryInterface.ListRoles(ctx)
+	if err != nil {
+		log.Error("Failed to load role definitions ", err)
+		return nil, err
+	}
+	authorizer.roles = make(map[string]model.Role, len(roles))
+	for _, role := range roles {
+		authorizer.roles[role.Name] = role
+	}
+	authorizer.loadedAt = time.Now()
+	return authorizer.roles, nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"user/service/mocks"
+	"user/service/model"
+)
+
+// AUTHORIZER TESTS
+func TestAuthorizerHasPermissionOk(t *testing.T) {
+	repository := new(mocks.RoleRepositoryInterface)
+	roles := append(model.DefaultRoles, model.Role{Name: "support", Permissions: []string{model.PermissionUsersRead}})
+	repository.On("ListRoles", ctx).Return(roles, nil)
+	authorizer := NewAuthorizer(repository, time.Minute)
+	// run test and validate
+	admin := &Identity{UserID: "admin", Roles: []string{model.UserRole, model.AdminRole}}
+	support := &Identity{UserID: "support", Roles: []string{model.UserRole, "support"}}
+	user := &Identity{UserID: "user", Roles: []string{model.UserRole, "unknown"}}
+	assertPermission(t, authorizer, admin, model.PermissionUsersDelete, true)
+	assertPermission(t, authorizer, support, model.PermissionUsersRead, true)
+	assertPermission(t, authorizer, support, model.PermissionUsersWrite, false)
+	assertPermission(t, authorizer, user, model.PermissionUsersRead, false)
+	// role definitions are loaded once
+	repository.AssertNumberOfCalls(t, "ListRoles", 1)
+}
+
+func TestAuthorizerInvalidateOk(t *testing.T) {
+	repository := new(mocks.RoleRepositoryInterface)
+	repository.On("ListRoles", ctx).Return(model.DefaultRoles, nil).Once()
+	repository.On("ListRoles", ctx).Return([]model.Role{{Name: model.UserRole, Permissions: []string{model.PermissionUsersRead}}}, nil).Once()
+	authorizer := NewAuthorizer(repository, time.Minute)
+	user := &Identity{UserID: "user", Roles: []string{model.UserRole}}
+	// run test and validate
+	assertPermission(t, authorizer, user, model.PermissionUsersRead, false)
+	authorizer.Invalidate()
+	assertPermission(t, authorizer, user, model.PermissionUsersRead, true)
+}
+
+func assertPermission(t *testing.T, authorizer *Authorizer, identity *Identity, permission string, expected bool) {
+	allowed, err := authorizer.HasPermission(ctx, identity, permission)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, expected, allowed)
+}
@@ -0,0 +1,100 @@
+package service
+
+// This file builds the domain events published by the service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"time"
+	"user/service/api"
+	"user/service/model"
+)
+
+// newEventMetadata returns the metadata of a new event, the actor is the caller identity if any
+func newEventMetadata(ctx context.Context) *api.EventMetadata {
+	metadata := &api.EventMetadata{
+		EventId:       uuid.New().String(),
+		OccurredAt:    timestamppb.Now(),
+		SchemaVersion: model.EventSchemaVersion,
+	}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		metadata.Actor = identity.UserID
+	}
+	return metadata
+}
+
+func newUserCreated(ctx context.Context, user *model.User) *api.UserCreated {
+	return &api.UserCreated{
+		Metadata: newEventMetadata(ctx),
+		UserId:   user.ID,
+		User:     toGrpcUser(user),
+	}
+}
+
+func newUserUpdated(ctx context.Context, before *model.User, after *model.User) *api.UserUpdated {
+	return &api.UserUpdated{
+		Metadata:      newEventMetadata(ctx),
+		UserId:        after.ID,
+		Before:        toGrpcUser(before),
+		After:         toGrpcUser(after),
+		ChangedFields: changedFields(before, after),
+	}
+}
+
+// newUserDeleted returns the event of a user that is purged at purgeTime, right away if purgeTime is zero
+func newUserDeleted(ctx context.Context, user *model.User, purgeTime time.Time) *api.UserDeleted {
+	event := &api.UserDeleted{
+		Metadata: newEventMetadata(ctx),
+		UserId:   user.ID,
+		User:     toGrpcUser(user),
+	}
+	if !purgeTime.IsZero() {
+		event.PurgeTime = timestamppb.New(purgeTime)
+	}
+	return event
+}
+
+func newUserRestored(ctx context.Context, user *model.User) *api.UserRestored {
+	return &api.UserRestored{
+		Metadata: newEventMetadata(ctx),
+		UserId:   user.ID,
+		User:     toGrpcUser(user),
+	}
+}
+
+func newUserPurged(ctx context.Context, user *model.User) *api.UserPurged {
+	return &api.UserPurged{
+		Metadata: newEventMetadata(ctx),
+		UserId:   user.ID,
+		User:     toGrpcUser(user),
+	}
+}
+
+// changedFields returns the names of the editable fields that differ between the two users
+func changedFields(before *model.User, after *model.User) []string {
+	var fields []string
+	if before.Firstname != after.Firstname {
+		fields = append(fields, "firstname")
+	}
+	if before.Lastname != after.Lastname {
+		fields = append(fields, "lastname")
+	}
+	if before.Nickname != after.Nickname {
+		fields = append(fields, "nickname")
+	}
+	if before.Email != after.Email {
+		fields = append(fields, "email")
+	}
+	if before.Password != after.Password {
+		fields = append(fields, "password")
+	}
+	if before.Country != after.Country {
+		fields = append(fields, "country")
+	}
+	if strings.Join(before.Roles, ",") != strings.Join(after.Roles, ",") {
+		fields = append(fields, "roles")
+	}
+	return fields
+}
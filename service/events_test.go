@@ -0,0 +1,45 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"user/service/model"
+)
+
+// EVENTS TESTS
+func TestEventsChangedFieldsOk(t *testing.T) {
+	before := createDecodedUsers()[0]
+	after := before
+	after.Email = "new@email.com"
+	after.Password = "new_password_hash"
+	after.UpdatedAt = creationDate.Add(time.Hour)
+	// run test and validate
+	event := newUserUpdated(ctx, &before, &after)
+	assert.Equal(t, []string{"email", "password"}, event.ChangedFields)
+	assert.Equal(t, before.Email, event.Before.Email)
+	assert.Equal(t, after.Email, event.After.Email)
+	assert.Equal(t, before.ID, event.UserId)
+}
+
+func TestEventsChangedRolesOk(t *testing.T) {
+	before := createDecodedUsers()[0]
+	after := before
+	after.Roles = append([]string{model.AdminRole}, before.Roles...)
+	// run test and validate
+	event := newUserUpdated(ctx, &before, &after)
+	assert.Equal(t, []string{"roles"}, event.ChangedFields)
+}
+
+func TestEventsMetadataActorOk(t *testing.T) {
+	user := &model.User{ID: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	callCtx := ContextWithIdentity(ctx, &Identity{UserID: "3bacc2e9-089a-4c27-b662-d3826b68173b"})
+	// run test and validate
+	event := newUserDeleted(callCtx, user, time.Time{})
+	assert.Equal(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", event.Metadata.Actor)
+	assert.Equal(t, int32(model.EventSchemaVersion), event.Metadata.SchemaVersion)
+	assert.NotEmpty(t, event.Metadata.EventId)
+	assert.NotNil(t, event.Metadata.OccurredAt)
+	// anonymous calls have no actor
+	assert.Empty(t, newUserCreated(ctx, user).Metadata.Actor)
+}
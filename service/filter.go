@@ -0,0 +1,420 @@
+package service
+
+// This file implements the parser of the filter expressions, in the AIP-160 syntax, and of the order clauses of GetUsers.
+// They are compiled to the model.UserFilter and model.UserOrder understood by every data layer, e.g.
+// email = "*@example.com" AND (roles:admin OR created_at >= "2023-01-01T00:00:00Z")
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"user/service/api"
+	"user/service/model"
+)
+
+const (
+	// maxFilterLength and maxFilterDepth limit the size of the filters, so that parsing and querying them stay cheap
+	maxFilterLength = 2000
+	maxFilterDepth  = 20
+)
+
+// filterError is returned when a filter or an order clause is not valid, its message is returned to the client
+type filterError struct {
+	message string
+}
+
+func (err *filterError) Error() string {
+	return err.message
+}
+
+func newFilterError(format string, args ...interface{}) error {
+	return &filterError{message: fmt.Sprintf(format, args...)}
+}
+
+// fieldKind is the type of the values a field is compared with
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	countryField
+	dateField
+	repeatedField
+	statusField
+)
+
+// userField describes a field of the users that can be filtered, sortable fields are indexed by every data layer
+type userField struct {
+	name     string
+	kind     fieldKind
+	sortable bool
+	// unique fields make the order of the users stable
+	unique bool
+	// normalized fields are compared with the normalized value, see model.Normalize
+	normalized bool
+}
+
+// userFields are the fields of the filters and of the order clauses, the dates are available with the names of the
+// deprecated and of the current fields of api.User
+var userFields = map[string]userField{
+	"id":          {name: model.UserFieldID, kind: stringField, sortable: true, unique: true},
+	"firstname":   {name: model.UserFieldFirstname, kind: stringField},
+	"lastname":    {name: model.UserFieldLastname, kind: stringField},
+	"nickname":    {name: model.UserFieldNickname, kind: stringField, sortable: true, unique: true, normalized: true},
+	"email":       {name: model.UserFieldEmail, kind: stringField, sortable: true, unique: true, normalized: true},
+	"country":     {name: model.UserFieldCountry, kind: countryField, sortable: true},
+	"created_at":  {name: model.UserFieldCreatedAt, kind: dateField, sortable: true},
+	"create_time": {name: model.UserFieldCreatedAt, kind: dateField, sortable: true},
+	"updated_at":  {name: model.UserFieldUpdatedAt, kind: dateField},
+	"update_time": {name: model.UserFieldUpdatedAt, kind: dateField},
+	"roles":       {name: model.UserFieldRoles, kind: repeatedField},
+	"status":      {name: model.UserFieldStatus, kind: statusField},
+}
+
+// filterParser is a recursive descent parser of the AIP-160 grammar, OR binds tighter than AND:
+//
+//	expression := sequence { "AND" sequence }
+//	sequence   := factor { factor }
+//	factor     := term { "OR" term }
+//	term       := [ "NOT" | "-" ] simple
+//	simple     := "(" expression ")" | field comparator value
+type filterParser struct {
+	input string
+	pos   int
+	depth int
+}
+
+// parseUserFilter returns the filter of the expression, nil if it is empty
+func parseUserFilter(expression string) (*model.UserFilter, error) {
+	if len(expression) > maxFilterLength {
+		return nil, newFilterError("filter is longer than %d characters", maxFilterLength)
+	}
+	parser := &filterParser{input: expression}
+	if parser.skipSpaces(); parser.end() {
+		return nil, nil
+	}
+	filter, err := parser.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if parser.skipSpaces(); !parser.end() {
+		return nil, newFilterError("unexpected %q at position %d", parser.input[parser.pos:], parser.pos)
+	}
+	return &filter, nil
+}
+
+func (parser *filterParser) parseExpression() (model.UserFilter, error) {
+	filters, err := parser.parseList(parser.parseSequence, "AND")
+	if err != nil {
+		return model.UserFilter{}, err
+	}
+	return combineFilters(model.FilterAnd, filters), nil
+}
+
+// parseSequence parses the factors separated by spaces, they must all match like if they were separated by AND
+func (parser *filterParser) parseSequence() (model.UserFilter, error) {
+	var filters []model.UserFilter
+	for {
+		filter, err := parser.parseFactor()
+		if err != nil {
+			return model.UserFilter{}, err
+		}
+		filters = append(filters, filter)
+		if parser.skipSpaces(); parser.end() || parser.peek() == ')' || parser.peekKeyword("AND") {
+			return combineFilters(model.FilterAnd, filters), nil
+		}
+	}
+}
+
+func (parser *filterParser) parseFactor() (model.UserFilter, error) {
+	filters, err := parser.parseList(parser.parseTerm, "OR")
+	if err != nil {
+		return model.UserFilter{}, err
+	}
+	return combineFilters(model.FilterOr, filters), nil
+}
+
+// parseList parses the elements separated by the keyword
+func (parser *filterParser) parseList(parseElement func() (model.UserFilter, error), keyword string) ([]model.UserFilter, error) {
+	var filters []model.UserFilter
+	for {
+		filter, err := parseElement()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+		if parser.skipSpaces(); !parser.peekKeyword(keyword) {
+			return filters, nil
+		}
+		parser.pos += len(keyword)
+	}
+}
+
+func (parser *filterParser) parseTerm() (model.UserFilter, error) {
+	parser.skipSpaces()
+	negated := false
+	if parser.peekKeyword("NOT") {
+		parser.pos += len("NOT")
+		negated = true
+	} else if parser.peek() == '-' {
+		parser.pos++
+		negated = true
+	}
+	filter, err := parser.parseSimple()
+	if err != nil || !negated {
+		return filter, err
+	}
+	return model.UserFilter{Operator: model.FilterNot, Filters: []model.UserFilter{filter}}, nil
+}
+
+func (parser *filterParser) parseSimple() (model.UserFilter, error) {
+	if parser.skipSpaces(); parser.peek() != '(' {
+		return parser.parseComparison()
+	}
+	if parser.depth++; parser.depth > maxFilterDepth {
+		return model.UserFilter{}, newFilterError("filter is nested more than %d times", maxFilterDepth)
+	}
+	parser.pos++
+	filter, err := parser.parseExpression()
+	if err != nil {
+		return model.UserFilter{}, err
+	}
+	if parser.skipSpaces(); parser.peek() != ')' {
+		return model.UserFilter{}, newFilterError("missing ) at position %d", parser.pos)
+	}
+	parser.pos++
+	parser.depth--
+	return filter, nil
+}
+
+func (parser *filterParser) parseComparison() (model.UserFilter, error) {
+	start := parser.pos
+	for !parser.end() && (unicode.IsLetter(rune(parser.peek())) || parser.peek() == '_') {
+		parser.pos++
+	}
+	name := parser.input[start:parser.pos]
+	if name == "" {
+		return model.UserFilter{}, newFilterError("expected a field at position %d", parser.pos)
+	}
+	parser.skipSpaces()
+	var comparator string
+	for _, candidate := range []string{"<=", ">=", "!=", "<", ">", "=", ":"} {
+		if strings.HasPrefix(parser.input[parser.pos:], candidate) {
+			comparator = candidate
+			break
+		}
+	}
+	if comparator == "" {
+		return model.UserFilter{}, newFilterError("expected a comparator after %s at position %d", name, parser.pos)
+	}
+	parser.pos += len(comparator)
+	parser.skipSpaces()
+	value, err := parser.parseValue()
+	if err != nil {
+		return model.UserFilter{}, err
+	}
+	return newComparison(name, comparator, value)
+}
+
+// parseValue parses a string between double quotes, with \" and \\ escapes, or a text up to the next space or parenthesis
+func (parser *filterParser) parseValue() (string, error) {
+	if parser.peek() != '"' {
+		start := parser.pos
+		for !parser.end() && !unicode.IsSpace(rune(parser.peek())) && parser.peek() != '(' && parser.peek() != ')' {
+			parser.pos++
+		}
+		if start == parser.pos {
+			return "", newFilterError("expected a value at position %d", parser.pos)
+		}
+		return parser.input[start:parser.pos], nil
+	}
+	start := parser.pos
+	parser.pos++
+	var value strings.Builder
+	for !parser.end() {
+		char := parser.peek()
+		parser.pos++
+		switch {
+		case char == '"':
+			return value.String(), nil
+		case char == '\\' && !parser.end():
+			value.WriteByte(parser.peek())
+			parser.pos++
+		default:
+			value.WriteByte(char)
+		}
+	}
+	return "", newFilterError("unterminated string at position %d", start)
+}
+
+func (parser *filterParser) skipSpaces() {
+	for !parser.end() && unicode.IsSpace(rune(parser.peek())) {
+		parser.pos++
+	}
+}
+
+func (parser *filterParser) end() bool {
+	return parser.pos >= len(parser.input)
+}
+
+// peek returns the current character, 0 at the end of the input
+func (parser *filterParser) peek() byte {
+	if parser.end() {
+		return 0
+	}
+	return parser.input[parser.pos]
+}
+
+// peekKeyword reports whether the keyword is at the current position, followed by a space or a parenthesis
+func (parser *filterParser) peekKeyword(keyword string) bool {
+	if !strings.HasPrefix(parser.input[parser.pos:], keyword) {
+		return false
+	}
+	next := parser.pos + len(keyword)
+	return next < len(parser.input) && (unicode.IsSpace(rune(parser.input[next])) || parser.input[next] == '(')
+}
+
+// newComparison returns the comparison of the field with the value, checking that the field supports the comparator
+func newComparison(name string, comparator string, value string) (model.UserFilter, error) {
+	field, ok := userFields[name]
+	if !ok {
+		return model.UserFilter{}, newFilterError("field %s is not supported", name)
+	}
+	operator := model.FilterOperator(comparator)
+	unsupported := newFilterError("comparator %s is not supported for field %s", comparator, name)
+	switch field.kind {
+	case repeatedField:
+		if operator != model.FilterHas {
+			return model.UserFilter{}, unsupported
+		}
+		return model.UserFilter{Operator: operator, Field: field.name, Value: value}, nil
+	case dateField:
+		if operator == model.FilterHas {
+			return model.UserFilter{}, unsupported
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return model.UserFilter{}, newFilterError("%s is not a RFC 3339 date", value)
+		}
+		return model.UserFilter{Operator: operator, Field: field.name, Value: date.UTC()}, nil
+	case countryField:
+		if operator != model.FilterEqual && operator != model.FilterNotEqual {
+			return model.UserFilter{}, unsupported
+		}
+		if _, ok := api.Country_value[value]; !ok {
+			return model.UserFilter{}, newFilterError("country %s is not valid", value)
+		}
+		return model.UserFilter{Operator: operator, Field: field.name, Value: value}, nil
+	case statusField:
+		if operator != model.FilterEqual && operator != model.FilterNotEqual {
+			return model.UserFilter{}, unsupported
+		}
+		if value != model.UserStatusActive && value != model.UserStatusDeleted {
+			return model.UserFilter{}, newFilterError("status %s is not valid, use %s or %s", value, model.UserStatusActive, model.UserStatusDeleted)
+		}
+		return model.UserFilter{Operator: operator, Field: field.name, Value: value}, nil
+	default:
+		if operator != model.FilterEqual && operator != model.FilterNotEqual {
+			return model.UserFilter{}, unsupported
+		}
+		if field.normalized {
+			value = model.Normalize(value)
+		}
+		return newStringComparison(operator, field.name, value)
+	}
+}
+
+// newStringComparison returns the comparison of a string field, a leading or a trailing * matches any suffix or prefix
+func newStringComparison(operator model.FilterOperator, field string, value string) (model.UserFilter, error) {
+	if !strings.Contains(value, "*") {
+		return model.UserFilter{Operator: operator, Field: field, Value: value}, nil
+	}
+	if operator == model.FilterEqual && len(value) > 1 {
+		if trimmed := strings.TrimSuffix(value, "*"); !strings.Contains(trimmed, "*") {
+			return model.UserFilter{Operator: model.FilterPrefix, Field: field, Value: trimmed}, nil
+		}
+		if trimmed := strings.TrimPrefix(value, "*"); !strings.Contains(trimmed, "*") {
+			return model.UserFilter{Operator: model.FilterSuffix, Field: field, Value: trimmed}, nil
+		}
+	}
+	return model.UserFilter{}, newFilterError("only a leading or a trailing * is supported, with the = comparator")
+}
+
+// combineFilters returns the combination of the filters with the operator, the filter itself if there is only one
+func combineFilters(operator model.FilterOperator, filters []model.UserFilter) model.UserFilter {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return model.UserFilter{Operator: operator, Filters: filters}
+}
+
+// withCountryFilter returns the filter restricted to the users of the country, the filter itself if country is nil
+func withCountryFilter(filter *model.UserFilter, country *api.Country) *model.UserFilter {
+	if country == nil {
+		return filter
+	}
+	countryFilter := model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldCountry, Value: country.String()}
+	if filter == nil {
+		return &countryFilter
+	}
+	return &model.UserFilter{Operator: model.FilterAnd, Filters: []model.UserFilter{*filter, countryFilter}}
+}
+
+// filterReferences reports whether the filter, or any filter it combines, compares the field
+func filterReferences(filter *model.UserFilter, field string) bool {
+	if filter == nil {
+		return false
+	}
+	for i := range filter.Filters {
+		if filterReferences(&filter.Filters[i], field) {
+			return true
+		}
+	}
+	return filter.Field == field
+}
+
+// parseUserOrder returns the order of the comma separated fields, each one optionally followed by asc or desc.
+// The creation time and the id are added if no unique field is present, so that the order is stable
+func parseUserOrder(orderBy string) ([]model.UserOrder, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+	var order []model.UserOrder
+	present := make(map[string]bool)
+	unique := false
+	for _, clause := range strings.Split(orderBy, ",") {
+		words := strings.Fields(clause)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, newFilterError("order clause %q is not valid", strings.TrimSpace(clause))
+		}
+		field, ok := userFields[words[0]]
+		if !ok || !field.sortable {
+			return nil, newFilterError("cannot order by %s", words[0])
+		}
+		if present[field.name] {
+			return nil, newFilterError("field %s is ordered twice", words[0])
+		}
+		descending := false
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				descending = true
+			default:
+				return nil, newFilterError("order direction %s is not valid, use asc or desc", words[1])
+			}
+		}
+		present[field.name] = true
+		unique = unique || field.unique
+		order = append(order, model.UserOrder{Field: field.name, Descending: descending})
+	}
+	if !unique {
+		// the added fields follow the direction of the last one, so that the indexes can be read backwards
+		descending := order[len(order)-1].Descending
+		if !present[model.UserFieldCreatedAt] {
+			order = append(order, model.UserOrder{Field: model.UserFieldCreatedAt, Descending: descending})
+		}
+		order = append(order, model.UserOrder{Field: model.UserFieldID, Descending: descending})
+	}
+	return order, nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+	"user/service/model"
+)
+
+// FILTER TESTS
+func TestParseUserFilterOk(t *testing.T) {
+	date := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	email := model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldEmail, Value: "user@test.com"}
+	admin := model.UserFilter{Operator: model.FilterHas, Field: model.UserFieldRoles, Value: "admin"}
+	italian := model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldCountry, Value: "IT"}
+	filters := []struct {
+		expression string
+		expected   model.UserFilter
+	}{
+		{`email = " User@Test.com "`, email},
+		{"roles:admin", admin},
+		{"nickname = User*", model.UserFilter{Operator: model.FilterPrefix, Field: model.UserFieldNickname, Value: "user"}},
+		{`firstname = "*son"`, model.UserFilter{Operator: model.FilterSuffix, Field: model.UserFieldFirstname, Value: "son"}},
+		{"create_time >= 2023-01-02T16:04:05+01:00", model.UserFilter{Operator: model.FilterGreaterOrEqual, Field: model.UserFieldCreatedAt, Value: date}},
+		{"country != IT", model.UserFilter{Operator: model.FilterNotEqual, Field: model.UserFieldCountry, Value: "IT"}},
+		// OR binds tighter than AND and than the implicit AND of the sequences
+		{"roles:admin country = IT OR email = user@test.com", model.UserFilter{Operator: model.FilterAnd, Filters: []model.UserFilter{
+			admin, {Operator: model.FilterOr, Filters: []model.UserFilter{italian, email}},
+		}}},
+		{"NOT (roles:admin AND country = IT)", model.UserFilter{Operator: model.FilterNot, Filters: []model.UserFilter{
+			{Operator: model.FilterAnd, Filters: []model.UserFilter{admin, italian}},
+		}}},
+		{"-roles:admin", model.UserFilter{Operator: model.FilterNot, Filters: []model.UserFilter{admin}}},
+		{`lastname = "O\"Brien"`, model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldLastname, Value: `O"Brien`}},
+		{"status = deleted", model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldStatus, Value: model.UserStatusDeleted}},
+		{"status != active", model.UserFilter{Operator: model.FilterNotEqual, Field: model.UserFieldStatus, Value: model.UserStatusActive}},
+	}
+	for _, filter := range filters {
+		parsed, err := parseUserFilter(filter.expression)
+		if assert.NoError(t, err, filter.expression) {
+			assert.Equal(t, filter.expected, *parsed, filter.expression)
+		}
+	}
+	parsed, err := parseUserFilter("  ")
+	assert.NoError(t, err)
+	assert.Nil(t, parsed)
+}
+
+func TestParseUserFilterKo(t *testing.T) {
+	filters := []struct {
+		expression string
+		message    string
+	}{
+		{"password = secret", "field password is not supported"},
+		{"email < user@test.com", "comparator < is not supported for field email"},
+		{"country = XX", "country XX is not valid"},
+		{"roles = admin", "comparator = is not supported for field roles"},
+		{"status = banned", "status banned is not valid, use active or deleted"},
+		{"status:active", "comparator : is not supported for field status"},
+		{"created_at > yesterday", "yesterday is not a RFC 3339 date"},
+		{"email = *user*", "only a leading or a trailing * is supported, with the = comparator"},
+		{"email != user*", "only a leading or a trailing * is supported, with the = comparator"},
+		{"(roles:admin", "missing ) at position 12"},
+		{"roles:admin)", `unexpected ")" at position 11`},
+		{`email = "user`, "unterminated string at position 8"},
+		{"email", "expected a comparator after email at position 5"},
+		{"email =", "expected a value at position 7"},
+		{strings.Repeat("(", maxFilterDepth+1) + "roles:admin" + strings.Repeat(")", maxFilterDepth+1), "filter is nested more than 20 times"},
+		{strings.Repeat("a", maxFilterLength+1), "filter is longer than 2000 characters"},
+	}
+	for _, filter := range filters {
+		_, err := parseUserFilter(filter.expression)
+		if assert.Error(t, err, filter.expression) {
+			assert.Equal(t, filter.message, err.Error())
+		}
+	}
+}
+
+func TestParseUserOrderOk(t *testing.T) {
+	orders := []struct {
+		orderBy  string
+		expected []model.UserOrder
+	}{
+		{"", nil},
+		{"email", []model.UserOrder{{Field: model.UserFieldEmail}}},
+		{"nickname desc", []model.UserOrder{{Field: model.UserFieldNickname, Descending: true}}},
+		// the creation time and the id make the order stable, in the direction of the last field
+		{"country DESC", []model.UserOrder{
+			{Field: model.UserFieldCountry, Descending: true},
+			{Field: model.UserFieldCreatedAt, Descending: true},
+			{Field: model.UserFieldID, Descending: true},
+		}},
+		{"country, create_time desc", []model.UserOrder{
+			{Field: model.UserFieldCountry},
+			{Field: model.UserFieldCreatedAt, Descending: true},
+			{Field: model.UserFieldID, Descending: true},
+		}},
+	}
+	for _, order := range orders {
+		parsed, err := parseUserOrder(order.orderBy)
+		if assert.NoError(t, err, order.orderBy) {
+			assert.Equal(t, order.expected, parsed, order.orderBy)
+		}
+	}
+}
+
+func TestParseUserOrderKo(t *testing.T) {
+	orders := []struct {
+		orderBy string
+		message string
+	}{
+		{"firstname", "cannot order by firstname"},
+		{"email up", "order direction up is not valid, use asc or desc"},
+		{"email, created_at, create_time", "field create_time is ordered twice"},
+		{"email,", `order clause "" is not valid`},
+		{"email asc desc", `order clause "email asc desc" is not valid`},
+	}
+	for _, order := range orders {
+		_, err := parseUserOrder(order.orderBy)
+		if assert.Error(t, err, order.orderBy) {
+			assert.Equal(t, order.message, err.Error())
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+// This file implements the REST gateway serving the google.api.http routes declared in user_service.proto
+
+import (
+	"context"
+	"errors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"user/service/api"
+)
+
+// gatewayMarshalOptions encodes the responses and the error details with the proto field names, e.g. create_time
+var gatewayMarshalOptions = protojson.MarshalOptions{UseProtoNames: true}
+
+// NewGateway returns the HTTP handler translating the REST calls into calls to the gRPC server listening on endpoint,
+// so that they go through the same interceptors. It also serves the OpenAPI specification on /openapi.json and its
+// documentation page on /docs
+func NewGateway(ctx context.Context, endpoint string) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithErrorHandler(gatewayErrorHandler),
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   gatewayMarshalOptions,
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+	)
+	options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if err := api.RegisterUserServiceHandlerFromEndpoint(ctx, mux, endpoint, options); err != nil {
+		return nil, err
+	}
+	// the api documentation is public
+	if err := mux.HandlePath(http.MethodGet, "/openapi.json", serveContent("application/json", api.OpenAPI)); err != nil {
+		return nil, err
+	}
+	docs := serveContent("text/html; charset=utf-8", api.DocsPage)
+	if err := mux.HandlePath(http.MethodGet, "/docs", withSecurityPolicy(api.DocsSecurityPolicy, docs)); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// serveContent returns the handler writing the given embedded content
+func serveContent(contentType string, content []byte) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := w.Write(content); err != nil {
+			log.Debug("Cannot write the response ", err)
+		}
+	}
+}
+
+// withSecurityPolicy returns the handler restricting the resources loaded by the page to those allowed by the policy
+func withSecurityPolicy(policy string, handler runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		w.Header().Set("Content-Security-Policy", policy)
+		handler(w, r, pathParams)
+	}
+}
+
+// gatewayErrorHandler writes the gRPC errors as an ErrorResponse, with the HTTP status mapped from their code. The
+// details of the status, e.g. the field violations of an invalid request, are written with their @type
+func gatewayErrorHandler(_ context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, _ *http.Request, err error) {
+	httpStatus := 0
+	// routing errors, e.g. unknown paths, carry their own HTTP status
+	var statusError *runtime.HTTPStatusError
+	if errors.As(err, &statusError) {
+		httpStatus, err = statusError.HTTPStatus, statusError.Err
+	}
+	st := status.Convert(err)
+	if httpStatus == 0 {
+		httpStatus = runtime.HTTPStatusFromCode(st.Code())
+	}
+	body := &api.ErrorResponse{Error: &api.ErrorResponse_Error{
+		Code:    int32(httpStatus),
+		Message: st.Message(),
+		Status:  code.Code(st.Code()).String(),
+		Details: st.Proto().Details,
+	}}
+	data, err := gatewayMarshalOptions.Marshal(body)
+	if err != nil {
+		// the details whose type is unknown cannot be encoded
+		log.Warn("Cannot encode the error details ", err)
+		body.Error.Details = nil
+		data, err = gatewayMarshalOptions.Marshal(body)
+	}
+	if err != nil {
+		log.Error("Cannot encode the error ", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Del("Trailer")
+	w.Header().Set("Content-Type", "application/json")
+	if st.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.WriteHeader(httpStatus)
+	if _, err = w.Write(data); err != nil {
+		log.Debug("Cannot write the error response ", err)
+	}
+}
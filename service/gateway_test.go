@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"user/service/api"
+	"user/service/model"
+)
+
+// setupGateway serves the Service with the auth interceptor on a local gRPC server and returns the REST gateway in front of it
+func setupGateway(t *testing.T) (*serviceMocks, *httptest.Server) {
+	mockServices, testingService := setupService()
+	interceptor := NewAuthInterceptor(testingService.TokenService, testingService.Authorizer)
+	return mockServices, serveGateway(t, testingService, grpc.UnaryInterceptor(interceptor.Unary()))
+}
+
+// serveGateway serves the UserService on a local gRPC server with the given options and returns the REST gateway in front of it
+func serveGateway(t *testing.T, service api.UserServiceServer, options ...grpc.ServerOption) *httptest.Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(options...)
+	api.RegisterUserServiceServer(server, service)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	gatewayCtx, cancel := context.WithCancel(context.Background())
+	gateway, err := NewGateway(gatewayCtx, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(gateway)
+	t.Cleanup(func() {
+		httpServer.Close()
+		cancel()
+		server.Stop()
+	})
+	return httpServer
+}
+
+// callGateway sends the REST request with the given access token, an empty token calls the api anonymously
+func callGateway(t *testing.T, server *httptest.Server, method string, path string, body string, token string) (*http.Response, map[string]interface{}) {
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Invalid JSON body %s: %v", data, err)
+	}
+	return response, decoded
+}
+
+// bearerToken returns the authorization header of an access token for the given user and roles
+func bearerToken(t *testing.T, userID string, roles ...string) string {
+	md, _ := metadata.FromIncomingContext(contextWithToken(t, userID, roles...))
+	return md.Get("authorization")[0]
+}
+
+// GATEWAY TESTS
+func TestGatewayGetUserOk(t *testing.T) {
+	existingUser := createDecodedUsers()[0]
+	mockServices, server := setupGateway(t)
+	mockServices.RepositoryInterface.On("GetUser", mock.Anything, mock.MatchedBy(func(request *api.GetUserRequest) bool {
+		return request.Id == existingUser.ID
+	})).Return(&existingUser, nil)
+	// run test and validate
+	response, body := callGateway(t, server, http.MethodGet, "/api/v1/users/"+existingUser.ID, "", bearerToken(t, existingUser.ID))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, existingUser.ID, body["id"])
+	assert.Equal(t, existingUser.Email, body["email"])
+	// the fields use the proto names
+	assert.Contains(t, body, "create_time")
+}
+
+func TestGatewayDeleteUserForceOk(t *testing.T) {
+	userID := "1b8b24f8-a56b-4665-88f2-44e144389ce0"
+	mockServices, server := setupGateway(t)
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(model.DefaultRoles, nil)
+	mockServices.RepositoryInterface.On("PurgeUser", mock.Anything, userID).Return(&model.User{ID: userID}, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", mock.Anything, mock.Anything).Return(nil)
+	// run test and validate, the DELETE has no body and force is a query parameter
+	response, body := callGateway(t, server, http.MethodDelete, "/api/v1/users/"+userID+"?force=true", "",
+		bearerToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.AdminRole))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, body)
+	mockServices.RepositoryInterface.AssertCalled(t, "PurgeUser", mock.Anything, userID)
+}
+
+func TestGatewayErrorsKo(t *testing.T) {
+	userID := "1b8b24f8-a56b-4665-88f2-44e144389ce0"
+	mockServices, server := setupGateway(t)
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(model.DefaultRoles, nil)
+	mockServices.RepositoryInterface.On("GetUser", mock.Anything, mock.Anything).Return(nil, model.ErrUserNotFound)
+	mockServices.RepositoryInterface.On("CreateUser", mock.Anything, mock.Anything).Return(nil, &model.DuplicateError{Field: model.FieldEmail})
+	createBody := `{"firstname": "firstname", "lastname": "lastname", "nickname": "nickname", "email": "test@email.com", "password": "my_test_password", "country": "EN"}`
+	for _, call := range []struct {
+		method     string
+		path       string
+		body       string
+		token      string
+		httpStatus int
+		status     string
+	}{
+		{http.MethodGet, "/api/v1/users/" + userID, "", bearerToken(t, userID), http.StatusNotFound, "NOT_FOUND"},
+		{http.MethodGet, "/api/v1/users/" + userID, "", "", http.StatusUnauthorized, "UNAUTHENTICATED"},
+		{http.MethodGet, "/api/v1/users", "", bearerToken(t, userID), http.StatusForbidden, "PERMISSION_DENIED"},
+		{http.MethodPost, "/api/v1/users", "{", "", http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{http.MethodPost, "/api/v1/users", createBody, "", http.StatusConflict, "ALREADY_EXISTS"},
+		{http.MethodGet, "/api/v1/unknown", "", "", http.StatusNotFound, "NOT_FOUND"},
+	} {
+		// run test and validate
+		response, body := callGateway(t, server, call.method, call.path, call.body, call.token)
+		assert.Equal(t, call.httpStatus, response.StatusCode, call.path)
+		assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+		if errorBody, ok := body["error"].(map[string]interface{}); assert.True(t, ok, body) {
+			assert.Equal(t, float64(call.httpStatus), errorBody["code"])
+			assert.Equal(t, call.status, errorBody["status"])
+			assert.NotEmpty(t, errorBody["message"])
+		}
+		if call.httpStatus == http.StatusUnauthorized {
+			assert.Equal(t, "Bearer", response.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestGatewayErrorDetailsKo(t *testing.T) {
+	mockServices, server := setupGateway(t)
+	mockServices.RepositoryInterface.On("CreateUser", mock.Anything, mock.Anything).Return(nil, &model.DuplicateError{Field: model.FieldEmail})
+	// run test and validate
+	_, body := callGateway(t, server, http.MethodPost, "/api/v1/users",
+		`{"nickname": "nickname", "email": "test@email.com", "password": "my_test_password", "country": "EN"}`, "")
+	details := body["error"].(map[string]interface{})["details"].([]interface{})
+	if assert.Len(t, details, 1) {
+		detail := details[0].(map[string]interface{})
+		assert.Equal(t, "type.googleapis.com/google.rpc.BadRequest", detail["@type"])
+		violations := detail["field_violations"].([]interface{})
+		assert.Equal(t, model.FieldEmail, violations[0].(map[string]interface{})["field"])
+	}
+}
+
+func TestGatewayOpenAPIOk(t *testing.T) {
+	_, server := setupGateway(t)
+	// run test and validate
+	response, spec := callGateway(t, server, http.MethodGet, "/openapi.json", "", "")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "2.0", spec["swagger"])
+	assert.Contains(t, spec["paths"], "/api/v1/users/{id}")
+	definitions := spec["definitions"].(map[string]interface{})
+	assert.Contains(t, definitions, "userErrorResponse")
+	country := definitions["userCountry"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"UNKNOWN", "EN", "IT", "FR", "DE"}, country["enum"])
+	// the documentation page loads the specification
+	response, err := server.Client().Get(server.URL + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	page, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header.Get("Content-Type"))
+	assert.Contains(t, string(page), `spec-url="openapi.json"`)
+	assert.Equal(t, "nosniff", response.Header.Get("X-Content-Type-Options"))
+	// the policy allows only the script of the page
+	policy := response.Header.Get("Content-Security-Policy")
+	assert.Equal(t, api.DocsSecurityPolicy, policy)
+	script := regexp.MustCompile(`<script src="([^"]+)"`).FindStringSubmatch(string(page))
+	if assert.Len(t, script, 2) {
+		assert.Contains(t, policy, "script-src "+script[1]+";")
+	}
+	assert.Contains(t, policy, "default-src 'none'")
+}
+
+func TestGatewayRoutesOk(t *testing.T) {
+	userID := "1b8b24f8-a56b-4665-88f2-44e144389ce0"
+	country := api.Country_IT
+	email, nickname, firstname := "test@email.com", "nickname", "firstname"
+	routes := []struct {
+		rpc      string
+		method   string
+		path     string
+		body     string
+		expected proto.Message
+		reply    proto.Message
+	}{
+		{"CreateUser", http.MethodPost, "/api/v1/users", `{"firstname": "firstname", "email": "test@email.com", "password": "password", "country": "IT"}`,
+			&api.CreateUserRequest{Firstname: firstname, Email: email, Password: "password", Country: country}, &api.CreateUserResponse{}},
+		{"UpdateUser", http.MethodPut, "/api/v1/users", `{"id": "` + userID + `", "firstname": "firstname", "country": "IT", "update_mask": "firstname,country", "etag": "W/\"1\""}`,
+			&api.UpdateUserRequest{Id: userID, Firstname: &firstname, Country: &country,
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"firstname", "country"}}, Etag: `W/"1"`}, &api.User{}},
+		{"DeleteUser", http.MethodDelete, "/api/v1/users/" + userID + "?force=true", "", &api.DeleteUserRequest{Id: userID, Force: true}, &empty.Empty{}},
+		{"RestoreUser", http.MethodPost, "/api/v1/users/" + userID + ":restore", "{}", &api.RestoreUserRequest{Id: userID}, &api.User{}},
+		{"GetUsers", http.MethodGet, "/api/v1/users?filter_country=IT&page=1&page_size=5&skip_total_count=true&filter=roles%3Aadmin&order_by=email+desc", "",
+			&api.GetUsersRequest{FilterCountry: &country, Page: 1, PageSize: 5, SkipTotalCount: true, Filter: "roles:admin", OrderBy: "email desc"},
+			&api.GetUserResponse{}},
+		{"GetUsers", http.MethodGet, "/api/v1/users?page_token=token", "", &api.GetUsersRequest{PageToken: "token"}, &api.GetUserResponse{}},
+		{"SearchUsers", http.MethodGet, "/api/v1/users:search?query=red+boa&page_size=10&page_token=token", "",
+			&api.SearchUsersRequest{Query: "red boa", PageSize: 10, PageToken: "token"}, &api.SearchUsersResponse{}},
+		{"GetUser", http.MethodGet, "/api/v1/users/" + userID, "", &api.GetUserRequest{Id: userID}, &api.User{}},
+		{"LookupUser", http.MethodGet, "/api/v1/users:lookup?email=test@email.com", "",
+			&api.LookupUserRequest{Key: &api.LookupUserRequest_Email{Email: email}}, &api.User{}},
+		{"LookupUser", http.MethodGet, "/api/v1/users:lookup?nickname=nickname", "",
+			&api.LookupUserRequest{Key: &api.LookupUserRequest_Nickname{Nickname: nickname}}, &api.User{}},
+		{"CheckAvailability", http.MethodGet, "/api/v1/users:availability?email=test@email.com&nickname=nickname", "",
+			&api.CheckAvailabilityRequest{Email: &email, Nickname: &nickname}, &api.CheckAvailabilityResponse{}},
+		{"Authenticate", http.MethodPost, "/api/v1/users/authenticate", `{"nickname": "nickname", "password": "password"}`,
+			&api.AuthenticateRequest{Login: &api.AuthenticateRequest_Nickname{Nickname: nickname}, Password: "password"}, &api.AuthenticateResponse{}},
+		{"RefreshToken", http.MethodPost, "/api/v1/tokens/refresh", `{"refresh_token": "token"}`, &api.RefreshTokenRequest{RefreshToken: "token"}, &api.RefreshTokenResponse{}},
+		{"RevokeToken", http.MethodPost, "/api/v1/tokens/revoke", `{"refresh_token": "token"}`, &api.RevokeTokenRequest{RefreshToken: "token"}, &empty.Empty{}},
+		{"GetJWKS", http.MethodGet, "/.well-known/jwks.json", "", &empty.Empty{}, &api.JWKSResponse{}},
+		{"AssignRole", http.MethodPost, "/api/v1/users/" + userID + "/roles", `{"role": "admin"}`,
+			&api.AssignRoleRequest{UserId: userID, Role: model.AdminRole}, &empty.Empty{}},
+		{"RevokeRole", http.MethodDelete, "/api/v1/users/" + userID + "/roles/admin", "", &api.RevokeRoleRequest{UserId: userID, Role: model.AdminRole}, &empty.Empty{}},
+		{"PutRole", http.MethodPut, "/api/v1/roles/support", `{"description": "Support team", "permissions": ["users.read"]}`,
+			&api.PutRoleRequest{Role: &api.Role{Name: "support", Description: "Support team", Permissions: []string{model.PermissionUsersRead}}}, &api.Role{}},
+		{"ListRoles", http.MethodGet, "/api/v1/roles", "", &empty.Empty{}, &api.ListRolesResponse{}},
+		{"GetStatus", http.MethodGet, "/api/v1/health-check", "", &empty.Empty{}, &api.StatusReply{}},
+	}
+	for _, route := range routes {
+		// the interceptor records the gRPC request and replies in place of the service, so that only the routing is tested
+		var method string
+		var received interface{}
+		recorder := grpc.UnaryInterceptor(func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, _ grpc.UnaryHandler) (interface{}, error) {
+			method, received = info.FullMethod, request
+			return route.reply, nil
+		})
+		server := serveGateway(t, &api.UnimplementedUserServiceServer{}, recorder)
+		// run test and validate
+		response, _ := callGateway(t, server, route.method, route.path, route.body, "")
+		name := route.method + " " + route.path
+		assert.Equal(t, http.StatusOK, response.StatusCode, name)
+		if assert.IsType(t, route.expected, received, name) {
+			assert.True(t, proto.Equal(route.expected, received.(proto.Message)), "%s: expected %v, received %v", name, route.expected, received)
+		}
+		assert.Equal(t, "/user.UserService/"+route.rpc, method, name)
+	}
+}
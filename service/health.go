@@ -0,0 +1,186 @@
+package service
+
+// This file implements the health checks of the dependencies, reported on the grpc.health.v1 api and by GetStatus
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"sync"
+	"time"
+	"user/service/api"
+)
+
+const (
+	// LivenessService is SERVING on the grpc.health.v1 api as long as the server runs, for the liveness probes
+	LivenessService = "liveness"
+	// ReadinessService is SERVING on the grpc.health.v1 api when all the dependencies are reachable, for the readiness
+	// probes and the load balancers
+	ReadinessService = "readiness"
+)
+
+// readinessServices are the grpc.health.v1 services following the readiness: the overall health, with the empty name,
+// and the UserService
+var readinessServices = []string{"", ReadinessService, "user.UserService"}
+
+// HealthCheck is a dependency of the service, Check returns an error when it is not reachable
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthConfig defines how often the dependencies are checked and how long a check can take
+type HealthConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// DefaultHealthConfig checks the dependencies every 10 seconds, a check failing after 2 seconds
+var DefaultHealthConfig = HealthConfig{Interval: 10 * time.Second, Timeout: 2 * time.Second}
+
+// dependencyStatus is the result of the last check of a dependency
+type dependencyStatus struct {
+	err       error
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+// HealthChecker checks the dependencies periodically and reports the readiness on the grpc.health.v1 api, it is
+// registered as the health server of the gRPC server
+type HealthChecker struct {
+	*health.Server
+	checks  []HealthCheck
+	config  HealthConfig
+	mutex   sync.RWMutex
+	results map[string]dependencyStatus
+	// shuttingDown keeps the service not ready whatever the result of the checks
+	shuttingDown bool
+}
+
+// NewHealthChecker returns a HealthChecker reporting the service live, but not ready until the dependencies are checked.
+// The interval and the timeout must be positive
+func NewHealthChecker(config HealthConfig, checks ...HealthCheck) (*HealthChecker, error) {
+	if config.Interval <= 0 || config.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid health check settings: interval %s, timeout %s", config.Interval, config.Timeout)
+	}
+	checker := &HealthChecker{Server: health.NewServer(), checks: checks, config: config, results: map[string]dependencyStatus{}}
+	checker.SetServingStatus(LivenessService, healthpb.HealthCheckResponse_SERVING)
+	checker.setReadiness(healthpb.HealthCheckResponse_NOT_SERVING)
+	return checker, nil
+}
+
+// SetChecks replaces the dependencies once they are connected, the service is not ready until they are checked
+func (checker *HealthChecker) SetChecks(checks ...HealthCheck) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.checks = checks
+	checker.results = map[string]dependencyStatus{}
+	checker.setReadiness(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Shutdown reports the service not ready, so that the load balancers stop sending requests, while it stays live until
+// the in-flight requests are completed
+func (checker *HealthChecker) Shutdown() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.shuttingDown = true
+	checker.setReadiness(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Run checks the dependencies every Interval until the context is done
+func (checker *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(checker.config.Interval)
+	defer ticker.Stop()
+	for {
+		checker.CheckDependencies(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckDependencies checks all the dependencies concurrently, updates the readiness and returns true if all are reachable
+func (checker *HealthChecker) CheckDependencies(ctx context.Context) bool {
+	checker.mutex.RLock()
+	checks := checker.checks
+	checker.mutex.RUnlock()
+	results := make([]dependencyStatus, len(checks))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checker.config.Timeout)
+			defer cancel()
+			start := time.Now()
+			err := checks[i].Check(checkCtx)
+			results[i] = dependencyStatus{err: err, latency: time.Since(start), checkedAt: start}
+		}(i)
+	}
+	wg.Wait()
+	ready := true
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	for i, check := range checks {
+		previous, checked := checker.results[check.Name]
+		// only the changes are logged, the checks run every few seconds
+		if results[i].err != nil {
+			ready = false
+			if !checked || previous.err == nil {
+				log.Error("Dependency ", check.Name, " is not available ", results[i].err)
+			}
+		} else if checked && previous.err != nil {
+			log.Info("Dependency ", check.Name, " is available again")
+		}
+		checker.results[check.Name] = results[i]
+	}
+	if ready && !checker.shuttingDown {
+		checker.setReadiness(healthpb.HealthCheckResponse_SERVING)
+	} else {
+		checker.setReadiness(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return ready
+}
+
+// setReadiness updates the readiness services, it is called with the mutex held so that the shutdown is not overridden
+func (checker *HealthChecker) setReadiness(servingStatus healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range readinessServices {
+		checker.SetServingStatus(service, servingStatus)
+	}
+}
+
+// Status returns the status of the dependencies as of their last check, the service is UP when all of them are
+func (checker *HealthChecker) Status() *api.StatusReply {
+	checker.mutex.RLock()
+	defer checker.mutex.RUnlock()
+	reply := &api.StatusReply{Status: api.ServiceStatus_UP}
+	var unavailable []string
+	for _, check := range checker.checks {
+		dependency := &api.DependencyStatus{Name: check.Name, Status: api.ServiceStatus_DOWN}
+		if result, checked := checker.results[check.Name]; checked {
+			dependency.Latency = durationpb.New(result.latency)
+			dependency.CheckTime = timestamppb.New(result.checkedAt)
+			if result.err == nil {
+				dependency.Status = api.ServiceStatus_UP
+			}
+		}
+		if dependency.Status != api.ServiceStatus_UP {
+			unavailable = append(unavailable, check.Name)
+		}
+		reply.Dependencies = append(reply.Dependencies, dependency)
+	}
+	if len(unavailable) > 0 {
+		reply.Status = api.ServiceStatus_DOWN
+		reply.Message = "Account service not ready, unavailable dependencies: " + strings.Join(unavailable, ", ")
+	} else {
+		reply.Message = "Account service up and running"
+	}
+	return reply
+}
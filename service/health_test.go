@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/model"
+)
+
+// assertServing checks the grpc.health.v1 status of the given services
+func assertServing(t *testing.T, checker *HealthChecker, expected healthpb.HealthCheckResponse_ServingStatus, services ...string) {
+	for _, service := range services {
+		response, err := checker.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, response.Status, service)
+		}
+	}
+}
+
+// HEALTH CHECKER TESTS
+func TestHealthCheckerOk(t *testing.T) {
+	kafkaErr := errors.New("connection refused")
+	checker, _ := NewHealthChecker(HealthConfig{Interval: time.Minute, Timeout: time.Second},
+		HealthCheck{Name: "mongo", Check: func(ctx context.Context) error { return nil }},
+		HealthCheck{Name: "kafka", Check: func(ctx context.Context) error { return kafkaErr }},
+	)
+	// the service is live but not ready until the dependencies are checked
+	assertServing(t, checker, healthpb.HealthCheckResponse_SERVING, LivenessService)
+	assertServing(t, checker, healthpb.HealthCheckResponse_NOT_SERVING, "", ReadinessService, "user.UserService")
+	reply := checker.Status()
+	assert.Equal(t, api.ServiceStatus_DOWN, reply.Status)
+	assert.Nil(t, reply.Dependencies[0].CheckTime)
+	// run test and validate
+	assert.False(t, checker.CheckDependencies(ctx))
+	assertServing(t, checker, healthpb.HealthCheckResponse_NOT_SERVING, "", ReadinessService, "user.UserService")
+	reply = checker.Status()
+	assert.Equal(t, api.ServiceStatus_DOWN, reply.Status)
+	assert.Equal(t, "Account service not ready, unavailable dependencies: kafka", reply.Message)
+	if assert.Len(t, reply.Dependencies, 2) {
+		assert.Equal(t, "mongo", reply.Dependencies[0].Name)
+		assert.Equal(t, api.ServiceStatus_UP, reply.Dependencies[0].Status)
+		assert.NotNil(t, reply.Dependencies[0].Latency)
+		assert.NotNil(t, reply.Dependencies[0].CheckTime)
+		assert.Equal(t, api.ServiceStatus_DOWN, reply.Dependencies[1].Status)
+	}
+	// the readiness follows the dependencies
+	kafkaErr = nil
+	assert.True(t, checker.CheckDependencies(ctx))
+	assertServing(t, checker, healthpb.HealthCheckResponse_SERVING, "", LivenessService, ReadinessService, "user.UserService")
+	reply = checker.Status()
+	assert.Equal(t, api.ServiceStatus_UP, reply.Status)
+	assert.Equal(t, "Account service up and running", reply.Message)
+}
+
+func TestHealthCheckerTimeoutKo(t *testing.T) {
+	checker, _ := NewHealthChecker(HealthConfig{Interval: time.Minute, Timeout: 10 * time.Millisecond},
+		HealthCheck{Name: "mongo", Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	)
+	// run test and validate
+	assert.False(t, checker.CheckDependencies(ctx))
+	reply := checker.Status()
+	assert.Equal(t, api.ServiceStatus_DOWN, reply.Dependencies[0].Status)
+	assert.GreaterOrEqual(t, reply.Dependencies[0].Latency.AsDuration(), 10*time.Millisecond)
+}
+
+func TestHealthCheckerSetChecksOk(t *testing.T) {
+	checker, _ := NewHealthChecker(HealthConfig{Interval: time.Minute, Timeout: time.Second})
+	// the service is not ready while the dependencies are awaited at startup
+	assertServing(t, checker, healthpb.HealthCheckResponse_SERVING, LivenessService)
+	assertServing(t, checker, healthpb.HealthCheckResponse_NOT_SERVING, "", ReadinessService, "user.UserService")
+	// run test and validate
+	checker.SetChecks(HealthCheck{Name: "mongo", Check: func(ctx context.Context) error { return nil }})
+	assertServing(t, checker, healthpb.HealthCheckResponse_NOT_SERVING, ReadinessService)
+	assert.True(t, checker.CheckDependencies(ctx))
+	assertServing(t, checker, healthpb.HealthCheckResponse_SERVING, "", ReadinessService, "user.UserService")
+	assert.Equal(t, "mongo", checker.Status().Dependencies[0].Name)
+}
+
+func TestHealthCheckerShutdownOk(t *testing.T) {
+	checker, _ := NewHealthChecker(DefaultHealthConfig)
+	assert.True(t, checker.CheckDependencies(ctx))
+	// run test and validate, the service stays live to complete the in-flight requests
+	checker.Shutdown()
+	assertServing(t, checker, healthpb.HealthCheckResponse_SERVING, LivenessService)
+	assertServing(t, checker, healthpb.HealthCheckResponse_NOT_SERVING, "", ReadinessService, "user.UserService")
+	// the checks no longer make the service ready
+	assert.True(t, checker.CheckDependencies(ctx))
+	assertServing(t, checker, healthpb.HealthCheckResponse_NOT_SERVING, ReadinessService)
+}
+
+func TestNewHealthCheckerKo(t *testing.T) {
+	for _, config := range []HealthConfig{
+		{Interval: 0, Timeout: time.Second},
+		{Interval: -time.Second, Timeout: time.Second},
+		{Interval: time.Second, Timeout: 0},
+	} {
+		// run test and validate
+		checker, err := NewHealthChecker(config)
+		assert.Error(t, err)
+		assert.Nil(t, checker)
+	}
+}
+
+func TestServiceGetStatusDependenciesKo(t *testing.T) {
+	mockServices, testingService := setupService()
+	testingService.HealthChecker, _ = NewHealthChecker(HealthConfig{Interval: time.Minute, Timeout: time.Second},
+		HealthCheck{Name: "mongo", Check: func(ctx context.Context) error { return errors.New("server selection timeout") }},
+	)
+	testingService.HealthChecker.CheckDependencies(ctx)
+	adminCtx := ContextWithIdentity(ctx, &Identity{UserID: "3bacc2e9-089a-4c27-b662-d3826b68173b", Roles: []string{model.AdminRole}})
+	mockServices.RoleRepositoryInterface.On("ListRoles", adminCtx).Return(model.DefaultRoles, nil)
+	// run test and validate, the administrators get the status of the dependencies
+	reply, err := testingService.GetStatus(adminCtx, &empty.Empty{})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, api.ServiceStatus_DOWN, reply.Status)
+	assert.Equal(t, "mongo", reply.Dependencies[0].Name)
+	// the anonymous callers only get the overall status
+	reply, err = testingService.GetStatus(ctx, &empty.Empty{})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, api.ServiceStatus_DOWN, reply.Status)
+	assert.Equal(t, "Account service not ready", reply.Message)
+	assert.Empty(t, reply.Dependencies)
+}
+
+func TestInterceptorHealthCheckPublicOk(t *testing.T) {
+	_, testingService := setupService()
+	interceptor := NewAuthInterceptor(testingService.TokenService, testingService.Authorizer)
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	// run test and validate, the probes call without token
+	reply, err := interceptor.Unary()(context.Background(), &healthpb.HealthCheckRequest{}, info, func(ctx context.Context, request interface{}) (interface{}, error) {
+		return testingService.HealthChecker.Check(ctx, request.(*healthpb.HealthCheckRequest))
+	})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, reply.(*healthpb.HealthCheckResponse).Status)
+}
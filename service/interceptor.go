@@ -0,0 +1,184 @@
+package service
+
+// This file implements the authentication and authorization of the gRPC calls
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"user/service/api"
+	"user/service/model"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "bearer "
+)
+
+// Identity is the authenticated caller of a RPC, it is available in the handlers' context
+type Identity struct {
+	UserID string
+	Roles  []string
+}
+
+// HasRole returns true if the identity has been granted the given role
+func (identity *Identity) HasRole(role string) bool {
+	for _, current := range identity.Roles {
+		if current == role {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of the context carrying the given identity
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the caller, false if the RPC is called anonymously
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// rpcPolicy defines who can call a RPC. Public RPCs do not require a token, the others require the permission.
+// If self is defined, the user the request refers to can call the RPC without the permission
+type rpcPolicy struct {
+	public     bool
+	permission string
+	self       func(request interface{}) string
+}
+
+// rpcPolicies declares the access rules of every RPC, RPCs not listed here are denied
+var rpcPolicies = map[string]rpcPolicy{
+	"/user.UserService/GetStatus":  {public: true},
+	"/user.UserService/CreateUser": {public: true},
+	// used by signup forms before the account exists
+	"/user.UserService/CheckAvailability": {public: true},
+	"/user.UserService/Authenticate":      {public: true},
+	"/user.UserService/RefreshToken":      {public: true},
+	// the refresh token in the request is the credential
+	"/user.UserService/RevokeToken": {public: true},
+	"/user.UserService/GetJWKS":     {public: true},
+	"/user.UserService/GetUsers":    {permission: model.PermissionUsersRead},
+	"/user.UserService/LookupUser":  {permission: model.PermissionUsersRead},
+	"/user.UserService/SearchUsers": {permission: model.PermissionUsersRead},
+	"/user.UserService/GetUser": {permission: model.PermissionUsersRead, self: func(request interface{}) string {
+		return request.(*api.GetUserRequest).Id
+	}},
+	"/user.UserService/UpdateUser": {permission: model.PermissionUsersWrite, self: func(request interface{}) string {
+		return request.(*api.UpdateUserRequest).Id
+	}},
+	"/user.UserService/DeleteUser": {permission: model.PermissionUsersDelete, self: func(request interface{}) string {
+		return request.(*api.DeleteUserRequest).Id
+	}},
+	// deleted users cannot log in, so they are restored by the support
+	"/user.UserService/RestoreUser": {permission: model.PermissionUsersDelete},
+	"/user.UserService/AssignRole":  {permission: model.PermissionUsersAdmin},
+	"/user.UserService/RevokeRole":  {permission: model.PermissionUsersAdmin},
+	"/user.UserService/PutRole":     {permission: model.PermissionUsersAdmin},
+	"/user.UserService/ListRoles":   {permission: model.PermissionUsersAdmin},
+	// used by the probes and the load balancers
+	"/grpc.health.v1.Health/Check": {public: true},
+	"/grpc.health.v1.Health/Watch": {public: true},
+}
+
+// AuthInterceptor validates the bearer token of the calls and enforces the RPC policies
+type AuthInterceptor struct {
+	TokenService *TokenService
+	Authorizer   *Authorizer
+	policies     map[string]rpcPolicy
+}
+
+// NewAuthInterceptor allows to create a new instance of the AuthInterceptor
+func NewAuthInterceptor(tokenService *TokenService, authorizer *Authorizer) *AuthInterceptor {
+	return &AuthInterceptor{TokenService: tokenService, Authorizer: authorizer, policies: rpcPolicies}
+}
+
+// Unary returns the interceptor for unary RPCs
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := interceptor.authorize(ctx, info.FullMethod, request)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, request)
+	}
+}
+
+// Stream returns the interceptor for streaming RPCs. As the request is not known yet, policies depending on it deny the call
+func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authorize returns a context carrying the identity of the caller if the policy of the RPC allows the call
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, fullMethod string, request interface{}) (context.Context, error) {
+	policy, ok := interceptor.policies[fullMethod]
+	if !ok {
+		log.Warn("No access policy defined for ", fullMethod)
+		return nil, status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	identity, err := interceptor.authenticate(ctx)
+	if err != nil {
+		if policy.public {
+			return ctx, nil
+		}
+		return nil, err
+	}
+	ctx = ContextWithIdentity(ctx, identity)
+	if policy.public {
+		return ctx, nil
+	}
+	if policy.self != nil && request != nil && policy.self(request) == identity.UserID {
+		return ctx, nil
+	}
+	allowed, err := interceptor.Authorizer.HasPermission(ctx, identity, policy.permission)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !allowed {
+		log.Info("User ", identity.UserID, " is not allowed to call ", fullMethod)
+		return nil, status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	return ctx, nil
+}
+
+// authenticate returns the identity carried by the bearer token in the authorization metadata
+func (interceptor *AuthInterceptor) authenticate(ctx context.Context) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "Missing bearer token")
+	}
+	if !strings.HasPrefix(strings.ToLower(values[0]), bearerPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "Invalid authorization header")
+	}
+	claims, err := interceptor.TokenService.ValidateAccessToken(values[0][len(bearerPrefix):])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid bearer token")
+	}
+	return &Identity{UserID: claims.Subject, Roles: claims.Roles}, nil
+}
+
+// identityStream overrides the context of the stream with the one carrying the identity
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *identityStream) Context() context.Context {
+	return stream.ctx
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/model"
+)
+
+// AUTH INTERCEPTOR TESTS
+func TestInterceptorPublicRpcWithoutTokenOk(t *testing.T) {
+	_, testingService := setupService()
+	// run test and validate
+	reply, err := callUnary(testingService, context.Background(), "GetStatus", &empty.Empty{})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, api.ServiceStatus_UP, reply.(*api.StatusReply).Status)
+}
+
+func TestInterceptorMissingTokenKo(t *testing.T) {
+	_, testingService := setupService()
+	request := &api.DeleteUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	// run test and validate
+	reply, err := callUnary(testingService, context.Background(), "DeleteUser", request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Missing bearer token", codes.Unauthenticated)
+}
+
+func TestInterceptorInvalidTokenKo(t *testing.T) {
+	_, testingService := setupService()
+	request := &api.DeleteUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer not-a-jwt"))
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "DeleteUser", request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Invalid bearer token", codes.Unauthenticated)
+}
+
+func TestInterceptorDeleteSelfOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	request := &api.DeleteUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	callCtx := contextWithToken(t, request.Id)
+	deletedAt := time.Now()
+	mockServices.RepositoryInterface.On("DeleteUser", mock.Anything, request).Return(&model.User{ID: request.Id, DeletedAt: &deletedAt}, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", mock.Anything, mock.AnythingOfType("*api.UserDeleted")).Return(nil)
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "DeleteUser", request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+}
+
+func TestInterceptorDeleteOtherUserKo(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(model.DefaultRoles, nil)
+	request := &api.DeleteUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b")
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "DeleteUser", request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Permission denied", codes.PermissionDenied)
+}
+
+func TestInterceptorAdminDeleteOtherUserOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(model.DefaultRoles, nil)
+	request := &api.DeleteUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.AdminRole)
+	deletedAt := time.Now()
+	mockServices.RepositoryInterface.On("DeleteUser", mock.Anything, request).Return(&model.User{ID: request.Id, DeletedAt: &deletedAt}, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", mock.Anything, mock.AnythingOfType("*api.UserDeleted")).Return(nil)
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "DeleteUser", request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+}
+
+func TestInterceptorGetUsersWithoutPermissionKo(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(model.DefaultRoles, nil)
+	request := &api.GetUsersRequest{Page: 0, PageSize: 10}
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.UserRole)
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "GetUsers", request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Permission denied", codes.PermissionDenied)
+}
+
+func TestInterceptorGetUsersWithPermissionOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	roles := append(model.DefaultRoles, model.Role{Name: "support", Permissions: []string{model.PermissionUsersRead}})
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(roles, nil)
+	request := &api.GetUsersRequest{Page: 0, PageSize: 10}
+	mockServices.RepositoryInterface.On("GetUsersPaginated", mock.Anything, mock.Anything).Return(&model.UserPage{Users: createDecodedUsers()}, nil)
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.UserRole, "support")
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "GetUsers", request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, 2, len(reply.(*api.GetUserResponse).Results))
+}
+
+func TestInterceptorRoleRepositoryErrorKo(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(nil, errors.New("repository error"))
+	request := &api.GetUsersRequest{Page: 0, PageSize: 10}
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.AdminRole)
+	// run test and validate
+	reply, err := callUnary(testingService, callCtx, "GetUsers", request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "repository error", codes.Internal)
+}
+
+func TestInterceptorIdentityInContextOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", mock.Anything).Return(model.DefaultRoles, nil)
+	interceptor := NewAuthInterceptor(testingService.TokenService, testingService.Authorizer)
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.AdminRole)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUsers"}
+	var identity *Identity
+	// run test and validate
+	_, err := interceptor.Unary()(callCtx, &api.GetUsersRequest{}, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		identity, _ = IdentityFromContext(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", identity.UserID)
+	assert.True(t, identity.HasRole(model.AdminRole))
+}
+
+func TestInterceptorUnknownRpcKo(t *testing.T) {
+	_, testingService := setupService()
+	interceptor := NewAuthInterceptor(testingService.TokenService, testingService.Authorizer)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/NotDeclared"}
+	callCtx := contextWithToken(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", model.AdminRole)
+	// run test and validate
+	_, err := interceptor.Unary()(callCtx, &empty.Empty{}, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	assertStatusError(t, err, "Permission denied", codes.PermissionDenied)
+}
+
+// Utility
+// callUnary invokes the RPC of the Service through the auth interceptor, as the gRPC server would do
+func callUnary(testingService *Service, callCtx context.Context, method string, request interface{}) (interface{}, error) {
+	interceptor := NewAuthInterceptor(testingService.TokenService, testingService.Authorizer)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/" + method}
+	return interceptor.Unary()(callCtx, request, info, func(ctx context.Context, request interface{}) (interface{}, error) {
+		switch method {
+		case "GetStatus":
+			return testingService.GetStatus(ctx, request.(*empty.Empty))
+		case "GetUsers":
+			return testingService.GetUsers(ctx, request.(*api.GetUsersRequest))
+		case "DeleteUser":
+			return testingService.DeleteUser(ctx, request.(*api.DeleteUserRequest))
+		}
+		return nil, nil
+	})
+}
+
+// contextWithToken returns an incoming context carrying an access token for the given user and roles
+func contextWithToken(t *testing.T, userID string, roles ...string) context.Context {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "user-service-test",
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		Roles: roles,
+	})
+	token.Header["kid"] = "test-key"
+	signedToken, err := token.SignedString(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+signedToken))
+}
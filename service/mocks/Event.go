@@ -0,0 +1,77 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import (
+	api "user/service/api"
+
+	mock "github.com/stretchr/testify/mock"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Event is an autogenerated mock type for the Event type
+type Event struct {
+	mock.Mock
+}
+
+// GetMetadata provides a mock function with given fields:
+func (_m *Event) GetMetadata() *api.EventMetadata {
+	ret := _m.Called()
+
+	var r0 *api.EventMetadata
+	if rf, ok := ret.Get(0).(func() *api.EventMetadata); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.EventMetadata)
+		}
+	}
+
+	return r0
+}
+
+// GetUserId provides a mock function with given fields:
+func (_m *Event) GetUserId() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// ProtoReflect provides a mock function with given fields:
+func (_m *Event) ProtoReflect() protoreflect.Message {
+	ret := _m.Called()
+
+	var r0 protoreflect.Message
+	if rf, ok := ret.Get(0).(func() protoreflect.Message); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(protoreflect.Message)
+		}
+	}
+
+	return r0
+}
+
+type mockConstructorTestingTNewEvent interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewEvent creates a new instance of Event. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewEvent(t mockConstructorTestingTNewEvent) *Event {
+	mock := &Event{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
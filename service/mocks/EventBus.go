@@ -0,0 +1,81 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	model "user/service/model"
+
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// EventBus is an autogenerated mock type for the EventBus type
+type EventBus struct {
+	mock.Mock
+}
+
+// Close provides a mock function with given fields:
+func (_m *EventBus) Close() {
+	_m.Called()
+}
+
+// Flush provides a mock function with given fields: timeout
+func (_m *EventBus) Flush(timeout time.Duration) int {
+	ret := _m.Called(timeout)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Duration) int); ok {
+		r0 = rf(timeout)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// Ping provides a mock function with given fields: ctx
+func (_m *EventBus) Ping(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Publish provides a mock function with given fields: event
+func (_m *EventBus) Publish(event model.Event) <-chan error {
+	ret := _m.Called(event)
+
+	var r0 <-chan error
+	if rf, ok := ret.Get(0).(func(model.Event) <-chan error); ok {
+		r0 = rf(event)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan error)
+		}
+	}
+
+	return r0
+}
+
+type mockConstructorTestingTNewEventBus interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewEventBus creates a new instance of EventBus. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewEventBus(t mockConstructorTestingTNewEventBus) *EventBus {
+	mock := &EventBus{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
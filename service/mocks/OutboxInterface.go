@@ -0,0 +1,120 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	model "user/service/model"
+
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// OutboxInterface is an autogenerated mock type for the OutboxInterface type
+type OutboxInterface struct {
+	mock.Mock
+}
+
+// ClaimPendingEvents provides a mock function with given fields: ctx, owner, now, lease, limit
+func (_m *OutboxInterface) ClaimPendingEvents(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.OutboxEntry, error) {
+	ret := _m.Called(ctx, owner, now, lease, limit)
+
+	var r0 []model.OutboxEntry
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Duration, int) []model.OutboxEntry); ok {
+		r0 = rf(ctx, owner, now, lease, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.OutboxEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Duration, int) error); ok {
+		r1 = rf(ctx, owner, now, lease, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetOutboxStats provides a mock function with given fields: ctx
+func (_m *OutboxInterface) GetOutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.OutboxStats
+	if rf, ok := ret.Get(0).(func(context.Context) *model.OutboxStats); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.OutboxStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkEventFailed provides a mock function with given fields: ctx, id, userID, nextAttemptAt, lastError
+func (_m *OutboxInterface) MarkEventFailed(ctx context.Context, id string, userID string, nextAttemptAt time.Time, lastError string) error {
+	ret := _m.Called(ctx, id, userID, nextAttemptAt, lastError)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time, string) error); ok {
+		r0 = rf(ctx, id, userID, nextAttemptAt, lastError)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MarkEventPublished provides a mock function with given fields: ctx, id, publishedAt
+func (_m *OutboxInterface) MarkEventPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	ret := _m.Called(ctx, id, publishedAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, id, publishedAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ParkEvent provides a mock function with given fields: ctx, id, parkedAt, lastError
+func (_m *OutboxInterface) ParkEvent(ctx context.Context, id string, parkedAt time.Time, lastError string) error {
+	ret := _m.Called(ctx, id, parkedAt, lastError)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, string) error); ok {
+		r0 = rf(ctx, id, parkedAt, lastError)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+type mockConstructorTestingTNewOutboxInterface interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewOutboxInterface creates a new instance of OutboxInterface. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewOutboxInterface(t mockConstructorTestingTNewOutboxInterface) *OutboxInterface {
+	mock := &OutboxInterface{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
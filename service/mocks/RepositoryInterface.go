@@ -9,6 +9,8 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	model "user/service/model"
+
+	time "time"
 )
 
 // RepositoryInterface is an autogenerated mock type for the RepositoryInterface type
@@ -16,6 +18,61 @@ type RepositoryInterface struct {
 	mock.Mock
 }
 
+// AssignRole provides a mock function with given fields: ctx, userID, role
+func (_m *RepositoryInterface) AssignRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	ret := _m.Called(ctx, userID, role)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *model.User); ok {
+		r0 = rf(ctx, userID, role)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 *model.User
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) *model.User); ok {
+		r1 = rf(ctx, userID, role)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*model.User)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, string) error); ok {
+		r2 = rf(ctx, userID, role)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// AuthenticateUser provides a mock function with given fields: ctx, request
+func (_m *RepositoryInterface) AuthenticateUser(ctx context.Context, request *api.AuthenticateRequest) (*model.User, error) {
+	ret := _m.Called(ctx, request)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.AuthenticateRequest) *model.User); ok {
+		r0 = rf(ctx, request)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.AuthenticateRequest) error); ok {
+		r1 = rf(ctx, request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateUser provides a mock function with given fields: ctx, request
 func (_m *RepositoryInterface) CreateUser(ctx context.Context, request *api.CreateUserRequest) (*model.User, error) {
 	ret := _m.Called(ctx, request)
@@ -40,12 +97,35 @@ func (_m *RepositoryInterface) CreateUser(ctx context.Context, request *api.Crea
 }
 
 // DeleteUser provides a mock function with given fields: ctx, request
-func (_m *RepositoryInterface) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) error {
+func (_m *RepositoryInterface) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) (*model.User, error) {
 	ret := _m.Called(ctx, request)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *api.DeleteUserRequest) error); ok {
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.DeleteUserRequest) *model.User); ok {
 		r0 = rf(ctx, request)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.DeleteUserRequest) error); ok {
+		r1 = rf(ctx, request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EnqueueEvent provides a mock function with given fields: ctx, event
+func (_m *RepositoryInterface) EnqueueEvent(ctx context.Context, event model.Event) error {
+	ret := _m.Called(ctx, event)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.Event) error); ok {
+		r0 = rf(ctx, event)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -53,21 +133,44 @@ func (_m *RepositoryInterface) DeleteUser(ctx context.Context, request *api.Dele
 	return r0
 }
 
-// GetUsersPaginated provides a mock function with given fields: ctx, request
-func (_m *RepositoryInterface) GetUsersPaginated(ctx context.Context, request *api.GetUsersRequest) ([]model.User, error) {
+// EnsureAdminUser provides a mock function with given fields: ctx, email, nickname, password
+func (_m *RepositoryInterface) EnsureAdminUser(ctx context.Context, email string, nickname string, password string) (*model.User, error) {
+	ret := _m.Called(ctx, email, nickname, password)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *model.User); ok {
+		r0 = rf(ctx, email, nickname, password)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, email, nickname, password)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUser provides a mock function with given fields: ctx, request
+func (_m *RepositoryInterface) GetUser(ctx context.Context, request *api.GetUserRequest) (*model.User, error) {
 	ret := _m.Called(ctx, request)
 
-	var r0 []model.User
-	if rf, ok := ret.Get(0).(func(context.Context, *api.GetUsersRequest) []model.User); ok {
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.GetUserRequest) *model.User); ok {
 		r0 = rf(ctx, request)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]model.User)
+			r0 = ret.Get(0).(*model.User)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, *api.GetUsersRequest) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, *api.GetUserRequest) error); ok {
 		r1 = rf(ctx, request)
 	} else {
 		r1 = ret.Error(1)
@@ -76,12 +179,56 @@ func (_m *RepositoryInterface) GetUsersPaginated(ctx context.Context, request *a
 	return r0, r1
 }
 
-// UpdateUser provides a mock function with given fields: ctx, request
-func (_m *RepositoryInterface) UpdateUser(ctx context.Context, request *api.UpdateUserRequest) (*model.User, error) {
+// GetUsersPaginated provides a mock function with given fields: ctx, query
+func (_m *RepositoryInterface) GetUsersPaginated(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 *model.UserPage
+	if rf, ok := ret.Get(0).(func(context.Context, model.UserQuery) *model.UserPage); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.UserPage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.UserQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IsTaken provides a mock function with given fields: ctx, field, value
+func (_m *RepositoryInterface) IsTaken(ctx context.Context, field string, value string) (bool, error) {
+	ret := _m.Called(ctx, field, value)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(ctx, field, value)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, field, value)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LookupUser provides a mock function with given fields: ctx, request
+func (_m *RepositoryInterface) LookupUser(ctx context.Context, request *api.LookupUserRequest) (*model.User, error) {
 	ret := _m.Called(ctx, request)
 
 	var r0 *model.User
-	if rf, ok := ret.Get(0).(func(context.Context, *api.UpdateUserRequest) *model.User); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *api.LookupUserRequest) *model.User); ok {
 		r0 = rf(ctx, request)
 	} else {
 		if ret.Get(0) != nil {
@@ -90,7 +237,7 @@ func (_m *RepositoryInterface) UpdateUser(ctx context.Context, request *api.Upda
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, *api.UpdateUserRequest) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, *api.LookupUserRequest) error); ok {
 		r1 = rf(ctx, request)
 	} else {
 		r1 = ret.Error(1)
@@ -99,6 +246,176 @@ func (_m *RepositoryInterface) UpdateUser(ctx context.Context, request *api.Upda
 	return r0, r1
 }
 
+// PurgeDeletedUsers provides a mock function with given fields: ctx, deletedBefore, limit
+func (_m *RepositoryInterface) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	ret := _m.Called(ctx, deletedBefore, limit)
+
+	var r0 []model.User
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) []model.User); ok {
+		r0 = rf(ctx, deletedBefore, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, int) error); ok {
+		r1 = rf(ctx, deletedBefore, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeUser provides a mock function with given fields: ctx, userID
+func (_m *RepositoryInterface) PurgeUser(ctx context.Context, userID string) (*model.User, error) {
+	ret := _m.Called(ctx, userID)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.User); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreUser provides a mock function with given fields: ctx, userID, deletedAfter
+func (_m *RepositoryInterface) RestoreUser(ctx context.Context, userID string, deletedAfter time.Time) (*model.User, error) {
+	ret := _m.Called(ctx, userID, deletedAfter)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) *model.User); ok {
+		r0 = rf(ctx, userID, deletedAfter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, userID, deletedAfter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeRole provides a mock function with given fields: ctx, userID, role
+func (_m *RepositoryInterface) RevokeRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	ret := _m.Called(ctx, userID, role)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *model.User); ok {
+		r0 = rf(ctx, userID, role)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 *model.User
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) *model.User); ok {
+		r1 = rf(ctx, userID, role)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*model.User)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, string) error); ok {
+		r2 = rf(ctx, userID, role)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// RunInTransaction provides a mock function with given fields: ctx, fn
+func (_m *RepositoryInterface) RunInTransaction(ctx context.Context, fn func(context.Context) error) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(context.Context) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchUsers provides a mock function with given fields: ctx, search
+func (_m *RepositoryInterface) SearchUsers(ctx context.Context, search model.UserSearch) ([]model.UserSearchResult, error) {
+	ret := _m.Called(ctx, search)
+
+	var r0 []model.UserSearchResult
+	if rf, ok := ret.Get(0).(func(context.Context, model.UserSearch) []model.UserSearchResult); ok {
+		r0 = rf(ctx, search)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.UserSearchResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.UserSearch) error); ok {
+		r1 = rf(ctx, search)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateUser provides a mock function with given fields: ctx, request, version
+func (_m *RepositoryInterface) UpdateUser(ctx context.Context, request *api.UpdateUserRequest, version int64) (*model.User, *model.User, error) {
+	ret := _m.Called(ctx, request, version)
+
+	var r0 *model.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.UpdateUserRequest, int64) *model.User); ok {
+		r0 = rf(ctx, request, version)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	var r1 *model.User
+	if rf, ok := ret.Get(1).(func(context.Context, *api.UpdateUserRequest, int64) *model.User); ok {
+		r1 = rf(ctx, request, version)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*model.User)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, *api.UpdateUserRequest, int64) error); ok {
+		r2 = rf(ctx, request, version)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 type mockConstructorTestingTNewRepositoryInterface interface {
 	mock.TestingT
 	Cleanup(func())
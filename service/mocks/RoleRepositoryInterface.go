@@ -0,0 +1,90 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	model "user/service/model"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// RoleRepositoryInterface is an autogenerated mock type for the RoleRepositoryInterface type
+type RoleRepositoryInterface struct {
+	mock.Mock
+}
+
+// GetRole provides a mock function with given fields: ctx, name
+func (_m *RoleRepositoryInterface) GetRole(ctx context.Context, name string) (*model.Role, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 *model.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Role); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Role)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListRoles provides a mock function with given fields: ctx
+func (_m *RoleRepositoryInterface) ListRoles(ctx context.Context) ([]model.Role, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.Role
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Role); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Role)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PutRole provides a mock function with given fields: ctx, role
+func (_m *RoleRepositoryInterface) PutRole(ctx context.Context, role *model.Role) error {
+	ret := _m.Called(ctx, role)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Role) error); ok {
+		r0 = rf(ctx, role)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+type mockConstructorTestingTNewRoleRepositoryInterface interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewRoleRepositoryInterface creates a new instance of RoleRepositoryInterface. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewRoleRepositoryInterface(t mockConstructorTestingTNewRoleRepositoryInterface) *RoleRepositoryInterface {
+	mock := &RoleRepositoryInterface{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
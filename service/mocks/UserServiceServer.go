@@ -16,6 +16,75 @@ type UserServiceServer struct {
 	mock.Mock
 }
 
+// AssignRole provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) AssignRole(_a0 context.Context, _a1 *api.AssignRoleRequest) (*emptypb.Empty, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *emptypb.Empty
+	if rf, ok := ret.Get(0).(func(context.Context, *api.AssignRoleRequest) *emptypb.Empty); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*emptypb.Empty)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.AssignRoleRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Authenticate provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) Authenticate(_a0 context.Context, _a1 *api.AuthenticateRequest) (*api.AuthenticateResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.AuthenticateResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *api.AuthenticateRequest) *api.AuthenticateResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.AuthenticateResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.AuthenticateRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CheckAvailability provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) CheckAvailability(_a0 context.Context, _a1 *api.CheckAvailabilityRequest) (*api.CheckAvailabilityResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.CheckAvailabilityResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *api.CheckAvailabilityRequest) *api.CheckAvailabilityResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.CheckAvailabilityResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.CheckAvailabilityRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateUser provides a mock function with given fields: _a0, _a1
 func (_m *UserServiceServer) CreateUser(_a0 context.Context, _a1 *api.CreateUserRequest) (*api.CreateUserResponse, error) {
 	ret := _m.Called(_a0, _a1)
@@ -62,6 +131,29 @@ func (_m *UserServiceServer) DeleteUser(_a0 context.Context, _a1 *api.DeleteUser
 	return r0, r1
 }
 
+// GetJWKS provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) GetJWKS(_a0 context.Context, _a1 *emptypb.Empty) (*api.JWKSResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.JWKSResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty) *api.JWKSResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.JWKSResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetStatus provides a mock function with given fields: _a0, _a1
 func (_m *UserServiceServer) GetStatus(_a0 context.Context, _a1 *emptypb.Empty) (*api.StatusReply, error) {
 	ret := _m.Called(_a0, _a1)
@@ -85,6 +177,29 @@ func (_m *UserServiceServer) GetStatus(_a0 context.Context, _a1 *emptypb.Empty)
 	return r0, r1
 }
 
+// GetUser provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) GetUser(_a0 context.Context, _a1 *api.GetUserRequest) (*api.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.GetUserRequest) *api.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.GetUserRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetUsers provides a mock function with given fields: _a0, _a1
 func (_m *UserServiceServer) GetUsers(_a0 context.Context, _a1 *api.GetUsersRequest) (*api.GetUserResponse, error) {
 	ret := _m.Called(_a0, _a1)
@@ -108,12 +223,127 @@ func (_m *UserServiceServer) GetUsers(_a0 context.Context, _a1 *api.GetUsersRequ
 	return r0, r1
 }
 
-// UpdateUser provides a mock function with given fields: _a0, _a1
-func (_m *UserServiceServer) UpdateUser(_a0 context.Context, _a1 *api.UpdateUserRequest) (*emptypb.Empty, error) {
+// ListRoles provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) ListRoles(_a0 context.Context, _a1 *emptypb.Empty) (*api.ListRolesResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.ListRolesResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty) *api.ListRolesResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.ListRolesResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LookupUser provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) LookupUser(_a0 context.Context, _a1 *api.LookupUserRequest) (*api.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.LookupUserRequest) *api.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.LookupUserRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PutRole provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) PutRole(_a0 context.Context, _a1 *api.PutRoleRequest) (*api.Role, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.Role
+	if rf, ok := ret.Get(0).(func(context.Context, *api.PutRoleRequest) *api.Role); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.Role)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.PutRoleRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RefreshToken provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) RefreshToken(_a0 context.Context, _a1 *api.RefreshTokenRequest) (*api.RefreshTokenResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.RefreshTokenResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *api.RefreshTokenRequest) *api.RefreshTokenResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.RefreshTokenResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.RefreshTokenRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreUser provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) RestoreUser(_a0 context.Context, _a1 *api.RestoreUserRequest) (*api.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.RestoreUserRequest) *api.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.RestoreUserRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeRole provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) RevokeRole(_a0 context.Context, _a1 *api.RevokeRoleRequest) (*emptypb.Empty, error) {
 	ret := _m.Called(_a0, _a1)
 
 	var r0 *emptypb.Empty
-	if rf, ok := ret.Get(0).(func(context.Context, *api.UpdateUserRequest) *emptypb.Empty); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *api.RevokeRoleRequest) *emptypb.Empty); ok {
 		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
@@ -121,6 +351,75 @@ func (_m *UserServiceServer) UpdateUser(_a0 context.Context, _a1 *api.UpdateUser
 		}
 	}
 
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.RevokeRoleRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeToken provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) RevokeToken(_a0 context.Context, _a1 *api.RevokeTokenRequest) (*emptypb.Empty, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *emptypb.Empty
+	if rf, ok := ret.Get(0).(func(context.Context, *api.RevokeTokenRequest) *emptypb.Empty); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*emptypb.Empty)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.RevokeTokenRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SearchUsers provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) SearchUsers(_a0 context.Context, _a1 *api.SearchUsersRequest) (*api.SearchUsersResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.SearchUsersResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *api.SearchUsersRequest) *api.SearchUsersResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.SearchUsersResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.SearchUsersRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateUser provides a mock function with given fields: _a0, _a1
+func (_m *UserServiceServer) UpdateUser(_a0 context.Context, _a1 *api.UpdateUserRequest) (*api.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.User
+	if rf, ok := ret.Get(0).(func(context.Context, *api.UpdateUserRequest) *api.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.User)
+		}
+	}
+
 	var r1 error
 	if rf, ok := ret.Get(1).(func(context.Context, *api.UpdateUserRequest) error); ok {
 		r1 = rf(_a0, _a1)
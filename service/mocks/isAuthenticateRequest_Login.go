@@ -0,0 +1,30 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// isAuthenticateRequest_Login is an autogenerated mock type for the isAuthenticateRequest_Login type
+type isAuthenticateRequest_Login struct {
+	mock.Mock
+}
+
+// isAuthenticateRequest_Login provides a mock function with given fields:
+func (_m *isAuthenticateRequest_Login) isAuthenticateRequest_Login() {
+	_m.Called()
+}
+
+type mockConstructorTestingTnewIsAuthenticateRequest_Login interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// newIsAuthenticateRequest_Login creates a new instance of isAuthenticateRequest_Login. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func newIsAuthenticateRequest_Login(t mockConstructorTestingTnewIsAuthenticateRequest_Login) *isAuthenticateRequest_Login {
+	mock := &isAuthenticateRequest_Login{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
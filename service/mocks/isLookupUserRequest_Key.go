@@ -0,0 +1,30 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// isLookupUserRequest_Key is an autogenerated mock type for the isLookupUserRequest_Key type
+type isLookupUserRequest_Key struct {
+	mock.Mock
+}
+
+// isLookupUserRequest_Key provides a mock function with given fields:
+func (_m *isLookupUserRequest_Key) isLookupUserRequest_Key() {
+	_m.Called()
+}
+
+type mockConstructorTestingTnewIsLookupUserRequest_Key interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// newIsLookupUserRequest_Key creates a new instance of isLookupUserRequest_Key. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func newIsLookupUserRequest_Key(t mockConstructorTestingTnewIsLookupUserRequest_Key) *isLookupUserRequest_Key {
+	mock := &isLookupUserRequest_Key{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
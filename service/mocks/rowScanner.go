@@ -0,0 +1,41 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// rowScanner is an autogenerated mock type for the rowScanner type
+type rowScanner struct {
+	mock.Mock
+}
+
+// Scan provides a mock function with given fields: dest
+func (_m *rowScanner) Scan(dest ...interface{}) error {
+	var _ca []interface{}
+	_ca = append(_ca, dest...)
+	ret := _m.Called(_ca...)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(...interface{}) error); ok {
+		r0 = rf(dest...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+type mockConstructorTestingTnewRowScanner interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// newRowScanner creates a new instance of rowScanner. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func newRowScanner(t mockConstructorTestingTnewRowScanner) *rowScanner {
+	mock := &rowScanner{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
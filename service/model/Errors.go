@@ -0,0 +1,43 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCredentials is returned when the given login and password do not match any stored user.
+// The same error is used for unknown accounts and wrong passwords, so callers cannot tell them apart
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrRefreshTokenNotFound is returned when the given refresh token does not exist or is expired
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// ErrRefreshTokenReused is returned when an already rotated or revoked refresh token is presented again
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// ErrUserNotFound is returned when the requested user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserNotDeleted is returned when restoring a user that is not deleted
+var ErrUserNotDeleted = errors.New("user is not deleted")
+
+// ErrRestorePeriodExpired is returned when restoring a user deleted before the grace period, it is going to be purged
+var ErrRestorePeriodExpired = errors.New("restore period expired")
+
+// ErrVersionConflict is returned when a user is not at the version an update was computed from
+var ErrVersionConflict = errors.New("user was modified concurrently")
+
+// ErrInvalidEtag is returned when an etag was not returned by User.Etag
+var ErrInvalidEtag = errors.New("invalid etag")
+
+// ErrRoleNotFound is returned when the requested role does not exist
+var ErrRoleNotFound = errors.New("role not found")
+
+// DuplicateError is returned when a user cannot be stored because another user has the same value of a unique field
+type DuplicateError struct {
+	Field string
+}
+
+func (err *DuplicateError) Error() string {
+	return fmt.Sprintf("a user with the same %s already exists", err.Field)
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"google.golang.org/protobuf/proto"
+	"user/service/api"
+)
+
+// EventSchemaVersion is the version of the events defined in user_events.proto, set in the metadata of every event
+const EventSchemaVersion = 1
+
+// Event is a domain event about a user, implemented by the messages of user_events.proto
+type Event interface {
+	proto.Message
+	GetMetadata() *api.EventMetadata
+	GetUserId() string
+}
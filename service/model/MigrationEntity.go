@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// MigrationStatus is a schema migration known by the service or recorded in the database, AppliedAt is nil if it is pending
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Pending reports whether the migration has not been applied yet
+func (status MigrationStatus) Pending() bool {
+	return status.AppliedAt == nil
+}
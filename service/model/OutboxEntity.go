@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// OutboxEntry is an event waiting to be published, stored in the same transaction as the user change that caused it.
+// The relay publishes the entries in creation order and sets PublishedAt, failed attempts are retried at NextAttemptAt.
+// A relay claims the entries until LeaseUntil before publishing them, entries that cannot be published are parked at ParkedAt
+type OutboxEntry struct {
+	ID            string     `bson:"id" json:"id"`
+	UserID        string     `bson:"user_id" json:"user_id"`
+	MessageType   string     `bson:"message_type" json:"message_type"`
+	Payload       []byte     `bson:"payload" json:"-"`
+	CreatedAt     time.Time  `bson:"created_at" json:"created_at"`
+	Attempts      int        `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastError     string     `bson:"last_error" json:"last_error"`
+	PublishedAt   *time.Time `bson:"published_at" json:"published_at"`
+	LeaseOwner    string     `bson:"lease_owner" json:"lease_owner"`
+	LeaseUntil    *time.Time `bson:"lease_until" json:"lease_until"`
+	ParkedAt      *time.Time `bson:"parked_at" json:"parked_at"`
+}
+
+// OutboxStats summarizes the entries not published yet, OldestCreatedAt is zero if there are no pending ones.
+// Parked entries are not pending, they are not published until they are inspected
+type OutboxStats struct {
+	Pending         int64
+	Parked          int64
+	OldestCreatedAt time.Time
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// RefreshToken is the refresh token model, only the hash of the opaque token is stored.
+// Tokens obtained by rotating the same login share the FamilyID, so that a reused token revokes all of them
+type RefreshToken struct {
+	Hash       string    `bson:"hash" json:"hash"`
+	UserID     string    `bson:"user_id" json:"user_id"`
+	FamilyID   string    `bson:"family_id" json:"family_id"`
+	ReplacedBy string    `bson:"replaced_by" json:"replaced_by"`
+	Revoked    bool      `bson:"revoked" json:"revoked"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,43 @@
+package model
+
+const (
+	// PermissionUsersRead allows to read any user
+	PermissionUsersRead = "users.read"
+	// PermissionUsersWrite allows to update any user
+	PermissionUsersWrite = "users.write"
+	// PermissionUsersDelete allows to delete any user
+	PermissionUsersDelete = "users.delete"
+	// PermissionUsersAdmin allows everything, including the management of roles
+	PermissionUsersAdmin = "users.admin"
+
+	// AdminRole is the built-in role granting PermissionUsersAdmin
+	AdminRole = "admin"
+	// UserRole is the built-in role assigned to every new user, it grants access only to the user itself
+	UserRole = "user"
+)
+
+// Permissions lists all the permissions that can be granted by a role
+var Permissions = []string{PermissionUsersRead, PermissionUsersWrite, PermissionUsersDelete, PermissionUsersAdmin}
+
+// DefaultRoles are the built-in roles, created at startup if they do not exist yet
+var DefaultRoles = []Role{
+	{Name: AdminRole, Description: "Administrator, can do anything", Permissions: []string{PermissionUsersAdmin}},
+	{Name: UserRole, Description: "Regular user, can manage only itself", Permissions: []string{}},
+}
+
+// Role is the role model, it groups the permissions granted to the users having it
+type Role struct {
+	Name        string   `bson:"name" json:"name"`
+	Description string   `bson:"description" json:"description"`
+	Permissions []string `bson:"permissions" json:"permissions"`
+}
+
+// IsValidPermission returns true if the permission is one of the known Permissions
+func IsValidPermission(permission string) bool {
+	for _, current := range Permissions {
+		if current == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,56 @@
 package model
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FieldEmail and FieldNickname are the user fields that must be unique
+	FieldEmail    = "email"
+	FieldNickname = "nickname"
+)
+
 // User is the user model, with bson and json identifiers for marshaling
 type User struct {
-	ID        string `bson:"id" json:"id"`
-	Firstname string `bson:"first_name" json:"first_name"`
-	Lastname  string `bson:"last_name" json:"last_name"`
-	Nickname  string `bson:"nickname" json:"nickname"`
-	Password  string `bson:"password" json:"password"`
-	Email     string `bson:"email" json:"email"`
-	Country   string `bson:"country" json:"country"`
-	CreatedAt string `bson:"created_at" json:"created_at"`
-	UpdatedAt string `bson:"updated_at" json:"updated_at"`
+	ID        string    `bson:"id" json:"id"`
+	Firstname string    `bson:"first_name" json:"first_name"`
+	Lastname  string    `bson:"last_name" json:"last_name"`
+	Nickname  string    `bson:"nickname" json:"nickname"`
+	Password  string    `bson:"password" json:"password"`
+	Email     string    `bson:"email" json:"email"`
+	Country   string    `bson:"country" json:"country"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	Roles     []string  `bson:"roles" json:"roles"`
+	// Version is incremented at each change of the user, updates are applied only to the version they were computed from
+	Version int64 `bson:"version" json:"version"`
+	// DeletedAt is set while the user is deleted, it can be restored until it is purged
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// Normalized values are used for lookups and uniqueness, so that e.g. User@Email.com and user@email.com collide
+	EmailNormalized    string `bson:"email_normalized" json:"-"`
+	NicknameNormalized string `bson:"nickname_normalized" json:"-"`
+	// SearchWords and SearchPrefixes are the indexed words of the names, the nickname and the email, see IndexSearch
+	SearchWords    []string `bson:"search_words" json:"-"`
+	SearchPrefixes []string `bson:"search_prefixes" json:"-"`
+}
+
+// Normalize returns the value used to compare emails and nicknames, trimmed and lower case
+func Normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// Etag returns the opaque version of the user sent to the clients
+func (user *User) Etag() string {
+	return strconv.FormatInt(user.Version, 10)
+}
+
+// ParseEtag returns the version of the user of the etag returned by Etag
+func ParseEtag(etag string) (int64, error) {
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, ErrInvalidEtag
+	}
+	return version, nil
 }
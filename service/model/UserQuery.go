@@ -0,0 +1,162 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Fields of the users that can be filtered and sorted, email and nickname are compared normalized
+const (
+	UserFieldID        = "id"
+	UserFieldFirstname = "firstname"
+	UserFieldLastname  = "lastname"
+	UserFieldNickname  = "nickname"
+	UserFieldEmail     = "email"
+	UserFieldCountry   = "country"
+	UserFieldCreatedAt = "created_at"
+	UserFieldUpdatedAt = "updated_at"
+	UserFieldRoles     = "roles"
+	// UserFieldStatus is UserStatusDeleted for the users with a deletion date, UserStatusActive for the others
+	UserFieldStatus = "status"
+)
+
+// Values of UserFieldStatus
+const (
+	UserStatusActive  = "active"
+	UserStatusDeleted = "deleted"
+)
+
+// FilterOperator combines filters or compares a field with a value
+type FilterOperator string
+
+const (
+	FilterAnd FilterOperator = "AND"
+	FilterOr  FilterOperator = "OR"
+	FilterNot FilterOperator = "NOT"
+	// FilterHas matches the users whose repeated field contains the value
+	FilterHas            FilterOperator = ":"
+	FilterEqual          FilterOperator = "="
+	FilterNotEqual       FilterOperator = "!="
+	FilterLess           FilterOperator = "<"
+	FilterLessOrEqual    FilterOperator = "<="
+	FilterGreater        FilterOperator = ">"
+	FilterGreaterOrEqual FilterOperator = ">="
+	// FilterPrefix and FilterSuffix match the strings starting or ending with the value
+	FilterPrefix FilterOperator = "PREFIX"
+	FilterSuffix FilterOperator = "SUFFIX"
+)
+
+// UserFilter is a parsed filter expression: And, Or and Not combine the Filters, the other operators compare the
+// Field with the Value, a string or a time.Time for the dates
+type UserFilter struct {
+	Operator FilterOperator
+	Filters  []UserFilter
+	Field    string
+	Value    interface{}
+}
+
+// UserOrder sorts the users by the field
+type UserOrder struct {
+	Field      string
+	Descending bool
+}
+
+// DefaultUserOrder sorts the users by creation time, the id makes the order stable between users created at the same time
+var DefaultUserOrder = []UserOrder{{Field: UserFieldCreatedAt}, {Field: UserFieldID}}
+
+// UserQuery selects a page of the users
+type UserQuery struct {
+	// Filter selects the users, all of them if nil
+	Filter *UserFilter
+	// OrderBy sorts the users, it must end with a unique field so that the order is stable. DefaultUserOrder if empty
+	OrderBy []UserOrder
+	// After selects the users sorted after it, the last user of the previous page: only its fields in OrderBy are used
+	After *User
+	// Offset users are skipped, then at most Limit users are returned, all of them if Limit is 0
+	Offset int64
+	Limit  int64
+	// CountTotal counts the users selected by the filter, regardless of After, Offset and Limit
+	CountTotal bool
+	// IncludeDeleted selects the deleted users too, only the users not deleted are selected otherwise
+	IncludeDeleted bool
+}
+
+// UserPage is a page of users, TotalCount is set only if the query counts the users
+type UserPage struct {
+	Users      []User
+	TotalCount int64
+}
+
+// Order returns the order of the query, DefaultUserOrder if it is not set
+func (query *UserQuery) Order() []UserOrder {
+	if len(query.OrderBy) == 0 {
+		return DefaultUserOrder
+	}
+	return query.OrderBy
+}
+
+// FieldValue returns the value of the field compared by the filters and the sort, a string, a time.Time or a []string
+func (user *User) FieldValue(field string) interface{} {
+	switch field {
+	case UserFieldID:
+		return user.ID
+	case UserFieldFirstname:
+		return user.Firstname
+	case UserFieldLastname:
+		return user.Lastname
+	case UserFieldNickname:
+		return user.NicknameNormalized
+	case UserFieldEmail:
+		return user.EmailNormalized
+	case UserFieldCountry:
+		return user.Country
+	case UserFieldCreatedAt:
+		return user.CreatedAt
+	case UserFieldUpdatedAt:
+		return user.UpdatedAt
+	case UserFieldRoles:
+		return user.Roles
+	case UserFieldStatus:
+		if user.DeletedAt != nil {
+			return UserStatusDeleted
+		}
+		return UserStatusActive
+	default:
+		return nil
+	}
+}
+
+// CompareUsers returns a negative number if user is sorted before other in the order, a positive one if it is sorted after
+func CompareUsers(user *User, other *User, order []UserOrder) int {
+	for _, field := range order {
+		comparison := CompareValues(user.FieldValue(field.Field), other.FieldValue(field.Field))
+		if comparison == 0 {
+			continue
+		}
+		if field.Descending {
+			return -comparison
+		}
+		return comparison
+	}
+	return 0
+}
+
+// CompareValues compares two strings byte by byte or two dates, it returns 0 for the other values
+func CompareValues(value interface{}, other interface{}) int {
+	switch value := value.(type) {
+	case string:
+		if other, ok := other.(string); ok {
+			return strings.Compare(value, other)
+		}
+	case time.Time:
+		if other, ok := other.(time.Time); ok {
+			if value.Before(other) {
+				return -1
+			}
+			if value.After(other) {
+				return 1
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaxSearchPrefixLength is the length of the longest indexed prefix, longer searched terms are matched by their prefix
+const MaxSearchPrefixLength = 20
+
+// UserSearch selects a page of the users matching all the terms, ranked by relevance then by creation time and id
+type UserSearch struct {
+	// Terms are the searched words, see SearchWords
+	Terms []string
+	// After selects the results ranked after it, the last result of the previous page
+	After *UserSearchResult
+	// At most Limit results are returned, all of them if Limit is 0
+	Limit int64
+}
+
+// UserSearchResult is a user matching the search with its relevance, the higher the more relevant
+type UserSearchResult struct {
+	User  User
+	Score int64
+}
+
+// SearchWords returns the distinct words of the values, lower case and without accents, e.g. "Zoë O'Neil" gives
+// zoe, o and neil. Words are split on the characters other than letters and digits, like the dots of the emails
+func SearchWords(values ...string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, word := range strings.FieldsFunc(foldAccents(value), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			word = strings.ToLower(word)
+			if !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+// SearchPrefix returns the indexed prefix matching the term, the term itself if it is not longer than MaxSearchPrefixLength
+func SearchPrefix(term string) string {
+	runes := []rune(term)
+	if len(runes) > MaxSearchPrefixLength {
+		return string(runes[:MaxSearchPrefixLength])
+	}
+	return term
+}
+
+// IndexSearch sets the words and the prefixes searched by SearchUsers, it must be called when the names, the nickname or
+// the email change
+func (user *User) IndexSearch() {
+	user.SearchWords = SearchWords(user.Firstname, user.Lastname, user.Nickname, user.Email)
+	seen := make(map[string]bool)
+	user.SearchPrefixes = nil
+	for _, word := range user.SearchWords {
+		runes := []rune(SearchPrefix(word))
+		for length := 1; length <= len(runes); length++ {
+			if prefix := string(runes[:length]); !seen[prefix] {
+				seen[prefix] = true
+				user.SearchPrefixes = append(user.SearchPrefixes, prefix)
+			}
+		}
+	}
+	sort.Strings(user.SearchPrefixes)
+}
+
+// SearchScore returns the relevance of the user for the terms and whether the user matches them: every term must start a
+// word of the user, the terms that are whole words are worth twice as much
+func (user *User) SearchScore(terms []string) (int64, bool) {
+	var score int64
+	for _, term := range terms {
+		if !containsString(user.SearchPrefixes, SearchPrefix(term)) {
+			return 0, false
+		}
+		score++
+		if containsString(user.SearchWords, term) {
+			score++
+		}
+	}
+	return score, true
+}
+
+// CompareSearchResults returns a negative number if result is ranked before other, a positive one if it is ranked after
+func CompareSearchResults(result *UserSearchResult, other *UserSearchResult) int {
+	if result.Score != other.Score {
+		if result.Score > other.Score {
+			return -1
+		}
+		return 1
+	}
+	return CompareUsers(&result.User, &other.User, DefaultUserOrder)
+}
+
+// foldAccents removes the accents of the letters, e.g. é becomes e
+func foldAccents(value string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), value)
+	if err != nil {
+		return value
+	}
+	return folded
+}
+
+func containsString(values []string, value string) bool {
+	index := sort.SearchStrings(values, value)
+	return index < len(values) && values[index] == value
+}
@@ -0,0 +1,139 @@
+package service
+
+// This file implements the pagination of the users lists with page numbers or opaque page tokens
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+	"user/service/api"
+	"user/service/model"
+)
+
+const (
+	// DefaultPageSize is the number of users returned when the request does not set a page size
+	DefaultPageSize = 50
+	// MaxPageSize is the largest number of users returned, larger page sizes are coerced to it
+	MaxPageSize = 1000
+	// MaxSearchQueryLength and MaxSearchTerms limit the searches, so that they stay cheap
+	MaxSearchQueryLength = 200
+	MaxSearchTerms       = 10
+)
+
+// errInvalidPageToken is returned when the page token cannot be decoded or was issued for different filters
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageToken is the content of the opaque page tokens, the position of the last user of the previous page
+type pageToken struct {
+	// Filters are the filters and the order of the request the token was issued for, they must not change between pages
+	Filters   string    `json:"f"`
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+	// Email, Nickname and Country are set only if the users are ordered by them, email and nickname normalized
+	Email    string `json:"e,omitempty"`
+	Nickname string `json:"n,omitempty"`
+	Country  string `json:"o,omitempty"`
+	// Score is the relevance of the last search result
+	Score int64 `json:"s,omitempty"`
+}
+
+// pageSize returns the page size of the request, DefaultPageSize if not set and at most MaxPageSize
+func pageSize(requested int64) int64 {
+	if requested == 0 {
+		return DefaultPageSize
+	}
+	if requested > MaxPageSize {
+		return MaxPageSize
+	}
+	return requested
+}
+
+// requestFilters returns the description of the filters and of the order of the request stored in the page tokens
+func requestFilters(request *api.GetUsersRequest) string {
+	filters := url.Values{}
+	if request.FilterCountry != nil {
+		filters.Set("country", request.FilterCountry.String())
+	}
+	if request.Filter != "" {
+		filters.Set("filter", request.Filter)
+	}
+	if request.OrderBy != "" {
+		filters.Set("order_by", request.OrderBy)
+	}
+	return filters.Encode()
+}
+
+// encodePageToken returns the token of the page following the given last user, sorted in the given order
+func encodePageToken(request *api.GetUsersRequest, order []model.UserOrder, last *model.User) string {
+	token := pageToken{Filters: requestFilters(request), CreatedAt: last.CreatedAt, ID: last.ID}
+	for _, field := range order {
+		switch field.Field {
+		case model.UserFieldEmail:
+			token.Email = last.EmailNormalized
+		case model.UserFieldNickname:
+			token.Nickname = last.NicknameNormalized
+		case model.UserFieldCountry:
+			token.Country = last.Country
+		}
+	}
+	return token.encode()
+}
+
+// decodePageToken returns the last user of the previous page, with only the fields used to sort the users
+func decodePageToken(request *api.GetUsersRequest) (*model.User, error) {
+	token, err := decodeToken(request.PageToken, requestFilters(request))
+	if err != nil {
+		return nil, err
+	}
+	return &model.User{
+		ID:                 token.ID,
+		CreatedAt:          token.CreatedAt,
+		EmailNormalized:    token.Email,
+		NicknameNormalized: token.Nickname,
+		Country:            token.Country,
+	}, nil
+}
+
+// searchFilters returns the description of the search stored in the page tokens
+func searchFilters(request *api.SearchUsersRequest) string {
+	return url.Values{"query": {request.Query}}.Encode()
+}
+
+// encodeSearchPageToken returns the token of the page following the given last search result
+func encodeSearchPageToken(request *api.SearchUsersRequest, last *model.UserSearchResult) string {
+	token := pageToken{Filters: searchFilters(request), CreatedAt: last.User.CreatedAt, ID: last.User.ID, Score: last.Score}
+	return token.encode()
+}
+
+// decodeSearchPageToken returns the last result of the previous page, with only the fields used to rank the results
+func decodeSearchPageToken(request *api.SearchUsersRequest) (*model.UserSearchResult, error) {
+	token, err := decodeToken(request.PageToken, searchFilters(request))
+	if err != nil {
+		return nil, err
+	}
+	return &model.UserSearchResult{User: model.User{ID: token.ID, CreatedAt: token.CreatedAt}, Score: token.Score}, nil
+}
+
+func (token *pageToken) encode() string {
+	// marshaling a struct of strings, numbers and a time cannot fail
+	content, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// decodeToken returns the content of the token, errInvalidPageToken if it was not issued for the given filters
+func decodeToken(encoded string, filters string) (*pageToken, error) {
+	content, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	var token pageToken
+	if err = json.Unmarshal(content, &token); err != nil || token.ID == "" {
+		return nil, errInvalidPageToken
+	}
+	if token.Filters != filters {
+		return nil, errInvalidPageToken
+	}
+	return &token, nil
+}
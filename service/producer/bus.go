@@ -0,0 +1,61 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Event bus backends, selected with the EVENT_BUS setting
+const (
+	BackendKafka  = "kafka"
+	BackendMemory = "memory"
+	BackendFile   = "file"
+)
+
+// EventBus publishes the events relayed from the outbox to the other services
+type EventBus interface {
+	Publisher
+	// Flush waits up to timeout for the published events to be delivered and returns how many are still pending
+	Flush(timeout time.Duration) int
+	// Close releases the resources of the bus, Flush should be called first
+	Close()
+	// Ping checks that the events can be published, e.g. that the Kafka brokers are reachable
+	Ping(ctx context.Context) error
+}
+
+// BusConfig defines the selected backend and its settings
+type BusConfig struct {
+	Backend string
+	Kafka   Config
+	// FilePath is the JSONL file the file backend appends the events to
+	FilePath string
+}
+
+// Config defines the Kafka producer settings.
+// Messages are sent in batches, waiting up to Linger for more messages, and are acknowledged according to Acks ("all", "1" or "0").
+// The idempotent producer avoids duplicates and reordering on retries and requires Acks "all"
+type Config struct {
+	BootstrapServers string
+	Topic            string
+	Acks             string
+	Idempotence      bool
+	Linger           time.Duration
+	BatchSize        int
+	// MessageTimeout bounds the time to deliver a message, retries included
+	MessageTimeout time.Duration
+}
+
+// NewEventBus returns the event bus of the configured backend
+func NewEventBus(config BusConfig) (EventBus, error) {
+	switch config.Backend {
+	case BackendKafka:
+		return newKafkaBus(config.Kafka)
+	case BackendMemory:
+		return NewMemoryBus(), nil
+	case BackendFile:
+		return NewFileBus(config.FilePath)
+	default:
+		return nil, fmt.Errorf("unknown event bus backend %s", config.Backend)
+	}
+}
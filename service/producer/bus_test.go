@@ -0,0 +1,84 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/model"
+)
+
+// EVENT BUS TESTS
+func TestEventBusUnknownBackendKo(t *testing.T) {
+	bus, err := NewEventBus(BusConfig{Backend: "nats"})
+	assert.Nil(t, bus)
+	assert.EqualError(t, err, "unknown event bus backend nats")
+}
+
+func TestMemoryBusOk(t *testing.T) {
+	bus, err := NewEventBus(BusConfig{Backend: BackendMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryBus := bus.(*MemoryBus)
+	var received []string
+	memoryBus.Subscribe(func(event model.Event) {
+		received = append(received, event.GetMetadata().GetEventId())
+	})
+	// run test and validate
+	assert.Nil(t, <-bus.Publish(&api.UserDeleted{Metadata: &api.EventMetadata{EventId: "event-1"}, UserId: "user-1"}))
+	assert.Nil(t, <-bus.Publish(&api.UserDeleted{Metadata: &api.EventMetadata{EventId: "event-2"}, UserId: "user-1"}))
+	assert.Equal(t, []string{"event-1", "event-2"}, received)
+	assert.Len(t, memoryBus.Events(), 2)
+	assert.Equal(t, 0, bus.Flush(time.Second))
+}
+
+func TestFileBusOk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	bus, err := NewEventBus(BusConfig{Backend: BackendFile, FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// run test and validate
+	assert.Nil(t, <-bus.Publish(&api.UserCreated{Metadata: &api.EventMetadata{EventId: "event-1"}, UserId: "user-1", User: &api.User{Id: "user-1"}}))
+	assert.Nil(t, <-bus.Publish(&api.UserDeleted{Metadata: &api.EventMetadata{EventId: "event-2"}, UserId: "user-1"}))
+	assert.Equal(t, 0, bus.Flush(time.Second))
+	bus.Close()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []FileRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record FileRecord
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "user-1", records[0].Key)
+		assert.Equal(t, "user.UserCreated", records[0].MessageType)
+		assert.Equal(t, "user.UserDeleted", records[1].MessageType)
+		var created api.UserCreated
+		assert.Nil(t, protojson.Unmarshal(records[0].Event, &created))
+		assert.Equal(t, "event-1", created.Metadata.EventId)
+	}
+}
+
+func TestFileBusPingOk(t *testing.T) {
+	bus, err := NewEventBus(BusConfig{Backend: BackendFile, FilePath: filepath.Join(t.TempDir(), "events.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// run test and validate
+	assert.NoError(t, bus.Ping(context.Background()))
+	bus.Close()
+	assert.Error(t, bus.Ping(context.Background()))
+}
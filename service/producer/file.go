@@ -0,0 +1,83 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/protobuf/encoding/protojson"
+	"os"
+	"sync"
+	"time"
+	"user/service/model"
+)
+
+// FileRecord is a line of the file written by FileBus
+type FileRecord struct {
+	Key         string          `json:"key"`
+	MessageType string          `json:"message_type"`
+	Event       json.RawMessage `json:"event"`
+}
+
+// FileBus appends the events to a local JSONL file, one FileRecord per line with the event in protobuf JSON format
+type FileBus struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileBus opens the file in append mode, creating it if needed
+func NewFileBus(path string) (*FileBus, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileBus{file: file}, nil
+}
+
+// Publish appends the event to the file, the delivery result is available as soon as the line is written
+func (bus *FileBus) Publish(event model.Event) <-chan error {
+	result := make(chan error, 1)
+	result <- bus.write(event)
+	return result
+}
+
+func (bus *FileBus) write(event model.Event) error {
+	value, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(FileRecord{
+		Key:         event.GetUserId(),
+		MessageType: string(event.ProtoReflect().Descriptor().FullName()),
+		Event:       value,
+	})
+	if err != nil {
+		return err
+	}
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	_, err = bus.file.Write(append(line, '\n'))
+	return err
+}
+
+// Flush commits the written events to disk
+func (bus *FileBus) Flush(timeout time.Duration) int {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if err := bus.file.Sync(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// Ping checks that the file is still open
+func (bus *FileBus) Ping(ctx context.Context) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	_, err := bus.file.Stat()
+	return err
+}
+
+func (bus *FileBus) Close() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.file.Close()
+}
@@ -0,0 +1,60 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user/service/model"
+)
+
+// MemoryBus keeps the published events in memory and hands them to its subscribers, for tests and single node development
+type MemoryBus struct {
+	mutex       sync.Mutex
+	events      []model.Event
+	subscribers []func(event model.Event)
+}
+
+// NewMemoryBus is used to create a MemoryBus object
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish stores the event and calls the subscribers, the delivery always succeeds
+func (bus *MemoryBus) Publish(event model.Event) <-chan error {
+	bus.mutex.Lock()
+	bus.events = append(bus.events, event)
+	subscribers := bus.subscribers
+	bus.mutex.Unlock()
+	for _, subscriber := range subscribers {
+		subscriber(event)
+	}
+	result := make(chan error, 1)
+	result <- nil
+	return result
+}
+
+// Subscribe registers a function called with every event published from now on
+func (bus *MemoryBus) Subscribe(subscriber func(event model.Event)) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.subscribers = append(bus.subscribers, subscriber)
+}
+
+// Events returns the events published so far, in publication order
+func (bus *MemoryBus) Events() []model.Event {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return append([]model.Event(nil), bus.events...)
+}
+
+// Flush has nothing to wait for, events are delivered on Publish
+func (bus *MemoryBus) Flush(timeout time.Duration) int {
+	return 0
+}
+
+func (bus *MemoryBus) Close() {}
+
+// Ping always succeeds, the events are kept in memory
+func (bus *MemoryBus) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package producer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outbox metrics, exposed on the metrics endpoint of the service
+var (
+	outboxPendingEvents = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "user_outbox_pending_events",
+		Help: "Number of events stored in the outbox and not published yet.",
+	})
+	outboxParkedEvents = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "user_outbox_parked_events",
+		Help: "Number of events the relay stopped trying to publish.",
+	})
+	outboxLagSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "user_outbox_lag_seconds",
+		Help: "Age of the oldest event not published yet, 0 if the outbox is drained.",
+	})
+	outboxPublishedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_outbox_published_events_total",
+		Help: "Number of events published by the outbox relay.",
+	})
+	outboxFailedPublications = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_outbox_failed_publications_total",
+		Help: "Number of failed attempts to publish an outbox event.",
+	})
+)
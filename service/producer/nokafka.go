@@ -0,0 +1,11 @@
+//go:build nokafka
+// +build nokafka
+
+package producer
+
+import "errors"
+
+// newKafkaBus is not available without librdkafka, the service is built with the nokafka tag to avoid cgo
+func newKafkaBus(config Config) (EventBus, error) {
+	return nil, errors.New("kafka event bus not available, the service was built with the nokafka tag")
+}
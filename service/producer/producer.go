@@ -1,40 +1,144 @@
+//go:build !nokafka
+// +build !nokafka
+
 package producer
 
 import (
+	"context"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"time"
+	"user/service/model"
+)
+
+const (
+	// pingTimeout bounds the metadata request of Ping when the context has no deadline
+	pingTimeout = 5 * time.Second
+	// ContentType is the content-type header of the published events
+	ContentType = "application/x-protobuf"
+	// contentTypeHeader and messageTypeHeader let consumers pick the message to unmarshal the event into
+	contentTypeHeader = "content-type"
+	messageTypeHeader = "message-type"
 )
 
+// Producer publishes the events to Kafka asynchronously, the delivery reports are dispatched by a dedicated goroutine
 type Producer struct {
 	broker *kafka.Producer
 	topic  string
+	done   chan struct{}
 }
 
 // New is used to create a Producer object
-func New(broker *kafka.Producer, topic string) *Producer {
-	return &Producer{
-		broker,
-		topic,
+func New(config Config) (*Producer, error) {
+	if config.Idempotence && config.Acks != "all" {
+		return nil, fmt.Errorf("the idempotent producer requires acks all, got %s", config.Acks)
+	}
+	broker, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  config.BootstrapServers,
+		"acks":               config.Acks,
+		"enable.idempotence": config.Idempotence,
+		"linger.ms":          int(config.Linger.Milliseconds()),
+		"batch.num.messages": config.BatchSize,
+		"message.timeout.ms": int(config.MessageTimeout.Milliseconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	kafkaProducer := &Producer{
+		broker: broker,
+		topic:  config.Topic,
+		done:   make(chan struct{}),
 	}
+	go kafkaProducer.handleEvents()
+	return kafkaProducer, nil
 }
 
-// PublishMessage send a message to the Kafka users_topic
-func (kafkaProducer *Producer) PublishMessage(message string) error {
+// newKafkaBus returns a Producer as EventBus
+func newKafkaBus(config Config) (EventBus, error) {
+	kafkaProducer, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return kafkaProducer, nil
+}
+
+// Publish enqueues an event for the Kafka users_topic, serialized in protobuf binary format, without waiting for the delivery.
+// The returned channel receives the delivery result once Kafka acknowledges the message or the delivery fails.
+// The user id is the key of the message, so that all the events of a user go to the same partition and are ordered
+func (kafkaProducer *Producer) Publish(event model.Event) <-chan error {
+	result := make(chan error, 1)
+	messageType := string(event.ProtoReflect().Descriptor().FullName())
+	value, err := proto.Marshal(event)
+	if err != nil {
+		log.Error("unable to serialize event ", messageType, " ", err)
+		result <- err
+		return result
+	}
 	producerErr := kafkaProducer.broker.Produce(&kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &kafkaProducer.topic, Partition: kafka.PartitionAny},
-		Value:          []byte(message),
+		Key:            []byte(event.GetUserId()),
+		Value:          value,
+		Headers: []kafka.Header{
+			{Key: contentTypeHeader, Value: []byte(ContentType)},
+			{Key: messageTypeHeader, Value: []byte(messageType)},
+		},
+		Opaque: result,
 	}, nil)
 	if producerErr != nil {
-		log.Error("unable to enqueue message ", message)
-		return producerErr
-	}
-	event := <-kafkaProducer.broker.Events()
-	kafkaMessage := event.(*kafka.Message)
-	if kafkaMessage.TopicPartition.Error != nil {
-		log.Error("Delivery failed due to error ", kafkaMessage.TopicPartition.Error)
-		return kafkaMessage.TopicPartition.Error
-	} else {
-		log.Warn("Delivered message to offset " + kafkaMessage.TopicPartition.Offset.String() + " in partition " + kafkaMessage.TopicPartition.String())
-		return nil
+		log.Error("unable to enqueue event ", messageType, " ", event.GetMetadata().GetEventId())
+		result <- producerErr
+	}
+	return result
+}
+
+// handleEvents dispatches the delivery reports to the channel of their message until the producer is closed
+func (kafkaProducer *Producer) handleEvents() {
+	defer close(kafkaProducer.done)
+	for kafkaEvent := range kafkaProducer.broker.Events() {
+		switch kafkaEvent := kafkaEvent.(type) {
+		case *kafka.Message:
+			result, ok := kafkaEvent.Opaque.(chan error)
+			if !ok {
+				continue
+			}
+			if kafkaEvent.TopicPartition.Error != nil {
+				log.Error("Delivery failed due to error ", kafkaEvent.TopicPartition.Error)
+			} else {
+				log.Debug("Delivered message to offset " + kafkaEvent.TopicPartition.Offset.String() + " in partition " + kafkaEvent.TopicPartition.String())
+			}
+			result <- kafkaEvent.TopicPartition.Error
+		case kafka.Error:
+			log.Error("Kafka producer error ", kafkaEvent)
+		}
+	}
+}
+
+// Flush waits up to timeout for the enqueued messages to be delivered and returns how many are still pending
+func (kafkaProducer *Producer) Flush(timeout time.Duration) int {
+	return kafkaProducer.broker.Flush(int(timeout.Milliseconds()))
+}
+
+// Ping requests the metadata of the topic, that fails if no broker is reachable
+func (kafkaProducer *Producer) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout := pingTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		// librdkafka waits forever on a negative timeout
+		if timeout < time.Millisecond {
+			return context.DeadlineExceeded
+		}
 	}
+	_, err := kafkaProducer.broker.GetMetadata(&kafkaProducer.topic, false, int(timeout.Milliseconds()))
+	return err
+}
+
+// Close stops the producer, messages not delivered yet are dropped so Flush should be called first
+func (kafkaProducer *Producer) Close() {
+	kafkaProducer.broker.Close()
+	<-kafkaProducer.done
 }
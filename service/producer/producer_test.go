@@ -0,0 +1,72 @@
+//go:build !nokafka
+// +build !nokafka
+
+package producer
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"user/service/api"
+)
+
+// PRODUCER TESTS
+func TestProducerIdempotenceRequiresAcksAllKo(t *testing.T) {
+	kafkaProducer, err := New(Config{BootstrapServers: "localhost:9092", Topic: "users_topic", Acks: "1", Idempotence: true})
+	assert.Nil(t, kafkaProducer)
+	assert.EqualError(t, err, "the idempotent producer requires acks all, got 1")
+}
+
+func TestProducerDeliveryReportKo(t *testing.T) {
+	// no broker listens on the port, the message times out and the delivery report reaches its own channel
+	kafkaProducer, err := New(Config{
+		BootstrapServers: "localhost:1",
+		Topic:            "users_topic",
+		Acks:             "all",
+		Idempotence:      true,
+		Linger:           time.Millisecond,
+		BatchSize:        100,
+		MessageTimeout:   100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := kafkaProducer.Publish(&api.UserDeleted{Metadata: &api.EventMetadata{EventId: "event-1"}, UserId: "user-1"})
+	second := kafkaProducer.Publish(&api.UserDeleted{Metadata: &api.EventMetadata{EventId: "event-2"}, UserId: "user-2"})
+	for _, result := range []<-chan error{first, second} {
+		select {
+		case err = <-result:
+			assert.NotNil(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("delivery report not received")
+		}
+	}
+	assert.Equal(t, 0, kafkaProducer.Flush(time.Second))
+	kafkaProducer.Close()
+}
+
+func TestProducerPingDeadlineKo(t *testing.T) {
+	kafkaProducer, err := New(Config{
+		BootstrapServers: "localhost:1",
+		Topic:            "users_topic",
+		Acks:             "all",
+		Idempotence:      true,
+		Linger:           time.Millisecond,
+		BatchSize:        100,
+		MessageTimeout:   100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kafkaProducer.Close()
+	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	// run test and validate, the metadata are not requested past the deadline
+	start := time.Now()
+	assert.ErrorIs(t, kafkaProducer.Ping(expiredCtx), context.DeadlineExceeded)
+	pingCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, kafkaProducer.Ping(pingCtx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
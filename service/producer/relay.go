@@ -0,0 +1,215 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"time"
+	"user/service/model"
+)
+
+// OutboxInterface defines the outbox operations used by the Relay
+type OutboxInterface interface {
+	ClaimPendingEvents(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.OutboxEntry, error)
+	MarkEventPublished(ctx context.Context, id string, publishedAt time.Time) error
+	MarkEventFailed(ctx context.Context, id string, userID string, nextAttemptAt time.Time, lastError string) error
+	ParkEvent(ctx context.Context, id string, parkedAt time.Time, lastError string) error
+	GetOutboxStats(ctx context.Context) (*model.OutboxStats, error)
+}
+
+// Publisher sends the events to the other services
+type Publisher interface {
+	// Publish sends the event asynchronously, the returned channel receives the delivery result
+	Publish(event model.Event) <-chan error
+}
+
+// RelayConfig defines how often the outbox is drained and how failed publications are retried
+type RelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// The delay before a retry doubles at every failed attempt, from MinBackoff up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long the claimed entries are reserved to the relay, it must exceed the time needed to publish a batch
+	Lease time.Duration
+	// MaxAttempts is the number of failed attempts after which an entry is parked, 0 retries forever
+	MaxAttempts int
+}
+
+// Relay publishes the events stored in the outbox. Entries are marked as published only after a successful publication,
+// so an event can be published more than once (at-least-once) and consumers should discard duplicates by event id
+type Relay struct {
+	outbox    OutboxInterface
+	publisher Publisher
+	config    RelayConfig
+	// owner identifies the leases of the relay among the replicas of the service
+	owner string
+	now   func() time.Time
+}
+
+// NewRelay is used to create a Relay object, the durations and the batch size must be positive
+func NewRelay(outbox OutboxInterface, publisher Publisher, config RelayConfig) (*Relay, error) {
+	if config.PollInterval <= 0 || config.BatchSize <= 0 || config.Lease <= 0 || config.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid outbox settings: poll interval %s, batch size %d, lease %s, max attempts %d",
+			config.PollInterval, config.BatchSize, config.Lease, config.MaxAttempts)
+	}
+	if config.MinBackoff <= 0 || config.MaxBackoff < config.MinBackoff {
+		return nil, fmt.Errorf("invalid outbox backoff between %s and %s", config.MinBackoff, config.MaxBackoff)
+	}
+	return &Relay{outbox: outbox, publisher: publisher, config: config, owner: uuid.New().String(), now: time.Now}, nil
+}
+
+// Run drains the outbox every PollInterval until the context is done
+func (relay *Relay) Run(ctx context.Context) {
+	log.Info("Starting outbox relay")
+	ticker := time.NewTicker(relay.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped outbox relay")
+			return
+		case <-ticker.C:
+			if _, err := relay.Drain(ctx); err != nil {
+				log.Error("Error while draining the outbox ", err)
+			}
+		}
+	}
+}
+
+// delivery is an outbox entry sent to the publisher and waiting for its delivery result
+type delivery struct {
+	entry  *model.OutboxEntry
+	result <-chan error
+}
+
+// Drain claims a batch of pending entries, publishes them and returns how many were published.
+// The entries are sent in waves holding the next entry of every user, so that a user entry is sent only after the previous one
+// was delivered: once an entry fails, the following entries of the user wait for its retry and are not published out of order
+func (relay *Relay) Drain(ctx context.Context) (int, error) {
+	entries, err := relay.outbox.ClaimPendingEvents(ctx, relay.owner, relay.now(), relay.config.Lease, relay.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var users []string
+	queues := make(map[string][]*model.OutboxEntry)
+	for i := range entries {
+		entry := &entries[i]
+		if _, ok := queues[entry.UserID]; !ok {
+			users = append(users, entry.UserID)
+		}
+		queues[entry.UserID] = append(queues[entry.UserID], entry)
+	}
+	published := 0
+	for len(users) > 0 {
+		var deliveries []delivery
+		var next []string
+		for _, userID := range users {
+			entry := queues[userID][0]
+			queues[userID] = queues[userID][1:]
+			event, err := decode(entry)
+			if err != nil {
+				// the entry will never be published, it does not block the following ones
+				if err = relay.park(ctx, entry, err); err != nil {
+					return published, err
+				}
+				if len(queues[userID]) > 0 {
+					next = append(next, userID)
+				}
+				continue
+			}
+			deliveries = append(deliveries, delivery{entry: entry, result: relay.publisher.Publish(event)})
+		}
+		for _, delivery := range deliveries {
+			var deliveryErr error
+			select {
+			case <-ctx.Done():
+				// the entries not marked yet are published again once their lease expires
+				return published, ctx.Err()
+			case deliveryErr = <-delivery.result:
+			}
+			if deliveryErr != nil {
+				if err = relay.markFailed(ctx, delivery.entry, deliveryErr); err != nil {
+					return published, err
+				}
+				continue
+			}
+			outboxPublishedEvents.Inc()
+			published++
+			if err = relay.outbox.MarkEventPublished(ctx, delivery.entry.ID, relay.now()); err != nil {
+				return published, err
+			}
+			if len(queues[delivery.entry.UserID]) > 0 {
+				next = append(next, delivery.entry.UserID)
+			}
+		}
+		users = next
+	}
+	return published, relay.updateLag(ctx)
+}
+
+// markFailed schedules the next attempt of the entry, or parks it once it failed MaxAttempts times
+func (relay *Relay) markFailed(ctx context.Context, entry *model.OutboxEntry, cause error) error {
+	outboxFailedPublications.Inc()
+	if relay.config.MaxAttempts > 0 && entry.Attempts+1 >= relay.config.MaxAttempts {
+		return relay.park(ctx, entry, cause)
+	}
+	nextAttemptAt := relay.now().Add(relay.backoff(entry.Attempts))
+	log.Warn("Failed to publish event ", entry.ID, ", retrying at ", nextAttemptAt, " ", cause)
+	return relay.outbox.MarkEventFailed(ctx, entry.ID, entry.UserID, nextAttemptAt, cause.Error())
+}
+
+// park stops the publication attempts of the entry, it is left in the outbox to be inspected
+func (relay *Relay) park(ctx context.Context, entry *model.OutboxEntry, cause error) error {
+	log.Error("Cannot publish event ", entry.ID, ", parking it ", cause)
+	return relay.outbox.ParkEvent(ctx, entry.ID, relay.now(), cause.Error())
+}
+
+// decode returns the stored event
+func decode(entry *model.OutboxEntry) (model.Event, error) {
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(entry.MessageType))
+	if err != nil {
+		return nil, fmt.Errorf("unknown message type %s: %w", entry.MessageType, err)
+	}
+	message := messageType.New().Interface()
+	if err = proto.Unmarshal(entry.Payload, message); err != nil {
+		return nil, err
+	}
+	event, ok := message.(model.Event)
+	if !ok {
+		return nil, fmt.Errorf("message type %s is not an event", entry.MessageType)
+	}
+	return event, nil
+}
+
+// backoff returns the delay before the next attempt of an entry that already failed the given times
+func (relay *Relay) backoff(attempts int) time.Duration {
+	delay := relay.config.MinBackoff
+	for i := 0; i < attempts && delay < relay.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > relay.config.MaxBackoff {
+		return relay.config.MaxBackoff
+	}
+	return delay
+}
+
+// updateLag updates the outbox metrics
+func (relay *Relay) updateLag(ctx context.Context) error {
+	stats, err := relay.outbox.GetOutboxStats(ctx)
+	if err != nil {
+		return err
+	}
+	outboxPendingEvents.Set(float64(stats.Pending))
+	outboxParkedEvents.Set(float64(stats.Parked))
+	if stats.Pending == 0 {
+		outboxLagSeconds.Set(0)
+		return nil
+	}
+	outboxLagSeconds.Set(relay.now().Sub(stats.OldestCreatedAt).Seconds())
+	return nil
+}
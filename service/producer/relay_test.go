@@ -0,0 +1,173 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/mocks"
+	"user/service/model"
+)
+
+var ctx = context.Background()
+
+// now is the fixed time of the relay in tests
+var now = time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func setupRelay() (*mocks.OutboxInterface, *mocks.Publisher, *Relay) {
+	outbox := new(mocks.OutboxInterface)
+	publisher := new(mocks.Publisher)
+	relay, _ := NewRelay(outbox, publisher, RelayConfig{
+		PollInterval: time.Second,
+		BatchSize:    10,
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+		Lease:        time.Minute,
+		MaxAttempts:  5,
+	})
+	relay.owner = "relay"
+	relay.now = func() time.Time { return now }
+	return outbox, publisher, relay
+}
+
+func outboxEntry(t *testing.T, eventID string, userID string) model.OutboxEntry {
+	payload, err := proto.Marshal(&api.UserDeleted{Metadata: &api.EventMetadata{EventId: eventID}, UserId: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.OutboxEntry{ID: eventID, UserID: userID, MessageType: "user.UserDeleted", Payload: payload, CreatedAt: now, NextAttemptAt: now}
+}
+
+// delivered returns the delivery result of a published event
+func delivered(err error) <-chan error {
+	result := make(chan error, 1)
+	result <- err
+	return result
+}
+
+func isEvent(eventID string) interface{} {
+	return mock.MatchedBy(func(event model.Event) bool {
+		return event.GetMetadata().GetEventId() == eventID
+	})
+}
+
+// RELAY TESTS
+func TestRelayDrainOk(t *testing.T) {
+	outbox, publisher, relay := setupRelay()
+	entries := []model.OutboxEntry{outboxEntry(t, "event-1", "user-1"), outboxEntry(t, "event-2", "user-2")}
+	outbox.On("ClaimPendingEvents", ctx, "relay", now, time.Minute, 10).Return(entries, nil)
+	publisher.On("Publish", isEvent("event-1")).Return(delivered(nil))
+	publisher.On("Publish", isEvent("event-2")).Return(delivered(nil))
+	outbox.On("MarkEventPublished", ctx, "event-1", now).Return(nil)
+	outbox.On("MarkEventPublished", ctx, "event-2", now).Return(nil)
+	outbox.On("GetOutboxStats", ctx).Return(&model.OutboxStats{}, nil)
+	// run test and validate
+	published, err := relay.Drain(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, published)
+	outbox.AssertExpectations(t)
+}
+
+func TestRelayDrainFailureKo(t *testing.T) {
+	outbox, publisher, relay := setupRelay()
+	failed := outboxEntry(t, "event-1", "user-1")
+	failed.Attempts = 2
+	entries := []model.OutboxEntry{failed, outboxEntry(t, "event-2", "user-2")}
+	outbox.On("ClaimPendingEvents", ctx, "relay", now, time.Minute, 10).Return(entries, nil)
+	publisher.On("Publish", isEvent("event-1")).Return(delivered(errors.New("broker down")))
+	publisher.On("Publish", isEvent("event-2")).Return(delivered(nil))
+	// third attempt waits 1s * 2^2
+	outbox.On("MarkEventFailed", ctx, "event-1", "user-1", now.Add(4*time.Second), "broker down").Return(nil)
+	outbox.On("MarkEventPublished", ctx, "event-2", now).Return(nil)
+	outbox.On("GetOutboxStats", ctx).Return(&model.OutboxStats{Pending: 1, OldestCreatedAt: now.Add(-time.Minute)}, nil)
+	// run test and validate
+	published, err := relay.Drain(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, published)
+	outbox.AssertExpectations(t)
+}
+
+func TestRelayDrainUserOrderOk(t *testing.T) {
+	outbox, publisher, relay := setupRelay()
+	entries := []model.OutboxEntry{outboxEntry(t, "event-1", "user-1"), outboxEntry(t, "event-2", "user-1"), outboxEntry(t, "event-3", "user-2")}
+	outbox.On("ClaimPendingEvents", ctx, "relay", now, time.Minute, 10).Return(entries, nil)
+	var sent []string
+	for _, entry := range entries {
+		publisher.On("Publish", isEvent(entry.ID)).Run(func(args mock.Arguments) {
+			sent = append(sent, args.Get(0).(model.Event).GetMetadata().GetEventId())
+		}).Return(delivered(nil))
+	}
+	outbox.On("MarkEventPublished", ctx, mock.Anything, now).Return(nil)
+	outbox.On("GetOutboxStats", ctx).Return(&model.OutboxStats{}, nil)
+	// run test and validate
+	published, err := relay.Drain(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, published)
+	// the second event of user-1 is sent after the delivery of the first one
+	assert.Equal(t, []string{"event-1", "event-3", "event-2"}, sent)
+}
+
+func TestRelayDrainFailureBlocksUserKo(t *testing.T) {
+	outbox, publisher, relay := setupRelay()
+	entries := []model.OutboxEntry{outboxEntry(t, "event-1", "user-1"), outboxEntry(t, "event-2", "user-1"), outboxEntry(t, "event-3", "user-2")}
+	outbox.On("ClaimPendingEvents", ctx, "relay", now, time.Minute, 10).Return(entries, nil)
+	publisher.On("Publish", isEvent("event-1")).Return(delivered(errors.New("broker down")))
+	publisher.On("Publish", isEvent("event-3")).Return(delivered(nil))
+	outbox.On("MarkEventFailed", ctx, "event-1", "user-1", now.Add(time.Second), "broker down").Return(nil)
+	outbox.On("MarkEventPublished", ctx, "event-3", now).Return(nil)
+	outbox.On("GetOutboxStats", ctx).Return(&model.OutboxStats{Pending: 2, OldestCreatedAt: now}, nil)
+	// run test and validate
+	published, err := relay.Drain(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, published)
+	// the second event of user-1 is not published before the first one
+	publisher.AssertNotCalled(t, "Publish", isEvent("event-2"))
+	outbox.AssertExpectations(t)
+}
+
+func TestRelayDrainMaxAttemptsParksKo(t *testing.T) {
+	outbox, publisher, relay := setupRelay()
+	poison := outboxEntry(t, "event-1", "user-1")
+	poison.Attempts = 4
+	outbox.On("ClaimPendingEvents", ctx, "relay", now, time.Minute, 10).Return([]model.OutboxEntry{poison}, nil)
+	publisher.On("Publish", isEvent("event-1")).Return(delivered(errors.New("message too large")))
+	outbox.On("ParkEvent", ctx, "event-1", now, "message too large").Return(nil)
+	outbox.On("GetOutboxStats", ctx).Return(&model.OutboxStats{Parked: 1}, nil)
+	// run test and validate
+	published, err := relay.Drain(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, published)
+	outbox.AssertExpectations(t)
+	outbox.AssertNotCalled(t, "MarkEventFailed", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRelayBackoffOk(t *testing.T) {
+	_, _, relay := setupRelay()
+	assert.Equal(t, time.Second, relay.backoff(0))
+	assert.Equal(t, 8*time.Second, relay.backoff(3))
+	assert.Equal(t, time.Minute, relay.backoff(10))
+}
+
+func TestNewRelayKo(t *testing.T) {
+	valid := RelayConfig{PollInterval: time.Second, BatchSize: 10, MinBackoff: time.Second, MaxBackoff: time.Minute, Lease: time.Minute}
+	invalidConfigs := []func(config *RelayConfig){
+		func(config *RelayConfig) { config.PollInterval = 0 },
+		func(config *RelayConfig) { config.BatchSize = 0 },
+		func(config *RelayConfig) { config.Lease = -time.Minute },
+		func(config *RelayConfig) { config.MaxAttempts = -1 },
+		func(config *RelayConfig) { config.MinBackoff = 0 },
+		func(config *RelayConfig) { config.MaxBackoff = time.Millisecond },
+	}
+	for _, invalidate := range invalidConfigs {
+		config := valid
+		invalidate(&config)
+		// run test and validate
+		relay, err := NewRelay(new(mocks.OutboxInterface), new(mocks.Publisher), config)
+		assert.Error(t, err)
+		assert.Nil(t, relay)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+// This file implements the purge of the deleted users once their grace period is over
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+	"user/service/model"
+)
+
+// PurgeConfig defines how long the deleted users can be restored and how often the expired ones are purged
+type PurgeConfig struct {
+	GracePeriod time.Duration
+	Interval    time.Duration
+	// BatchSize is the number of users purged in a single transaction
+	BatchSize int
+}
+
+// Purger removes permanently the users deleted for longer than the grace period, a UserPurged event is sent for each of them
+type Purger struct {
+	repository RepositoryInterface
+	config     PurgeConfig
+	now        func() time.Time
+}
+
+// NewPurger is used to create a Purger object, the interval and the batch size must be positive
+func NewPurger(repository RepositoryInterface, config PurgeConfig) (*Purger, error) {
+	if config.GracePeriod < 0 || config.Interval <= 0 || config.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid purge settings: grace period %s, interval %s, batch size %d",
+			config.GracePeriod, config.Interval, config.BatchSize)
+	}
+	return &Purger{repository: repository, config: config, now: time.Now}, nil
+}
+
+// Run purges the expired users every Interval until the context is done
+func (purger *Purger) Run(ctx context.Context) {
+	log.Info("Starting purge of the deleted users")
+	ticker := time.NewTicker(purger.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped purge of the deleted users")
+			return
+		case <-ticker.C:
+			if _, err := purger.Purge(ctx); err != nil {
+				log.Error("Error while purging the deleted users ", err)
+			}
+		}
+	}
+}
+
+// Purge removes the users deleted before the grace period, one batch per transaction, and returns how many were purged
+func (purger *Purger) Purge(ctx context.Context) (int, error) {
+	deletedBefore := purger.now().Add(-purger.config.GracePeriod)
+	purged := 0
+	for ctx.Err() == nil {
+		var users []model.User
+		err := purger.repository.RunInTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			users, err = purger.repository.PurgeDeletedUsers(ctx, deletedBefore, purger.config.BatchSize)
+			if err != nil {
+				return err
+			}
+			for i := range users {
+				if err = purger.repository.EnqueueEvent(ctx, newUserPurged(ctx, &users[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += len(users)
+		if len(users) < purger.config.BatchSize {
+			break
+		}
+	}
+	if purged > 0 {
+		log.Info("Purged ", purged, " deleted users")
+	}
+	return purged, ctx.Err()
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/mocks"
+	"user/service/model"
+)
+
+func setupPurger(batchSize int) (*mocks.RepositoryInterface, *Purger) {
+	repository := new(mocks.RepositoryInterface)
+	repository.On("RunInTransaction", mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, fn func(context.Context) error) error {
+			return fn(ctx)
+		})
+	purger, _ := NewPurger(repository, PurgeConfig{GracePeriod: 24 * time.Hour, Interval: time.Hour, BatchSize: batchSize})
+	purger.now = func() time.Time { return time.Date(2022, 3, 2, 12, 0, 0, 0, time.UTC) }
+	return repository, purger
+}
+
+// PURGER TESTS
+func TestPurgerPurgeOk(t *testing.T) {
+	repository, purger := setupPurger(2)
+	deletedBefore := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	// batches are purged until one is not full
+	repository.On("PurgeDeletedUsers", ctx, deletedBefore, 2).Return([]model.User{{ID: "first"}, {ID: "second"}}, nil).Once()
+	repository.On("PurgeDeletedUsers", ctx, deletedBefore, 2).Return([]model.User{{ID: "third"}}, nil).Once()
+	for _, userID := range []string{"first", "second", "third"} {
+		userID := userID
+		repository.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserPurged) bool {
+			return event.UserId == userID
+		})).Return(nil).Once()
+	}
+	// run test and validate
+	purged, err := purger.Purge(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+	repository.AssertExpectations(t)
+}
+
+func TestPurgerPurgeErrorKo(t *testing.T) {
+	repository, purger := setupPurger(1)
+	repository.On("PurgeDeletedUsers", ctx, mock.AnythingOfType("time.Time"), 1).Return([]model.User{{ID: "first"}}, nil).Once()
+	repository.On("EnqueueEvent", ctx, mock.AnythingOfType("*api.UserPurged")).Return(nil).Once()
+	repository.On("PurgeDeletedUsers", ctx, mock.AnythingOfType("time.Time"), 1).Return(nil, errors.New("repository error")).Once()
+	// run test and validate
+	purged, err := purger.Purge(ctx)
+	assert.EqualError(t, err, "repository error")
+	assert.Equal(t, 1, purged)
+}
+
+func TestNewPurgerKo(t *testing.T) {
+	for _, config := range []PurgeConfig{
+		{GracePeriod: time.Hour, Interval: time.Hour, BatchSize: 0},
+		{GracePeriod: time.Hour, Interval: 0, BatchSize: 100},
+		{GracePeriod: time.Hour, Interval: -time.Hour, BatchSize: 100},
+		{GracePeriod: -time.Hour, Interval: time.Hour, BatchSize: 100},
+	} {
+		// run test and validate
+		purger, err := NewPurger(new(mocks.RepositoryInterface), config)
+		assert.Error(t, err)
+		assert.Nil(t, purger)
+	}
+}
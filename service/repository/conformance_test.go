@@ -0,0 +1,965 @@
+package repository
+
+// The conformance tests run the same cases against every implementation of the data layer, so that they cannot drift.
+// The MongoDB implementation is tested only if MONGODB_TEST_HOST is set, e.g. mongodb://localhost:27017/?replicaSet=rs0,
+// the users_collection database of that instance is dropped before each case. Transactions require a replica set.
+// The PostgreSQL implementation is tested only if POSTGRES_TEST_DSN is set, e.g. started with scripts/run_postgres_test.sh,
+// the public schema of that database is dropped before each case
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user/service"
+	"user/service/api"
+	"user/service/model"
+	"user/service/producer"
+	"user/service/utility"
+)
+
+const notExError = "Not expected error: "
+
+var ctx = context.Background()
+
+// storage is implemented by every data layer of the service
+type storage interface {
+	service.RepositoryInterface
+	service.TokenRepositoryInterface
+	service.RoleRepositoryInterface
+	producer.OutboxInterface
+	ListStuckEvents(ctx context.Context, minAttempts int, limit int) ([]model.OutboxEntry, error)
+	SeedRoles(ctx context.Context, roles []model.Role) error
+	Ping(ctx context.Context) error
+}
+
+var conformanceCases = []struct {
+	name string
+	run  func(t *testing.T, repo storage)
+}{
+	{"Ping", testPing},
+	{"CreateAndGetUser", testCreateAndGetUser},
+	{"UniqueEmailAndNickname", testUniqueEmailAndNickname},
+	{"ConcurrentCreates", testConcurrentCreates},
+	{"GetUsersPaginated", testGetUsersPaginated},
+	{"GetUsersAfter", testGetUsersAfter},
+	{"GetUsersFilter", testGetUsersFilter},
+	{"GetUsersOrder", testGetUsersOrder},
+	{"SearchUsers", testSearchUsers},
+	{"UpdateUser", testUpdateUser},
+	{"UserVersions", testUserVersions},
+	{"UserDates", testUserDates},
+	{"DeleteUser", testDeleteUser},
+	{"RestoreUser", testRestoreUser},
+	{"PurgeUser", testPurgeUser},
+	{"PurgeDeletedUsers", testPurgeDeletedUsers},
+	{"AuthenticateUser", testAuthenticateUser},
+	{"Roles", testRoles},
+	{"EnsureAdminUser", testEnsureAdminUser},
+	{"RefreshTokens", testRefreshTokens},
+	{"RunInTransaction", testRunInTransaction},
+	{"Outbox", testOutbox},
+}
+
+func TestConformance(t *testing.T) {
+	implementations := map[string]func(t *testing.T) storage{
+		StorageMemory:   newTestMemoryRepository,
+		StorageMongo:    newTestMongoRepository,
+		StoragePostgres: newTestPostgresRepository,
+	}
+	for name, newStorage := range implementations {
+		t.Run(name, func(t *testing.T) {
+			for _, conformanceCase := range conformanceCases {
+				t.Run(conformanceCase.name, func(t *testing.T) {
+					repo := newStorage(t)
+					setTestClock(repo, tickingClock())
+					conformanceCase.run(t, repo)
+				})
+			}
+		})
+	}
+}
+
+func testHasher() utility.PasswordHasher {
+	return &utility.BcryptHasher{Cost: 4}
+}
+
+func newTestMemoryRepository(t *testing.T) storage {
+	return NewMemoryRepository(testHasher())
+}
+
+func newTestMongoRepository(t *testing.T) storage {
+	host := os.Getenv("MONGODB_TEST_HOST")
+	if host == "" {
+		t.Skip("MONGODB_TEST_HOST is not set")
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(host))
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(ctx) })
+	if err = client.Database("users_collection").Drop(ctx); err != nil {
+		t.Fatal(notExError, err)
+	}
+	repo := New(client, testHasher())
+	// migrating again has no effect
+	for i := 0; i < 2; i++ {
+		if err = repo.Migrate(ctx); err != nil {
+			t.Fatal(notExError, err)
+		}
+	}
+	return repo
+}
+
+func newTestPostgresRepository(t *testing.T) storage {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err = db.ExecContext(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatal(notExError, err)
+	}
+	repo := NewPostgresRepository(db, testHasher())
+	// migrating again has no effect
+	for i := 0; i < 2; i++ {
+		if err = repo.Migrate(ctx); err != nil {
+			t.Fatal(notExError, err)
+		}
+	}
+	return repo
+}
+
+func testPing(t *testing.T, repo storage) {
+	assert.NoError(t, repo.Ping(ctx))
+}
+
+func createTestUser(t *testing.T, repo storage, nickname string, email string, country api.Country) *model.User {
+	user, err := repo.CreateUser(ctx, &api.CreateUserRequest{
+		Firstname: "firstname",
+		Lastname:  "lastname",
+		Nickname:  nickname,
+		Email:     email,
+		Password:  "password",
+		Country:   country,
+	})
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	return user
+}
+
+func assertDuplicate(t *testing.T, field string, err error) {
+	var duplicateError *model.DuplicateError
+	if assert.True(t, errors.As(err, &duplicateError), "expected a duplicate error, got %v", err) {
+		assert.Equal(t, field, duplicateError.Field)
+	}
+}
+
+func testCreateAndGetUser(t *testing.T, repo storage) {
+	created := createTestUser(t, repo, "Nickname", "Test@Email.com", api.Country_IT)
+	assert.NotEmpty(t, created.ID)
+	assert.NotEqual(t, "password", created.Password)
+	assert.Equal(t, []string{model.UserRole}, created.Roles)
+	user, err := repo.GetUser(ctx, &api.GetUserRequest{Id: created.ID})
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	assert.Equal(t, created, user)
+	user, err = repo.LookupUser(ctx, &api.LookupUserRequest{Key: &api.LookupUserRequest_Email{Email: "test@email.COM "}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, created.ID, user.ID)
+	}
+	user, err = repo.LookupUser(ctx, &api.LookupUserRequest{Key: &api.LookupUserRequest_Nickname{Nickname: "nickname"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, created.ID, user.ID)
+	}
+	_, err = repo.GetUser(ctx, &api.GetUserRequest{Id: uuid.New().String()})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, err = repo.LookupUser(ctx, &api.LookupUserRequest{Key: &api.LookupUserRequest_Email{Email: "other@email.com"}})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+}
+
+func testUniqueEmailAndNickname(t *testing.T, repo storage) {
+	first := createTestUser(t, repo, "first", "first@email.com", api.Country_IT)
+	second := createTestUser(t, repo, "second", "second@email.com", api.Country_IT)
+	_, err := repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "third", Email: "FIRST@email.com", Password: "password"})
+	assertDuplicate(t, model.FieldEmail, err)
+	_, err = repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "First", Email: "third@email.com", Password: "password"})
+	assertDuplicate(t, model.FieldNickname, err)
+	taken, err := repo.IsTaken(ctx, model.FieldEmail, " First@Email.com")
+	if assert.NoError(t, err) {
+		assert.True(t, taken)
+	}
+	taken, err = repo.IsTaken(ctx, model.FieldNickname, "third")
+	if assert.NoError(t, err) {
+		assert.False(t, taken)
+	}
+	nickname := "FIRST"
+	_, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: second.ID, Nickname: &nickname}, 0)
+	assertDuplicate(t, model.FieldNickname, err)
+	// the user keeps its own values
+	email := "First@Email.com"
+	_, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: first.ID, Email: &email, Nickname: &nickname}, 0)
+	assert.NoError(t, err)
+}
+
+func testConcurrentCreates(t *testing.T, repo storage) {
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: uuid.New().String(), Email: "test@email.com", Password: "password"})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	created := 0
+	for err := range results {
+		if err == nil {
+			created++
+		} else {
+			assertDuplicate(t, model.FieldEmail, err)
+		}
+	}
+	assert.Equal(t, 1, created)
+}
+
+func testGetUsersPaginated(t *testing.T, repo storage) {
+	first := createTestUser(t, repo, "first", "first@email.com", api.Country_EN)
+	createTestUser(t, repo, "second", "second@email.com", api.Country_IT)
+	third := createTestUser(t, repo, "third", "third@email.com", api.Country_EN)
+	page, err := repo.GetUsersPaginated(ctx, model.UserQuery{Filter: countryFilter("EN"), Limit: 10, CountTotal: true})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 2) {
+		assert.Equal(t, first.ID, page.Users[0].ID)
+		assert.Equal(t, third.ID, page.Users[1].ID)
+		assert.Equal(t, int64(2), page.TotalCount)
+	}
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{Filter: countryFilter("EN"), Limit: 1, CountTotal: true})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 1) {
+		assert.Equal(t, first.ID, page.Users[0].ID)
+		assert.Equal(t, int64(2), page.TotalCount, "the total count ignores the limit")
+	}
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{Filter: countryFilter("EN"), Offset: 1, Limit: 1})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 1) {
+		assert.Equal(t, third.ID, page.Users[0].ID)
+		assert.Equal(t, int64(0), page.TotalCount, "the users are not counted")
+	}
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{})
+	if assert.NoError(t, err) {
+		assert.Len(t, page.Users, 3)
+	}
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{Filter: countryFilter("DE"), Limit: 10, CountTotal: true})
+	if assert.NoError(t, err) {
+		assert.Empty(t, page.Users)
+		assert.Equal(t, int64(0), page.TotalCount)
+	}
+}
+
+func testGetUsersAfter(t *testing.T, repo storage) {
+	first := createTestUser(t, repo, "first", "first@email.com", api.Country_EN)
+	second := createTestUser(t, repo, "second", "second@email.com", api.Country_IT)
+	third := createTestUser(t, repo, "third", "third@email.com", api.Country_EN)
+	page, err := repo.GetUsersPaginated(ctx, model.UserQuery{After: first, Limit: 10, CountTotal: true})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 2) {
+		assert.Equal(t, second.ID, page.Users[0].ID)
+		assert.Equal(t, third.ID, page.Users[1].ID)
+		assert.Equal(t, int64(3), page.TotalCount, "the total count ignores the position")
+	}
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{Filter: countryFilter("EN"), After: first, Limit: 10})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 1) {
+		assert.Equal(t, third.ID, page.Users[0].ID)
+	}
+	// users created at the same time are sorted by id
+	setTestClock(repo, func() time.Time { return third.CreatedAt })
+	tied := []*model.User{
+		third,
+		createTestUser(t, repo, "fourth", "fourth@email.com", api.Country_EN),
+		createTestUser(t, repo, "fifth", "fifth@email.com", api.Country_EN),
+	}
+	sort.Slice(tied, func(i, j int) bool { return tied[i].ID < tied[j].ID })
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{After: tied[0], Limit: 10})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 2) {
+		assert.Equal(t, tied[1].ID, page.Users[0].ID)
+		assert.Equal(t, tied[2].ID, page.Users[1].ID)
+	}
+	page, err = repo.GetUsersPaginated(ctx, model.UserQuery{After: tied[2], Limit: 10})
+	if assert.NoError(t, err) {
+		assert.Empty(t, page.Users)
+	}
+}
+
+// countryFilter selects the users of the country
+func countryFilter(country string) *model.UserFilter {
+	return &model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldCountry, Value: country}
+}
+
+// userIDs returns the ids of the users in order
+func userIDs(users []model.User) []string {
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func testGetUsersFilter(t *testing.T, repo storage) {
+	first := createTestUser(t, repo, "First", "first@example.com", api.Country_EN)
+	second := createTestUser(t, repo, "second", "second%@other.com", api.Country_IT)
+	third := createTestUser(t, repo, "third_user", "Third@Example.com", api.Country_EN)
+	if _, _, err := repo.AssignRole(ctx, second.ID, model.AdminRole); err != nil {
+		t.Fatal(notExError, err)
+	}
+	filters := []struct {
+		name     string
+		filter   model.UserFilter
+		expected []string
+	}{
+		{"equal normalized", model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldNickname, Value: "first"}, []string{first.ID}},
+		{"not equal", model.UserFilter{Operator: model.FilterNotEqual, Field: model.UserFieldCountry, Value: "EN"}, []string{second.ID}},
+		{"prefix", model.UserFilter{Operator: model.FilterPrefix, Field: model.UserFieldEmail, Value: "second%"}, []string{second.ID}},
+		{"suffix", model.UserFilter{Operator: model.FilterSuffix, Field: model.UserFieldEmail, Value: "@example.com"}, []string{first.ID, third.ID}},
+		{"wildcards are literal", model.UserFilter{Operator: model.FilterSuffix, Field: model.UserFieldNickname, Value: "_user"}, []string{third.ID}},
+		{"has role", model.UserFilter{Operator: model.FilterHas, Field: model.UserFieldRoles, Value: model.AdminRole}, []string{second.ID}},
+		{"date range", model.UserFilter{Operator: model.FilterAnd, Filters: []model.UserFilter{
+			{Operator: model.FilterGreater, Field: model.UserFieldCreatedAt, Value: first.CreatedAt},
+			{Operator: model.FilterLessOrEqual, Field: model.UserFieldCreatedAt, Value: third.CreatedAt},
+		}}, []string{second.ID, third.ID}},
+		{"or", model.UserFilter{Operator: model.FilterOr, Filters: []model.UserFilter{
+			{Operator: model.FilterEqual, Field: model.UserFieldID, Value: first.ID},
+			{Operator: model.FilterHas, Field: model.UserFieldRoles, Value: model.AdminRole},
+		}}, []string{first.ID, second.ID}},
+		{"not", model.UserFilter{Operator: model.FilterNot, Filters: []model.UserFilter{
+			{Operator: model.FilterEqual, Field: model.UserFieldFirstname, Value: "firstname"},
+		}}, []string{}},
+	}
+	for _, filter := range filters {
+		page, err := repo.GetUsersPaginated(ctx, model.UserQuery{Filter: &filter.filter, CountTotal: true})
+		if assert.NoError(t, err, filter.name) {
+			assert.Equal(t, filter.expected, userIDs(page.Users), filter.name)
+			assert.Equal(t, int64(len(filter.expected)), page.TotalCount, filter.name)
+		}
+	}
+	// the status selects the deleted users, they are hidden when the query does not include them
+	if _, err := repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: third.ID}); err != nil {
+		t.Fatal(notExError, err)
+	}
+	deleted := model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldStatus, Value: model.UserStatusDeleted}
+	statuses := []struct {
+		name     string
+		query    model.UserQuery
+		expected []string
+	}{
+		{"deleted", model.UserQuery{Filter: &deleted, IncludeDeleted: true}, []string{third.ID}},
+		{"active", model.UserQuery{Filter: &model.UserFilter{Operator: model.FilterNotEqual, Field: model.UserFieldStatus, Value: model.UserStatusDeleted}, IncludeDeleted: true}, []string{first.ID, second.ID}},
+		{"any status", model.UserQuery{Filter: &model.UserFilter{Operator: model.FilterSuffix, Field: model.UserFieldEmail, Value: "@example.com"}, IncludeDeleted: true}, []string{first.ID, third.ID}},
+		{"deleted hidden", model.UserQuery{Filter: &deleted}, []string{}},
+	}
+	for _, status := range statuses {
+		status.query.CountTotal = true
+		page, err := repo.GetUsersPaginated(ctx, status.query)
+		if assert.NoError(t, err, status.name) {
+			assert.Equal(t, status.expected, userIDs(page.Users), status.name)
+			assert.Equal(t, int64(len(status.expected)), page.TotalCount, status.name)
+		}
+	}
+}
+
+func testGetUsersOrder(t *testing.T, repo storage) {
+	first := createTestUser(t, repo, "b", "c@email.com", api.Country_IT)
+	second := createTestUser(t, repo, "C", "a@email.com", api.Country_EN)
+	third := createTestUser(t, repo, "a", "B@email.com", api.Country_IT)
+	orders := []struct {
+		name     string
+		order    []model.UserOrder
+		expected []string
+	}{
+		{"default", nil, []string{first.ID, second.ID, third.ID}},
+		{"normalized email", []model.UserOrder{{Field: model.UserFieldEmail}}, []string{second.ID, third.ID, first.ID}},
+		{"nickname descending", []model.UserOrder{{Field: model.UserFieldNickname, Descending: true}}, []string{second.ID, first.ID, third.ID}},
+		{"country then creation", []model.UserOrder{
+			{Field: model.UserFieldCountry, Descending: true},
+			{Field: model.UserFieldCreatedAt, Descending: true},
+			{Field: model.UserFieldID, Descending: true},
+		}, []string{third.ID, first.ID, second.ID}},
+	}
+	for _, order := range orders {
+		page, err := repo.GetUsersPaginated(ctx, model.UserQuery{OrderBy: order.order})
+		if assert.NoError(t, err, order.name) {
+			assert.Equal(t, order.expected, userIDs(page.Users), order.name)
+		}
+		// the pages following each user are the rest of the list
+		for i, id := range order.expected {
+			after, err := repo.GetUser(ctx, &api.GetUserRequest{Id: id})
+			if err != nil {
+				t.Fatal(notExError, err)
+			}
+			page, err = repo.GetUsersPaginated(ctx, model.UserQuery{OrderBy: order.order, After: after})
+			if assert.NoError(t, err, order.name) {
+				assert.Equal(t, order.expected[i+1:], userIDs(page.Users), order.name)
+			}
+		}
+	}
+}
+
+func testSearchUsers(t *testing.T, repo storage) {
+	mario := createTestUser(t, repo, "SuperMario", "mario.rossi@email.com", api.Country_IT)
+	marianne := createTestUser(t, repo, "marianne", "m.dupont@email.com", api.Country_EN)
+	created, err := repo.CreateUser(ctx, &api.CreateUserRequest{
+		Firstname: "Hélène",
+		Lastname:  "Marín",
+		Nickname:  "helene",
+		Email:     "helene@email.com",
+		Password:  "password",
+	})
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	searches := []struct {
+		name     string
+		terms    []string
+		expected []string
+		scores   []int64
+	}{
+		// whole words rank before prefixes, then the users created first
+		{"prefix", []string{"mari"}, []string{mario.ID, marianne.ID, created.ID}, []int64{1, 1, 1}},
+		{"whole word first", []string{"marianne"}, []string{marianne.ID}, []int64{2}},
+		{"all terms", []string{"mar", "rossi"}, []string{mario.ID}, []int64{3}},
+		{"accents", []string{"helene", "marin"}, []string{created.ID}, []int64{4}},
+		{"email domain", []string{"email"}, []string{mario.ID, marianne.ID, created.ID}, []int64{2, 2, 2}},
+		{"no match", []string{"luigi"}, []string{}, []int64{}},
+	}
+	for _, search := range searches {
+		results, err := repo.SearchUsers(ctx, model.UserSearch{Terms: search.terms})
+		if assert.NoError(t, err, search.name) {
+			ids := make([]string, 0, len(results))
+			scores := make([]int64, 0, len(results))
+			for _, result := range results {
+				ids = append(ids, result.User.ID)
+				scores = append(scores, result.Score)
+			}
+			assert.Equal(t, search.expected, ids, search.name)
+			assert.Equal(t, search.scores, scores, search.name)
+		}
+	}
+	results, err := repo.SearchUsers(ctx, model.UserSearch{Terms: []string{"mari"}, Limit: 1})
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, *mario, results[0].User)
+		results, err = repo.SearchUsers(ctx, model.UserSearch{Terms: []string{"mari"}, After: &results[0], Limit: 10})
+		if assert.NoError(t, err) && assert.Len(t, results, 2) {
+			assert.Equal(t, marianne.ID, results[0].User.ID)
+			assert.Equal(t, created.ID, results[1].User.ID)
+		}
+	}
+	// the search follows the updates
+	nickname := "luigi"
+	if _, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: mario.ID, Nickname: &nickname}, 0); err != nil {
+		t.Fatal(notExError, err)
+	}
+	results, err = repo.SearchUsers(ctx, model.UserSearch{Terms: []string{"luigi"}})
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, mario.ID, results[0].User.ID)
+	}
+}
+
+func testUpdateUser(t *testing.T, repo storage) {
+	created := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	firstname := "updated"
+	country := api.Country_IT
+	before, after, err := repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: created.ID, Firstname: &firstname, Country: &country}, 0)
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	assert.Equal(t, created, before)
+	assert.Equal(t, firstname, after.Firstname)
+	assert.Equal(t, country.String(), after.Country)
+	assert.Equal(t, created.Lastname, after.Lastname)
+	user, err := repo.GetUser(ctx, &api.GetUserRequest{Id: created.ID})
+	if assert.NoError(t, err) {
+		assert.Equal(t, after, user)
+	}
+	_, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: uuid.New().String(), Firstname: &firstname}, 0)
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+}
+
+func testUserVersions(t *testing.T, repo storage) {
+	created := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	assert.Equal(t, int64(1), created.Version)
+	firstname := "updated"
+	_, after, err := repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: created.ID, Firstname: &firstname}, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), after.Version)
+	}
+	// the update is rejected if the user changed since it was read
+	_, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: created.ID, Firstname: &firstname}, 1)
+	assert.ErrorIs(t, err, model.ErrVersionConflict)
+	user, err := repo.GetUser(ctx, &api.GetUserRequest{Id: created.ID})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), user.Version)
+	}
+	// the role changes are versioned too
+	if err = repo.SeedRoles(ctx, model.DefaultRoles); err != nil {
+		t.Fatal(notExError, err)
+	}
+	_, after, err = repo.AssignRole(ctx, created.ID, model.AdminRole)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(3), after.Version)
+	}
+	_, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: created.ID, Firstname: &firstname}, 2)
+	assert.ErrorIs(t, err, model.ErrVersionConflict)
+}
+
+// tickingClock returns a clock advancing by a millisecond at each call, so that the users are created at distinct times
+// and listed in creation order
+func tickingClock() func() time.Time {
+	start := time.Now()
+	var ticks int64
+	return func() time.Time {
+		return start.Add(time.Duration(atomic.AddInt64(&ticks, 1)) * time.Millisecond)
+	}
+}
+
+// setTestClock replaces the clock of the repository
+func setTestClock(repo storage, now func() time.Time) {
+	switch repo := repo.(type) {
+	case *MemoryRepository:
+		repo.now = now
+	case *Repository:
+		repo.now = now
+	case *PostgresRepository:
+		repo.now = now
+	}
+}
+
+func testUserDates(t *testing.T, repo storage) {
+	// dates are stored in UTC with millisecond precision whatever the zone of the clock
+	createdAt := time.Date(2023, 1, 2, 15, 4, 5, 123456789, time.FixedZone("CET", 3600))
+	setTestClock(repo, func() time.Time { return createdAt })
+	created := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	expectedCreatedAt := time.Date(2023, 1, 2, 14, 4, 5, 123000000, time.UTC)
+	assert.Equal(t, expectedCreatedAt, created.CreatedAt)
+	assert.Equal(t, expectedCreatedAt, created.UpdatedAt)
+	updatedAt := createdAt.Add(time.Hour)
+	setTestClock(repo, func() time.Time { return updatedAt })
+	firstname := "updated"
+	_, after, err := repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: created.ID, Firstname: &firstname}, 0)
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	assert.Equal(t, expectedCreatedAt, after.CreatedAt)
+	assert.Equal(t, expectedCreatedAt.Add(time.Hour), after.UpdatedAt)
+	user, err := repo.GetUser(ctx, &api.GetUserRequest{Id: created.ID})
+	if assert.NoError(t, err) {
+		assert.Equal(t, after, user)
+	}
+}
+
+func testDeleteUser(t *testing.T, repo storage) {
+	created := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	deleted, err := repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: created.ID})
+	if assert.NoError(t, err) && assert.NotNil(t, deleted.DeletedAt) {
+		assert.Equal(t, created.Version+1, deleted.Version)
+		expected := *created
+		expected.DeletedAt, expected.Version = deleted.DeletedAt, deleted.Version
+		assert.Equal(t, &expected, deleted)
+	}
+	// the deleted user is hidden
+	_, err = repo.GetUser(ctx, &api.GetUserRequest{Id: created.ID})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, err = repo.LookupUser(ctx, &api.LookupUserRequest{Key: &api.LookupUserRequest_Email{Email: created.Email}})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, err = repo.AuthenticateUser(ctx, &api.AuthenticateRequest{Login: &api.AuthenticateRequest_Nickname{Nickname: created.Nickname}, Password: "password"})
+	assert.ErrorIs(t, err, model.ErrInvalidCredentials)
+	page, err := repo.GetUsersPaginated(ctx, model.UserQuery{CountTotal: true})
+	if assert.NoError(t, err) {
+		assert.Empty(t, page.Users)
+		assert.Zero(t, page.TotalCount)
+	}
+	results, err := repo.SearchUsers(ctx, model.UserSearch{Terms: []string{"nickname"}})
+	if assert.NoError(t, err) {
+		assert.Empty(t, results)
+	}
+	firstname := "updated"
+	_, _, err = repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: created.ID, Firstname: &firstname}, 0)
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, _, err = repo.AssignRole(ctx, created.ID, model.AdminRole)
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, err = repo.GetUserRoles(ctx, created.ID)
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, err = repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: created.ID})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	// the email and the nickname stay reserved until the user is purged, so that it can be restored
+	taken, err := repo.IsTaken(ctx, model.FieldEmail, created.Email)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	_, err = repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "nickname", Email: "other@email.com", Password: "password", Country: api.Country_EN})
+	assertDuplicate(t, model.FieldNickname, err)
+}
+
+func testRestoreUser(t *testing.T, repo storage) {
+	created := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	_, err := repo.RestoreUser(ctx, created.ID, time.Time{})
+	assert.ErrorIs(t, err, model.ErrUserNotDeleted)
+	_, err = repo.RestoreUser(ctx, uuid.New().String(), time.Time{})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	deleted, err := repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: created.ID})
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	restored, err := repo.RestoreUser(ctx, created.ID, *deleted.DeletedAt)
+	if assert.NoError(t, err) {
+		assert.Nil(t, restored.DeletedAt)
+		assert.Equal(t, deleted.Version+1, restored.Version)
+	}
+	user, err := repo.GetUser(ctx, &api.GetUserRequest{Id: created.ID})
+	if assert.NoError(t, err) {
+		assert.Equal(t, restored, user)
+	}
+	// a user deleted before deletedAfter is past its grace period
+	deleted, err = repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: created.ID})
+	if err != nil {
+		t.Fatal(notExError, err)
+	}
+	_, err = repo.RestoreUser(ctx, created.ID, deleted.DeletedAt.Add(time.Millisecond))
+	assert.ErrorIs(t, err, model.ErrRestorePeriodExpired)
+}
+
+func testPurgeUser(t *testing.T, repo storage) {
+	active := createTestUser(t, repo, "active", "active@email.com", api.Country_EN)
+	deleted := createTestUser(t, repo, "deleted", "deleted@email.com", api.Country_EN)
+	if _, err := repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: deleted.ID}); err != nil {
+		t.Fatal(notExError, err)
+	}
+	// both the active and the deleted users can be purged
+	for _, user := range []*model.User{active, deleted} {
+		purged, err := repo.PurgeUser(ctx, user.ID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, user.ID, purged.ID)
+		}
+		_, err = repo.RestoreUser(ctx, user.ID, time.Time{})
+		assert.ErrorIs(t, err, model.ErrUserNotFound)
+		_, err = repo.PurgeUser(ctx, user.ID)
+		assert.ErrorIs(t, err, model.ErrUserNotFound)
+	}
+	// the email and the nickname can be used again
+	createTestUser(t, repo, "deleted", "deleted@email.com", api.Country_EN)
+}
+
+func testPurgeDeletedUsers(t *testing.T, repo storage) {
+	var deleted []*model.User
+	for _, nickname := range []string{"first", "second", "third"} {
+		user := createTestUser(t, repo, nickname, nickname+"@email.com", api.Country_EN)
+		deletedUser, err := repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: user.ID})
+		if err != nil {
+			t.Fatal(notExError, err)
+		}
+		deleted = append(deleted, deletedUser)
+	}
+	active := createTestUser(t, repo, "active", "active@email.com", api.Country_EN)
+	purge := func(deletedBefore time.Time, limit int) []string {
+		var ids []string
+		err := repo.RunInTransaction(ctx, func(ctx context.Context) error {
+			users, err := repo.PurgeDeletedUsers(ctx, deletedBefore, limit)
+			ids = nil
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			return err
+		})
+		assert.NoError(t, err)
+		return ids
+	}
+	// only the users deleted before the date are purged, the oldest deletions first
+	assert.Equal(t, []string{deleted[0].ID}, purge(*deleted[1].DeletedAt, 10))
+	assert.Equal(t, []string{deleted[1].ID}, purge(deleted[2].DeletedAt.Add(time.Millisecond), 1))
+	assert.Equal(t, []string{deleted[2].ID}, purge(time.Now().Add(time.Hour), 10))
+	assert.Empty(t, purge(time.Now().Add(time.Hour), 10))
+	_, err := repo.RestoreUser(ctx, deleted[0].ID, time.Time{})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	user, err := repo.GetUser(ctx, &api.GetUserRequest{Id: active.ID})
+	if assert.NoError(t, err) {
+		assert.Equal(t, active, user)
+	}
+}
+
+func testAuthenticateUser(t *testing.T, repo storage) {
+	created := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	user, err := repo.AuthenticateUser(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: "Test@Email.com"},
+		Password: "password",
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, created.ID, user.ID)
+	}
+	user, err = repo.AuthenticateUser(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Nickname{Nickname: "nickname"},
+		Password: "password",
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, created.ID, user.ID)
+	}
+	_, err = repo.AuthenticateUser(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Nickname{Nickname: "nickname"},
+		Password: "wrong",
+	})
+	assert.ErrorIs(t, err, model.ErrInvalidCredentials)
+	_, err = repo.AuthenticateUser(ctx, &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Nickname{Nickname: "unknown"},
+		Password: "password",
+	})
+	assert.ErrorIs(t, err, model.ErrInvalidCredentials)
+}
+
+func testRoles(t *testing.T, repo storage) {
+	if err := repo.SeedRoles(ctx, model.DefaultRoles); err != nil {
+		t.Fatal(notExError, err)
+	}
+	support := &model.Role{Name: "support", Description: "Support", Permissions: []string{model.PermissionUsersRead}}
+	assert.NoError(t, repo.PutRole(ctx, support))
+	// seeding again does not replace the existing roles
+	assert.NoError(t, repo.SeedRoles(ctx, []model.Role{{Name: "support", Permissions: []string{}}}))
+	roles, err := repo.ListRoles(ctx)
+	if assert.NoError(t, err) && assert.Len(t, roles, 3) {
+		assert.Equal(t, []string{model.AdminRole, "support", model.UserRole}, []string{roles[0].Name, roles[1].Name, roles[2].Name})
+	}
+	role, err := repo.GetRole(ctx, "support")
+	if assert.NoError(t, err) {
+		assert.Equal(t, support, role)
+	}
+	_, err = repo.GetRole(ctx, "unknown")
+	assert.ErrorIs(t, err, model.ErrRoleNotFound)
+
+	user := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	before, after, err := repo.AssignRole(ctx, user.ID, "support")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{model.UserRole}, before.Roles)
+		assert.Equal(t, []string{model.UserRole, "support"}, after.Roles)
+	}
+	// assigning the role again changes nothing, the version and so the etag are kept
+	before, after, err = repo.AssignRole(ctx, user.ID, "support")
+	if assert.NoError(t, err) {
+		assert.Equal(t, before, after)
+		assert.Equal(t, user.Version+1, after.Version)
+	}
+	before, after, err = repo.RevokeRole(ctx, user.ID, model.AdminRole)
+	if assert.NoError(t, err) {
+		assert.Equal(t, before, after)
+		assert.Equal(t, user.Version+1, after.Version)
+	}
+	_, _, err = repo.AssignRole(ctx, uuid.New().String(), "support")
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, _, err = repo.RevokeRole(ctx, uuid.New().String(), "support")
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	userRoles, err := repo.GetUserRoles(ctx, user.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{model.UserRole, "support"}, userRoles)
+	}
+	_, after, err = repo.RevokeRole(ctx, user.ID, model.UserRole)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"support"}, after.Roles)
+	}
+	userRoles, err = repo.GetUserRoles(ctx, user.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"support"}, userRoles)
+	}
+	_, _, err = repo.AssignRole(ctx, uuid.New().String(), "support")
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	_, err = repo.GetUserRoles(ctx, uuid.New().String())
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+
+}
+
+func testEnsureAdminUser(t *testing.T, repo storage) {
+	user := createTestUser(t, repo, "nickname", "test@email.com", api.Country_EN)
+	// existing accounts are never promoted
+	_, err := repo.EnsureAdminUser(ctx, "Test@Email.com", "root", "password")
+	assertDuplicate(t, model.FieldEmail, err)
+	_, err = repo.EnsureAdminUser(ctx, "admin@email.com", "Nickname", "password")
+	assertDuplicate(t, model.FieldNickname, err)
+	userRoles, err := repo.GetUserRoles(ctx, user.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{model.UserRole}, userRoles)
+	}
+	admin, err := repo.EnsureAdminUser(ctx, "admin@email.com", "root", "password")
+	if assert.NoError(t, err) && assert.NotNil(t, admin) {
+		assert.Equal(t, "root", admin.Nickname)
+		assert.Equal(t, []string{model.UserRole, model.AdminRole}, admin.Roles)
+	}
+	// nothing is created once an admin exists
+	admin, err = repo.EnsureAdminUser(ctx, "other@email.com", "other", "password")
+	assert.NoError(t, err)
+	assert.Nil(t, admin)
+	_, err = repo.LookupUser(ctx, &api.LookupUserRequest{Key: &api.LookupUserRequest_Email{Email: "other@email.com"}})
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+}
+
+func testRefreshTokens(t *testing.T, repo storage) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	first := &model.RefreshToken{Hash: "first", UserID: "user", FamilyID: "family", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	other := &model.RefreshToken{Hash: "other", UserID: "user", FamilyID: "other", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, repo.CreateRefreshToken(ctx, first))
+	assert.NoError(t, repo.CreateRefreshToken(ctx, other))
+	token, err := repo.UseRefreshToken(ctx, "first", "second")
+	if assert.NoError(t, err) {
+		assert.Equal(t, first, token)
+	}
+	token, err = repo.UseRefreshToken(ctx, "first", "third")
+	assert.ErrorIs(t, err, model.ErrRefreshTokenReused)
+	if assert.NotNil(t, token) {
+		assert.Equal(t, "second", token.ReplacedBy)
+	}
+	_, err = repo.UseRefreshToken(ctx, "unknown", "third")
+	assert.ErrorIs(t, err, model.ErrRefreshTokenNotFound)
+	assert.NoError(t, repo.RevokeRefreshTokenFamily(ctx, "other"))
+	token, err = repo.GetRefreshToken(ctx, "other")
+	if assert.NoError(t, err) {
+		assert.True(t, token.Revoked)
+	}
+	_, err = repo.UseRefreshToken(ctx, "other", "third")
+	assert.ErrorIs(t, err, model.ErrRefreshTokenReused)
+	_, err = repo.GetRefreshToken(ctx, "unknown")
+	assert.ErrorIs(t, err, model.ErrRefreshTokenNotFound)
+}
+
+func testRunInTransaction(t *testing.T, repo storage) {
+	failure := errors.New("failure")
+	first, err := repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "first", Email: "first@email.com", Password: "password"})
+	assert.NoError(t, err)
+	second, err := repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "second", Email: "second@email.com", Password: "password"})
+	assert.NoError(t, err)
+	var rolledBack *model.User
+	err = repo.RunInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := repo.DeleteUser(ctx, &api.DeleteUserRequest{Id: first.ID}); err != nil {
+			return err
+		}
+		if _, _, err := repo.UpdateUser(ctx, &api.UpdateUserRequest{Id: second.ID, Firstname: proto.String("updated")}, 0); err != nil {
+			return err
+		}
+		if _, _, err := repo.AssignRole(ctx, second.ID, model.AdminRole); err != nil {
+			return err
+		}
+		event := &api.UserDeleted{Metadata: &api.EventMetadata{EventId: uuid.New().String()}, UserId: first.ID}
+		if err := repo.EnqueueEvent(ctx, event); err != nil {
+			return err
+		}
+		var err error
+		rolledBack, err = repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "rolled_back", Email: "rolled_back@email.com", Password: "password"})
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+	if assert.NotNil(t, rolledBack) {
+		_, err = repo.GetUser(ctx, &api.GetUserRequest{Id: rolledBack.ID})
+		assert.ErrorIs(t, err, model.ErrUserNotFound)
+	}
+	// the changes are reverted and the users keep their order
+	page, err := repo.GetUsersPaginated(ctx, model.UserQuery{})
+	if assert.NoError(t, err) && assert.Len(t, page.Users, 2) {
+		assert.Equal(t, first.ID, page.Users[0].ID)
+		assert.Equal(t, second.ID, page.Users[1].ID)
+		assert.Equal(t, second.Firstname, page.Users[1].Firstname)
+		assert.Equal(t, []string{model.UserRole}, page.Users[1].Roles)
+	}
+	stats, err := repo.GetOutboxStats(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), stats.Pending)
+	}
+	var committed *model.User
+	err = repo.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		committed, err = repo.CreateUser(ctx, &api.CreateUserRequest{Nickname: "committed", Email: "committed@email.com", Password: "password"})
+		return err
+	})
+	if assert.NoError(t, err) {
+		_, err = repo.GetUser(ctx, &api.GetUserRequest{Id: committed.ID})
+		assert.NoError(t, err)
+	}
+}
+
+func testOutbox(t *testing.T, repo storage) {
+	events := []*api.UserCreated{
+		{Metadata: &api.EventMetadata{EventId: uuid.New().String()}, UserId: "first"},
+		{Metadata: &api.EventMetadata{EventId: uuid.New().String()}, UserId: "second"},
+		{Metadata: &api.EventMetadata{EventId: uuid.New().String()}, UserId: "first"},
+	}
+	for _, event := range events {
+		assert.NoError(t, repo.EnqueueEvent(ctx, event))
+		// entries are returned in creation order
+		time.Sleep(2 * time.Millisecond)
+	}
+	now := time.Now().UTC().Add(time.Second).Truncate(time.Millisecond)
+	entries, err := repo.ClaimPendingEvents(ctx, "relay-1", now, time.Minute, 10)
+	if assert.NoError(t, err) && assert.Len(t, entries, 3) {
+		assert.Equal(t, events[0].Metadata.EventId, entries[0].ID)
+		assert.Equal(t, "first", entries[0].UserID)
+		assert.Equal(t, "user.UserCreated", entries[0].MessageType)
+		assert.NotEmpty(t, entries[0].Payload)
+		assert.Equal(t, "relay-1", entries[0].LeaseOwner)
+		assert.Equal(t, events[1].Metadata.EventId, entries[1].ID)
+		assert.Equal(t, events[2].Metadata.EventId, entries[2].ID)
+	}
+	// the entries are leased to the first relay
+	entries, err = repo.ClaimPendingEvents(ctx, "relay-2", now, time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	// a failure delays the following entries of the user
+	assert.NoError(t, repo.MarkEventFailed(ctx, events[0].Metadata.EventId, "first", now.Add(time.Minute), "failure"))
+	assert.NoError(t, repo.MarkEventPublished(ctx, events[1].Metadata.EventId, now))
+	entries, err = repo.ClaimPendingEvents(ctx, "relay-2", now.Add(30*time.Second), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	stuck, err := repo.ListStuckEvents(ctx, 1, 10)
+	if assert.NoError(t, err) && assert.Len(t, stuck, 1) {
+		assert.Equal(t, 1, stuck[0].Attempts)
+		assert.Equal(t, "failure", stuck[0].LastError)
+		assert.True(t, now.Add(time.Minute).Equal(stuck[0].NextAttemptAt))
+	}
+	// an event enqueued meanwhile waits for the retry too
+	later := &api.UserDeleted{Metadata: &api.EventMetadata{EventId: uuid.New().String()}, UserId: "first"}
+	assert.NoError(t, repo.EnqueueEvent(ctx, later))
+	entries, err = repo.ClaimPendingEvents(ctx, "relay-2", now.Add(time.Minute), time.Minute, 10)
+	if assert.NoError(t, err) && assert.Len(t, entries, 3) {
+		assert.Equal(t, events[0].Metadata.EventId, entries[0].ID)
+		assert.Equal(t, events[2].Metadata.EventId, entries[1].ID)
+		assert.Equal(t, later.Metadata.EventId, entries[2].ID)
+	}
+	// a parked entry does not block the following ones
+	assert.NoError(t, repo.ParkEvent(ctx, events[0].Metadata.EventId, now.Add(time.Minute), "poison"))
+	assert.NoError(t, repo.MarkEventPublished(ctx, later.Metadata.EventId, now.Add(time.Minute)))
+	entries, err = repo.ClaimPendingEvents(ctx, "relay-1", now.Add(3*time.Minute), time.Minute, 10)
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, events[2].Metadata.EventId, entries[0].ID)
+	}
+	stuck, err = repo.ListStuckEvents(ctx, 1, 10)
+	if assert.NoError(t, err) && assert.Len(t, stuck, 1) {
+		assert.Equal(t, 2, stuck[0].Attempts)
+		assert.Equal(t, "poison", stuck[0].LastError)
+		assert.NotNil(t, stuck[0].ParkedAt)
+	}
+	stats, err := repo.GetOutboxStats(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), stats.Pending)
+		assert.Equal(t, int64(1), stats.Parked)
+		assert.True(t, entries[0].CreatedAt.Equal(stats.OldestCreatedAt))
+	}
+}
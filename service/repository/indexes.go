@@ -0,0 +1,154 @@
+package repository
+
+// This file implements the creation of the indexes, applied by the migrations, and the translation of the errors they raise
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+	"user/service/model"
+)
+
+// Names of the unique indexes of the users collection, used to find the conflicting field of duplicate key errors
+const (
+	uniqueIDIndex       = "unique_id"
+	uniqueEmailIndex    = "unique_email"
+	uniqueNicknameIndex = "unique_nickname"
+)
+
+// userSearchIndex is the name of the text index of the users searched by SearchUsers
+const userSearchIndex = "search"
+
+var uniqueIndexFields = map[string]string{
+	uniqueIDIndex:       "id",
+	uniqueEmailIndex:    model.FieldEmail,
+	uniqueNicknameIndex: model.FieldNickname,
+}
+
+// createIndexes creates the indexes of all the collections of the service, creating an existing index has no effect.
+// It fails if the stored users already violate a unique index
+func (repository *Repository) createIndexes(ctx context.Context) error {
+	log.Info("Creating indexes")
+	_, err := repository.GetConnection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName(uniqueIDIndex).SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "email_normalized", Value: 1}},
+			Options: options.Index().SetName(uniqueEmailIndex).SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "nickname_normalized", Value: 1}},
+			Options: options.Index().SetName(uniqueNicknameIndex).SetUnique(true),
+		},
+	})
+	if err != nil {
+		log.Error("Error while creating users indexes ", err)
+		return err
+	}
+	_, err = repository.GetTokensConnection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "family_id", Value: 1}},
+		},
+		{
+			// expired tokens are removed by MongoDB
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Error("Error while creating refresh tokens indexes ", err)
+		return err
+	}
+	_, err = repository.GetOutboxConnection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "published_at", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		{
+			// published entries are kept for a week to investigate deliveries, pending ones have no date and are never removed
+			Keys:    bson.D{{Key: "published_at", Value: 1}},
+			Options: options.Index().SetName("published_at_ttl").SetExpireAfterSeconds(int32((7 * 24 * time.Hour).Seconds())),
+		},
+	})
+	if err != nil {
+		log.Error("Error while creating outbox indexes ", err)
+		return err
+	}
+	_, err = repository.GetRolesConnection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Error("Error while creating roles index ", err)
+		return err
+	}
+	log.Info("Indexes created")
+	return nil
+}
+
+// normalizeUsers computes the normalized email and nickname of the users stored before they were introduced
+func (repository *Repository) normalizeUsers(ctx context.Context) error {
+	filter := bson.D{{Key: "email_normalized", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "email_normalized", Value: normalizeExpression("$email")},
+		{Key: "nickname_normalized", Value: normalizeExpression("$nickname")},
+	}}}}
+	result, err := repository.GetConnection().UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error("Error while normalizing users ", err)
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Info("Normalized email and nickname of ", result.ModifiedCount, " users")
+	}
+	return nil
+}
+
+// normalizeExpression is the aggregation equivalent of model.Normalize
+func normalizeExpression(field string) bson.D {
+	return bson.D{{Key: "$toLower", Value: bson.D{{Key: "$trim", Value: bson.D{{Key: "input", Value: field}}}}}}
+}
+
+// translateWriteError returns a model.DuplicateError with the conflicting field if the error is a duplicate key one
+func translateWriteError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			for index, field := range uniqueIndexFields {
+				if strings.Contains(writeError.Message, index) {
+					return &model.DuplicateError{Field: field}
+				}
+			}
+		}
+	}
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) {
+		for index, field := range uniqueIndexFields {
+			if strings.Contains(commandError.Message, index) {
+				return &model.DuplicateError{Field: field}
+			}
+		}
+	}
+	log.Warn("Cannot find the conflicting field of duplicate key error ", err)
+	return err
+}
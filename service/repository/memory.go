@@ -0,0 +1,868 @@
+package repository
+
+// This file implements the data layer in memory, used by the tests and to run the service locally without MongoDB.
+// It follows the semantics of the MongoDB implementation, the conformance tests run against both
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"sort"
+	"sync"
+	"time"
+	"user/service/api"
+	"user/service/model"
+	"user/service/utility"
+)
+
+// transactionKey marks the contexts of the operations run by RunInTransaction, its value is the *memoryTransaction
+type transactionKey struct{}
+
+// memoryTransaction is a transaction in progress, it takes the write lock at its first operation and holds it until it ends
+type memoryTransaction struct {
+	locked bool
+}
+
+// memoryState holds the stored data, user ids are kept in creation order so that the iterations are deterministic.
+// While a transaction holds the lock, every change records in undoLog how to revert it
+type memoryState struct {
+	users         map[string]model.User
+	userIDs       []string
+	refreshTokens map[string]model.RefreshToken
+	roles         map[string]model.Role
+	outbox        map[string]model.OutboxEntry
+	recording     bool
+	undoLog       []func()
+}
+
+// MemoryRepository stores the data in memory, it is safe for concurrent use
+type MemoryRepository struct {
+	PasswordHasher    utility.PasswordHasher
+	dummyPasswordHash string
+	mutex             sync.RWMutex
+	state             memoryState
+	// now is the clock of the stored dates, replaced by the tests
+	now func() time.Time
+}
+
+// NewMemoryRepository returns an empty in memory repository
+func NewMemoryRepository(hasher utility.PasswordHasher) *MemoryRepository {
+	dummyPasswordHash, err := hasher.Hash(uuid.New().String())
+	if err != nil {
+		log.Error("Error while computing the dummy password hash ", err)
+	}
+	return &MemoryRepository{
+		PasswordHasher:    hasher,
+		dummyPasswordHash: dummyPasswordHash,
+		now:               time.Now,
+		state: memoryState{
+			users:         map[string]model.User{},
+			refreshTokens: map[string]model.RefreshToken{},
+			roles:         map[string]model.Role{},
+			outbox:        map[string]model.OutboxEntry{},
+		},
+	}
+}
+
+// lock acquires the write lock, the returned function releases it.
+// Within a transaction the lock is acquired by the first operation and released when the transaction ends
+func (repository *MemoryRepository) lock(ctx context.Context) func() {
+	transaction, ok := ctx.Value(transactionKey{}).(*memoryTransaction)
+	if !ok {
+		repository.mutex.Lock()
+		return repository.mutex.Unlock
+	}
+	if !transaction.locked {
+		repository.mutex.Lock()
+		transaction.locked = true
+		repository.state.recording = true
+	}
+	return func() {}
+}
+
+// rlock acquires the read lock, the returned function releases it. Within a transaction it acquires the write lock like lock
+func (repository *MemoryRepository) rlock(ctx context.Context) func() {
+	if inTransaction(ctx) {
+		return repository.lock(ctx)
+	}
+	repository.mutex.RLock()
+	return repository.mutex.RUnlock
+}
+
+func inTransaction(ctx context.Context) bool {
+	return ctx.Value(transactionKey{}) != nil
+}
+
+// Ping always succeeds, the data is kept in memory
+func (repository *MemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the data is kept in memory
+func (repository *MemoryRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// RunInTransaction runs fn as a single operation, the changes made by fn are rolled back if it fails.
+// The lock is taken only by the first operation of fn, so that the password hashing done before does not block the other callers
+func (repository *MemoryRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inTransaction(ctx) {
+		return fn(ctx)
+	}
+	transaction := &memoryTransaction{}
+	committed := false
+	defer func() {
+		if !transaction.locked {
+			return
+		}
+		// fn failed or panicked
+		if !committed {
+			repository.state.rollback()
+		}
+		repository.state.recording = false
+		repository.state.undoLog = nil
+		repository.mutex.Unlock()
+	}()
+	if err := fn(context.WithValue(ctx, transactionKey{}, transaction)); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// CreateUser returns the created User
+func (repository *MemoryRepository) CreateUser(ctx context.Context, request *api.CreateUserRequest) (*model.User, error) {
+	log.Debug("Creating new user entity")
+	user, err := newUser(repository.PasswordHasher, request, repository.now())
+	if err != nil {
+		return nil, err
+	}
+	defer repository.lock(ctx)()
+	if err = repository.state.insertUser(*user); err != nil {
+		log.Error("Error while creating the user", err)
+		return nil, err
+	}
+	log.Debug("Generated user with id ", user.ID)
+	return user, nil
+}
+
+// GetUsersPaginated returns a page of the users selected by the query
+func (repository *MemoryRepository) GetUsersPaginated(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	log.Debug("Starting paginated retrieval of users")
+	defer repository.rlock(ctx)()
+	var selected []model.User
+	for _, id := range repository.state.userIDs {
+		user := repository.state.users[id]
+		if (user.DeletedAt != nil && !query.IncludeDeleted) || !matchUser(&user, query.Filter) {
+			continue
+		}
+		selected = append(selected, user)
+	}
+	order := query.Order()
+	sort.Slice(selected, func(i, j int) bool { return model.CompareUsers(&selected[i], &selected[j], order) < 0 })
+	page := &model.UserPage{}
+	if query.CountTotal {
+		page.TotalCount = int64(len(selected))
+	}
+	skip := query.Offset
+	for i := range selected {
+		if query.After != nil && model.CompareUsers(&selected[i], query.After, order) <= 0 {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if query.Limit > 0 && int64(len(page.Users)) == query.Limit {
+			break
+		}
+		page.Users = append(page.Users, cloneUser(selected[i]))
+	}
+	log.Debug("Retrieved paged users successfully")
+	return page, nil
+}
+
+// SearchUsers returns a page of the users matching the search, ranked by relevance
+func (repository *MemoryRepository) SearchUsers(ctx context.Context, search model.UserSearch) ([]model.UserSearchResult, error) {
+	log.Debug("Starting search of users")
+	defer repository.rlock(ctx)()
+	var matching []model.UserSearchResult
+	for _, id := range repository.state.userIDs {
+		user := repository.state.users[id]
+		score, ok := user.SearchScore(search.Terms)
+		if !ok || user.DeletedAt != nil {
+			continue
+		}
+		result := model.UserSearchResult{User: user, Score: score}
+		if search.After != nil && model.CompareSearchResults(&result, search.After) <= 0 {
+			continue
+		}
+		matching = append(matching, result)
+	}
+	sort.Slice(matching, func(i, j int) bool { return model.CompareSearchResults(&matching[i], &matching[j]) < 0 })
+	if search.Limit > 0 && int64(len(matching)) > search.Limit {
+		matching = matching[:search.Limit]
+	}
+	for i := range matching {
+		matching[i].User = cloneUser(matching[i].User)
+	}
+	log.Debug("Searched users successfully")
+	return matching, nil
+}
+
+// UpdateUser returns the User before and after the update
+func (repository *MemoryRepository) UpdateUser(ctx context.Context, request *api.UpdateUserRequest, version int64) (*model.User, *model.User, error) {
+	log.Debug("Starting update user for user ", request.Id)
+	hashedPassword, err := hashUpdatedPassword(repository.PasswordHasher, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer repository.lock(ctx)()
+	existingUser, ok := repository.state.activeUser(request.Id)
+	if !ok {
+		log.Error("Error while getting the use with id ", request.Id)
+		return nil, nil, model.ErrUserNotFound
+	}
+	if err = checkVersion(&existingUser, version); err != nil {
+		return nil, nil, err
+	}
+	previousUser := cloneUser(existingUser)
+	updatedUser := cloneUser(existingUser)
+	applyUserUpdate(&updatedUser, request, hashedPassword, repository.now())
+	if err := repository.state.checkUnique(updatedUser); err != nil {
+		return nil, nil, err
+	}
+	repository.state.putUser(updatedUser)
+	log.Debug("Updated users ", updatedUser.ID)
+	return &previousUser, &updatedUser, nil
+}
+
+// DeleteUser marks the user deleted and returns it, model.ErrUserNotFound if it does not exist or is already deleted
+func (repository *MemoryRepository) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) (*model.User, error) {
+	log.Debug("Starting deletion func for user ", request.Id)
+	defer repository.lock(ctx)()
+	deletedUser, ok := repository.state.activeUser(request.Id)
+	if !ok {
+		log.Error("User ", request.Id, " is not present in the database")
+		return nil, model.ErrUserNotFound
+	}
+	deletedAt := storedTime(repository.now())
+	deletedUser.DeletedAt = &deletedAt
+	deletedUser.Version++
+	repository.state.putUser(deletedUser)
+	log.Debug("Correctly deleted user ", request.Id)
+	return &deletedUser, nil
+}
+
+// RestoreUser undoes the deletion of the user and returns it, model.ErrUserNotDeleted if it is not deleted and
+// model.ErrRestorePeriodExpired if it was deleted before deletedAfter
+func (repository *MemoryRepository) RestoreUser(ctx context.Context, userID string, deletedAfter time.Time) (*model.User, error) {
+	log.Debug("Starting restore of user ", userID)
+	defer repository.lock(ctx)()
+	user, ok := repository.state.users[userID]
+	if !ok {
+		return nil, model.ErrUserNotFound
+	}
+	if err := checkRestorable(&user, deletedAfter); err != nil {
+		return nil, err
+	}
+	restoredUser := cloneUser(user)
+	restoredUser.DeletedAt = nil
+	restoredUser.Version++
+	repository.state.putUser(restoredUser)
+	log.Debug("Restored user ", userID)
+	return &restoredUser, nil
+}
+
+// PurgeUser removes the user permanently, whether it is deleted or not, and returns it. model.ErrUserNotFound if it does not exist
+func (repository *MemoryRepository) PurgeUser(ctx context.Context, userID string) (*model.User, error) {
+	log.Debug("Starting purge of user ", userID)
+	defer repository.lock(ctx)()
+	purgedUser, ok := repository.state.users[userID]
+	if !ok {
+		log.Error("User ", userID, " is not present in the database")
+		return nil, model.ErrUserNotFound
+	}
+	repository.state.removeUser(userID)
+	log.Debug("Purged user ", userID)
+	return &purgedUser, nil
+}
+
+// PurgeDeletedUsers removes permanently at most limit users deleted before deletedBefore, the oldest deletions first, and returns them
+func (repository *MemoryRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	defer repository.lock(ctx)()
+	var users []model.User
+	for _, id := range repository.state.userIDs {
+		user := repository.state.users[id]
+		if user.DeletedAt != nil && user.DeletedAt.Before(deletedBefore) {
+			users = append(users, user)
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].DeletedAt.Before(*users[j].DeletedAt) })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	for _, user := range users {
+		repository.state.removeUser(user.ID)
+	}
+	log.Debug("Purged ", len(users), " deleted users")
+	return users, nil
+}
+
+// GetUser returns the user with the given id, model.ErrUserNotFound if it does not exist
+func (repository *MemoryRepository) GetUser(ctx context.Context, request *api.GetUserRequest) (*model.User, error) {
+	log.Debug("Starting get user ", request.Id)
+	defer repository.rlock(ctx)()
+	user, ok := repository.state.activeUser(request.Id)
+	if !ok {
+		return nil, model.ErrUserNotFound
+	}
+	user = cloneUser(user)
+	return &user, nil
+}
+
+// LookupUser returns the user with the given email or nickname, model.ErrUserNotFound if it does not exist
+func (repository *MemoryRepository) LookupUser(ctx context.Context, request *api.LookupUserRequest) (*model.User, error) {
+	log.Debug("Starting lookup user")
+	defer repository.rlock(ctx)()
+	switch key := request.Key.(type) {
+	case *api.LookupUserRequest_Email:
+		return repository.state.findActiveUser(model.FieldEmail, key.Email)
+	case *api.LookupUserRequest_Nickname:
+		return repository.state.findActiveUser(model.FieldNickname, key.Nickname)
+	default:
+		return nil, model.ErrUserNotFound
+	}
+}
+
+// AuthenticateUser returns the User matching the given login if the password is correct, model.ErrInvalidCredentials otherwise
+func (repository *MemoryRepository) AuthenticateUser(ctx context.Context, request *api.AuthenticateRequest) (*model.User, error) {
+	log.Debug("Starting authentication of user")
+	var field, login string
+	switch requestLogin := request.Login.(type) {
+	case *api.AuthenticateRequest_Email:
+		field, login = model.FieldEmail, requestLogin.Email
+	case *api.AuthenticateRequest_Nickname:
+		field, login = model.FieldNickname, requestLogin.Nickname
+	default:
+		return nil, model.ErrInvalidCredentials
+	}
+	unlock := repository.rlock(ctx)
+	existingUser, err := repository.state.findActiveUser(field, login)
+	unlock()
+	if errors.Is(err, model.ErrUserNotFound) {
+		// Verify anyway so that response time does not reveal whether the account exists
+		_, _, _ = repository.PasswordHasher.Verify(request.Password, repository.dummyPasswordHash)
+		log.Debug("No user found for the given login")
+		return nil, model.ErrInvalidCredentials
+	}
+	match, needsRehash, err := repository.PasswordHasher.Verify(request.Password, existingUser.Password)
+	if err != nil {
+		log.Error("Error while verifying the password of user ", existingUser.ID, " ", err)
+		return nil, model.ErrInvalidCredentials
+	}
+	if !match {
+		log.Debug("Wrong password for user ", existingUser.ID)
+		return nil, model.ErrInvalidCredentials
+	}
+	if needsRehash {
+		repository.rehashPassword(ctx, existingUser, request.Password)
+	}
+	log.Debug("Authenticated user ", existingUser.ID)
+	return existingUser, nil
+}
+
+// rehashPassword replaces the stored hash of the user with one computed with the current hashing settings, unless it changed meanwhile.
+// Failures are only logged, the old hash is still valid and the upgrade will be retried on next login
+func (repository *MemoryRepository) rehashPassword(ctx context.Context, user *model.User, password string) {
+	hashedPassword, err := repository.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Error("Error while rehashing the password of user ", user.ID, " ", err)
+		return
+	}
+	defer repository.lock(ctx)()
+	storedUser, ok := repository.state.users[user.ID]
+	if !ok || storedUser.Password != user.Password {
+		return
+	}
+	storedUser.Password = hashedPassword
+	repository.state.putUser(storedUser)
+	user.Password = hashedPassword
+	log.Debug("Upgraded password hash of user ", user.ID)
+}
+
+// IsTaken reports whether a user already uses the value of the given unique field, model.FieldEmail or model.FieldNickname
+func (repository *MemoryRepository) IsTaken(ctx context.Context, field string, value string) (bool, error) {
+	log.Debug("Checking availability of ", field)
+	defer repository.rlock(ctx)()
+	_, err := repository.state.findUser(field, value)
+	return err == nil, nil
+}
+
+// AssignRole adds the role to the user and returns the User before and after the change, assigning a role twice has no effect
+func (repository *MemoryRepository) AssignRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	log.Debug("Assigning role ", role, " to user ", userID)
+	defer repository.lock(ctx)()
+	return repository.state.updateRoles(userID, func(roles []string) []string { return addRole(roles, role) })
+}
+
+// RevokeRole removes the role from the user and returns the User before and after the change
+func (repository *MemoryRepository) RevokeRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	log.Debug("Revoking role ", role, " from user ", userID)
+	defer repository.lock(ctx)()
+	return repository.state.updateRoles(userID, func(roles []string) []string { return removeRole(roles, role) })
+}
+
+// GetUserRoles returns the roles of the user, model.ErrUserNotFound if it does not exist
+func (repository *MemoryRepository) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	defer repository.rlock(ctx)()
+	user, ok := repository.state.activeUser(userID)
+	if !ok {
+		return nil, model.ErrUserNotFound
+	}
+	return user.Roles, nil
+}
+
+// EnsureAdminUser creates the administrator with the given credentials if no user has the admin role yet, and returns it.
+// Existing accounts are never promoted, nil is returned if an administrator already exists
+func (repository *MemoryRepository) EnsureAdminUser(ctx context.Context, email string, nickname string, password string) (*model.User, error) {
+	admin, err := newAdminUser(repository.PasswordHasher, email, nickname, password, repository.now())
+	if err != nil {
+		return nil, err
+	}
+	defer repository.lock(ctx)()
+	for _, user := range repository.state.users {
+		for _, role := range user.Roles {
+			if role == model.AdminRole {
+				log.Info("An admin user already exists, skipping the admin bootstrap")
+				return nil, nil
+			}
+		}
+	}
+	if err = repository.state.insertUser(*admin); err != nil {
+		log.Error("Error while creating admin user ", err)
+		return nil, err
+	}
+	log.Info("Created admin user ", admin.ID)
+	return admin, nil
+}
+
+// SeedRoles creates the given roles if they do not exist yet, existing roles are left untouched
+func (repository *MemoryRepository) SeedRoles(ctx context.Context, roles []model.Role) error {
+	defer repository.lock(ctx)()
+	for _, role := range roles {
+		if _, ok := repository.state.roles[role.Name]; !ok {
+			setEntry(&repository.state, repository.state.roles, role.Name, cloneRole(role))
+		}
+	}
+	log.Debug("Seeded default roles")
+	return nil
+}
+
+// ListRoles returns all the defined roles
+func (repository *MemoryRepository) ListRoles(ctx context.Context) ([]model.Role, error) {
+	defer repository.rlock(ctx)()
+	var roles []model.Role
+	for _, role := range repository.state.roles {
+		roles = append(roles, cloneRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// GetRole returns the role with the given name, model.ErrRoleNotFound if it does not exist
+func (repository *MemoryRepository) GetRole(ctx context.Context, name string) (*model.Role, error) {
+	defer repository.rlock(ctx)()
+	role, ok := repository.state.roles[name]
+	if !ok {
+		return nil, model.ErrRoleNotFound
+	}
+	role = cloneRole(role)
+	return &role, nil
+}
+
+// PutRole creates the role or replaces its definition if it already exists
+func (repository *MemoryRepository) PutRole(ctx context.Context, role *model.Role) error {
+	log.Debug("Storing role ", role.Name)
+	defer repository.lock(ctx)()
+	setEntry(&repository.state, repository.state.roles, role.Name, cloneRole(*role))
+	return nil
+}
+
+// CreateRefreshToken stores a new refresh token
+func (repository *MemoryRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	log.Debug("Storing new refresh token for user ", token.UserID)
+	defer repository.lock(ctx)()
+	repository.state.pruneRefreshTokens(repository.now())
+	if _, ok := repository.state.refreshTokens[token.Hash]; ok {
+		return errors.New("a refresh token with the same hash already exists")
+	}
+	setEntry(&repository.state, repository.state.refreshTokens, token.Hash, *token)
+	return nil
+}
+
+// UseRefreshToken atomically marks the refresh token as replaced by a new one and returns it.
+// It returns model.ErrRefreshTokenReused if the token was already replaced or revoked, model.ErrRefreshTokenNotFound if it does not exist
+func (repository *MemoryRepository) UseRefreshToken(ctx context.Context, tokenHash string, replacedBy string) (*model.RefreshToken, error) {
+	defer repository.lock(ctx)()
+	token, ok := repository.state.refreshTokens[tokenHash]
+	if !ok {
+		return nil, model.ErrRefreshTokenNotFound
+	}
+	if token.ReplacedBy != "" || token.Revoked {
+		log.Warn("Refresh token of family ", token.FamilyID, " has been reused")
+		return &token, model.ErrRefreshTokenReused
+	}
+	// the token is returned as it was before the update, like MongoDB FindOneAndUpdate
+	used := token
+	used.ReplacedBy = replacedBy
+	setEntry(&repository.state, repository.state.refreshTokens, tokenHash, used)
+	return &token, nil
+}
+
+// GetRefreshToken returns the refresh token with the given hash, model.ErrRefreshTokenNotFound if it does not exist
+func (repository *MemoryRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	defer repository.rlock(ctx)()
+	token, ok := repository.state.refreshTokens[tokenHash]
+	if !ok {
+		return nil, model.ErrRefreshTokenNotFound
+	}
+	return &token, nil
+}
+
+// RevokeRefreshTokenFamily revokes all the refresh tokens issued from the same login
+func (repository *MemoryRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	log.Debug("Revoking refresh tokens of family ", familyID)
+	defer repository.lock(ctx)()
+	for hash, token := range repository.state.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			setEntry(&repository.state, repository.state.refreshTokens, hash, token)
+		}
+	}
+	return nil
+}
+
+// EnqueueEvent stores the event in the outbox, it is published once the surrounding transaction is committed.
+// If a previous event of the user is waiting for a retry, the event waits too so that the events of a user stay ordered
+func (repository *MemoryRepository) EnqueueEvent(ctx context.Context, event model.Event) error {
+	payload, err := proto.Marshal(event)
+	if err != nil {
+		log.Error("Error while serializing the event ", err)
+		return err
+	}
+	createdAt := repository.now().UTC()
+	entry := model.OutboxEntry{
+		ID:            event.GetMetadata().GetEventId(),
+		UserID:        event.GetUserId(),
+		MessageType:   string(event.ProtoReflect().Descriptor().FullName()),
+		Payload:       payload,
+		CreatedAt:     createdAt,
+		NextAttemptAt: createdAt,
+	}
+	defer repository.lock(ctx)()
+	if _, ok := repository.state.outbox[entry.ID]; ok {
+		return fmt.Errorf("event %s is already in the outbox", entry.ID)
+	}
+	for _, waiting := range repository.state.outbox {
+		if waiting.UserID == entry.UserID && waiting.ParkedAt == nil && waiting.NextAttemptAt.After(entry.NextAttemptAt) {
+			entry.NextAttemptAt = waiting.NextAttemptAt
+		}
+	}
+	setEntry(&repository.state, repository.state.outbox, entry.ID, entry)
+	log.Debug("Stored event ", entry.ID, " in the outbox")
+	return nil
+}
+
+// ClaimPendingEvents leases to owner the oldest entries that can be published at now, in creation order.
+// The entries of a user are claimed only starting from the oldest pending one
+func (repository *MemoryRepository) ClaimPendingEvents(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.OutboxEntry, error) {
+	defer repository.lock(ctx)()
+	leaseUntil := now.Add(lease)
+	blockedUsers := make(map[string]bool)
+	var claimed []model.OutboxEntry
+	for _, entry := range repository.state.unpublishedEvents(0, 0, false) {
+		if len(claimed) == limit {
+			break
+		}
+		if blockedUsers[entry.UserID] {
+			continue
+		}
+		if entry.NextAttemptAt.After(now) || (entry.LeaseUntil != nil && entry.LeaseUntil.After(now)) {
+			blockedUsers[entry.UserID] = true
+			continue
+		}
+		entry.LeaseOwner = owner
+		entry.LeaseUntil = &leaseUntil
+		setEntry(&repository.state, repository.state.outbox, entry.ID, entry)
+		claimed = append(claimed, entry)
+	}
+	return claimed, nil
+}
+
+// MarkEventPublished records the publication of the entry.
+// Published entries are removed right away, MongoDB keeps them for a few days only for troubleshooting
+func (repository *MemoryRepository) MarkEventPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	defer repository.lock(ctx)()
+	deleteEntry(&repository.state, repository.state.outbox, id)
+	return nil
+}
+
+// MarkEventFailed records a failed publication of the entry, that will be retried at nextAttemptAt.
+// The following entries of the user are released and wait until then too
+func (repository *MemoryRepository) MarkEventFailed(ctx context.Context, id string, userID string, nextAttemptAt time.Time, lastError string) error {
+	defer repository.lock(ctx)()
+	if entry, ok := repository.state.outbox[id]; ok {
+		entry.Attempts++
+		entry.NextAttemptAt = nextAttemptAt
+		entry.LastError = lastError
+		entry.LeaseUntil = nil
+		setEntry(&repository.state, repository.state.outbox, id, entry)
+	}
+	for entryID, entry := range repository.state.outbox {
+		if entry.UserID == userID && entry.ParkedAt == nil && entry.NextAttemptAt.Before(nextAttemptAt) {
+			entry.NextAttemptAt = nextAttemptAt
+			entry.LeaseUntil = nil
+			setEntry(&repository.state, repository.state.outbox, entryID, entry)
+		}
+	}
+	return nil
+}
+
+// ParkEvent records that the entry cannot be published, it is not retried anymore and does not block the following entries of the user
+func (repository *MemoryRepository) ParkEvent(ctx context.Context, id string, parkedAt time.Time, lastError string) error {
+	defer repository.lock(ctx)()
+	entry, ok := repository.state.outbox[id]
+	if !ok {
+		return nil
+	}
+	entry.Attempts++
+	entry.ParkedAt = &parkedAt
+	entry.LastError = lastError
+	entry.LeaseUntil = nil
+	setEntry(&repository.state, repository.state.outbox, id, entry)
+	return nil
+}
+
+// GetOutboxStats returns the number of pending and parked entries and the creation time of the oldest pending one
+func (repository *MemoryRepository) GetOutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	defer repository.rlock(ctx)()
+	pending := repository.state.unpublishedEvents(0, 0, false)
+	all := repository.state.unpublishedEvents(0, 0, true)
+	stats := &model.OutboxStats{Pending: int64(len(pending)), Parked: int64(len(all) - len(pending))}
+	if len(pending) > 0 {
+		stats.OldestCreatedAt = pending[0].CreatedAt
+	}
+	return stats, nil
+}
+
+// ListStuckEvents returns the entries not published that failed at least minAttempts times, parked ones included, in creation order
+func (repository *MemoryRepository) ListStuckEvents(ctx context.Context, minAttempts int, limit int) ([]model.OutboxEntry, error) {
+	defer repository.rlock(ctx)()
+	return repository.state.unpublishedEvents(minAttempts, limit, true), nil
+}
+
+// updateRoles replaces the roles of the user with the result of update, model.ErrUserNotFound if it does not exist
+func (state *memoryState) updateRoles(userID string, update func(roles []string) []string) (*model.User, *model.User, error) {
+	user, ok := state.activeUser(userID)
+	if !ok {
+		log.Error("User ", userID, " is not present in the database")
+		return nil, nil, model.ErrUserNotFound
+	}
+	previousUser := cloneUser(user)
+	updatedUser := cloneUser(user)
+	updatedUser.Roles = update(updatedUser.Roles)
+	if sameRoles(previousUser.Roles, updatedUser.Roles) {
+		// nothing to store, the version is kept so that the etag of the user stays valid
+		return &previousUser, &updatedUser, nil
+	}
+	updatedUser.Version++
+	state.putUser(updatedUser)
+	return &previousUser, &updatedUser, nil
+}
+
+// unpublishedEvents returns the entries not published yet that failed at least minAttempts times, limit 0 returns all of them.
+// Parked entries are returned only if withParked is set
+func (state *memoryState) unpublishedEvents(minAttempts int, limit int, withParked bool) []model.OutboxEntry {
+	var entries []model.OutboxEntry
+	for _, entry := range state.outbox {
+		if entry.PublishedAt == nil && entry.Attempts >= minAttempts && (withParked || entry.ParkedAt == nil) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// insertUser stores a new user, model.DuplicateError if another user has the same email or nickname
+func (state *memoryState) insertUser(user model.User) error {
+	if _, ok := state.users[user.ID]; ok {
+		return fmt.Errorf("a user with id %s already exists", user.ID)
+	}
+	if err := state.checkUnique(user); err != nil {
+		return err
+	}
+	state.putUser(user)
+	return nil
+}
+
+// checkUnique returns a model.DuplicateError if another user has the same email or nickname, in the order of the unique indexes
+func (state *memoryState) checkUnique(user model.User) error {
+	for _, field := range []string{model.FieldEmail, model.FieldNickname} {
+		existingUser, err := state.findUser(field, normalizedValue(user, field))
+		if err == nil && existingUser.ID != user.ID {
+			return &model.DuplicateError{Field: field}
+		}
+	}
+	return nil
+}
+
+// findUser returns the user having the normalized value of the given unique field, model.ErrUserNotFound if there is none
+func (state *memoryState) findUser(field string, value string) (*model.User, error) {
+	if field != model.FieldEmail && field != model.FieldNickname {
+		return nil, model.ErrUserNotFound
+	}
+	normalized := model.Normalize(value)
+	for _, id := range state.userIDs {
+		user := state.users[id]
+		if normalizedValue(user, field) == normalized {
+			user = cloneUser(user)
+			return &user, nil
+		}
+	}
+	return nil, model.ErrUserNotFound
+}
+
+// findActiveUser returns the user not deleted having the normalized value of the given unique field, model.ErrUserNotFound if there is none
+func (state *memoryState) findActiveUser(field string, value string) (*model.User, error) {
+	user, err := state.findUser(field, value)
+	if err == nil && user.DeletedAt != nil {
+		return nil, model.ErrUserNotFound
+	}
+	return user, err
+}
+
+// activeUser returns a copy of the user with the given id, false if it does not exist or is deleted
+func (state *memoryState) activeUser(userID string) (model.User, bool) {
+	user, ok := state.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return model.User{}, false
+	}
+	return cloneUser(user), true
+}
+
+func normalizedValue(user model.User, field string) string {
+	switch field {
+	case model.FieldEmail:
+		return user.EmailNormalized
+	case model.FieldNickname:
+		return user.NicknameNormalized
+	default:
+		return ""
+	}
+}
+
+// putUser stores the user, new users are added after the existing ones
+func (state *memoryState) putUser(user model.User) {
+	previousUser, exists := state.users[user.ID]
+	state.users[user.ID] = cloneUser(user)
+	if exists {
+		state.onRollback(func() { state.users[user.ID] = previousUser })
+		return
+	}
+	state.userIDs = append(state.userIDs, user.ID)
+	state.onRollback(func() {
+		delete(state.users, user.ID)
+		state.userIDs = state.userIDs[:len(state.userIDs)-1]
+	})
+}
+
+// removeUser deletes the user if it exists
+func (state *memoryState) removeUser(userID string) {
+	deletedUser, ok := state.users[userID]
+	if !ok {
+		return
+	}
+	delete(state.users, userID)
+	for i, id := range state.userIDs {
+		if id == userID {
+			state.userIDs = append(state.userIDs[:i:i], state.userIDs[i+1:]...)
+			state.onRollback(func() {
+				state.userIDs = append(state.userIDs[:i:i], append([]string{userID}, state.userIDs[i:]...)...)
+				state.users[userID] = deletedUser
+			})
+			return
+		}
+	}
+}
+
+// pruneRefreshTokens deletes the expired refresh tokens, like the TTL index of MongoDB
+func (state *memoryState) pruneRefreshTokens(now time.Time) {
+	for hash, token := range state.refreshTokens {
+		if token.ExpiresAt.Before(now) {
+			deleteEntry(state, state.refreshTokens, hash)
+		}
+	}
+}
+
+// onRollback records how to revert a change made by a transaction, changes made outside transactions are not recorded
+func (state *memoryState) onRollback(undo func()) {
+	if state.recording {
+		state.undoLog = append(state.undoLog, undo)
+	}
+}
+
+// rollback reverts the changes recorded by the transaction, the last one first
+func (state *memoryState) rollback() {
+	for i := len(state.undoLog) - 1; i >= 0; i-- {
+		state.undoLog[i]()
+	}
+	state.undoLog = nil
+}
+
+// setEntry stores the value in entries, recording how to restore the previous one
+func setEntry[K comparable, V any](state *memoryState, entries map[K]V, key K, value V) {
+	previousValue, exists := entries[key]
+	entries[key] = value
+	state.onRollback(func() {
+		if exists {
+			entries[key] = previousValue
+		} else {
+			delete(entries, key)
+		}
+	})
+}
+
+// deleteEntry removes the key from entries, recording how to restore it
+func deleteEntry[K comparable, V any](state *memoryState, entries map[K]V, key K) {
+	previousValue, exists := entries[key]
+	if !exists {
+		return
+	}
+	delete(entries, key)
+	state.onRollback(func() { entries[key] = previousValue })
+}
+
+// cloneUser copies the user roles, so that the stored user cannot be changed by the callers
+func cloneUser(user model.User) model.User {
+	user.Roles = append([]string(nil), user.Roles...)
+	user.SearchWords = append([]string(nil), user.SearchWords...)
+	user.SearchPrefixes = append([]string(nil), user.SearchPrefixes...)
+	return user
+}
+
+func cloneRole(role model.Role) model.Role {
+	role.Permissions = append([]string(nil), role.Permissions...)
+	return role
+}
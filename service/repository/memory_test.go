@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/model"
+	"user/service/utility"
+)
+
+func TestMemoryTransactionLocksAtFirstOperation(t *testing.T) {
+	repo := NewMemoryRepository(&utility.BcryptHasher{Cost: 4})
+	err := repo.RunInTransaction(ctx, func(txCtx context.Context) error {
+		// the work done before the first operation, e.g. hashing, does not block the other callers
+		done := make(chan error)
+		go func() {
+			_, err := repo.IsTaken(ctx, model.FieldEmail, "user@email.com")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the transaction blocks the other callers before its first operation")
+		}
+		_, err := repo.CreateUser(txCtx, &api.CreateUserRequest{Nickname: "user", Email: "user@email.com", Password: "password"})
+		return err
+	})
+	assert.NoError(t, err)
+	taken, err := repo.IsTaken(ctx, model.FieldEmail, "user@email.com")
+	assert.NoError(t, err)
+	assert.True(t, taken)
+}
+
+func TestMemoryPruneExpiredRefreshTokens(t *testing.T) {
+	repo := NewMemoryRepository(&utility.BcryptHasher{Cost: 4})
+	now := time.Now()
+	expired := &model.RefreshToken{Hash: "expired", UserID: "user", FamilyID: "family", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	valid := &model.RefreshToken{Hash: "valid", UserID: "user", FamilyID: "family", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, repo.CreateRefreshToken(ctx, expired))
+	assert.NoError(t, repo.CreateRefreshToken(ctx, valid))
+	_, err := repo.GetRefreshToken(ctx, "expired")
+	assert.ErrorIs(t, err, model.ErrRefreshTokenNotFound)
+	_, err = repo.GetRefreshToken(ctx, "valid")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,290 @@
+package repository
+
+// This file implements the versioned migrations of the MongoDB data layer, the applied ones are recorded in schema_migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+	"user/service/model"
+)
+
+// migrationLockID is the id of the document of the migration_lock collection held while migrating
+const migrationLockID = "migrations"
+
+// migrationLockLease is how long the lock is held at most, so that a replica stopped while migrating does not block the others
+const migrationLockLease = 10 * time.Minute
+
+// migrationLockPoll is how often a replica waiting for the lock tries to acquire it
+const migrationLockPoll = time.Second
+
+// mongoMigration changes the collections or their documents, it is applied once and recorded with its version.
+// A migration interrupted before being recorded is applied again, so it must be idempotent
+type mongoMigration struct {
+	Version int
+	Name    string
+	Up      func(repository *Repository, ctx context.Context) error
+}
+
+// mongoMigrations are applied in order, released ones are never changed or removed
+var mongoMigrations = []mongoMigration{
+	{Version: 1, Name: "normalize_users", Up: (*Repository).normalizeUsers},
+	{Version: 2, Name: "create_indexes", Up: (*Repository).createIndexes},
+	{Version: 3, Name: "convert_user_dates", Up: (*Repository).convertUserDates},
+	{Version: 4, Name: "index_users_created_at", Up: (*Repository).indexUsersCreatedAt},
+	{Version: 5, Name: "index_users_sort", Up: (*Repository).indexUsersSort},
+	{Version: 6, Name: "index_users_search", Up: (*Repository).indexUsersSearch},
+	{Version: 7, Name: "set_user_versions", Up: (*Repository).setUserVersions},
+	{Version: 8, Name: "index_users_deleted_at", Up: (*Repository).indexUsersDeletedAt},
+}
+
+// appliedMigration is a document of the schema_migrations collection
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Migrate applies the pending migrations in version order.
+// The replicas starting together wait for the one holding the migration lock
+func (repository *Repository) Migrate(ctx context.Context) error {
+	owner := uuid.New().String()
+	if err := repository.waitMigrationLock(ctx, owner); err != nil {
+		return err
+	}
+	defer repository.releaseMigrationLock(owner)
+	applied, err := repository.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range mongoMigrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err = migration.Up(repository, ctx); err != nil {
+			log.Error("Error while applying migration ", migration.Version, " ", migration.Name, " ", err)
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		record := appliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: repository.now().UTC()}
+		if _, err = repository.GetMigrationsConnection().InsertOne(ctx, record); err != nil {
+			log.Error("Error while recording migration ", migration.Version, " ", err)
+			return err
+		}
+		log.Info("Applied migration ", migration.Version, " ", migration.Name)
+	}
+	return nil
+}
+
+// MigrationStatus returns the known migrations and the applied ones unknown to this version of the service, in version order
+func (repository *Repository) MigrationStatus(ctx context.Context) ([]model.MigrationStatus, error) {
+	applied, err := repository.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []model.MigrationStatus
+	for _, migration := range mongoMigrations {
+		status := model.MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		statuses = append(statuses, model.MigrationStatus{Version: record.Version, Name: record.Name, AppliedAt: &appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// convertUserDates stores as dates the creation and update times of the users stored as RFC 3339 strings
+func (repository *Repository) convertUserDates(ctx context.Context) error {
+	for _, field := range []string{"created_at", "updated_at"} {
+		filter := bson.D{{Key: field, Value: bson.D{{Key: "$type", Value: "string"}}}}
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+			{Key: field, Value: bson.D{{Key: "$dateFromString", Value: bson.D{{Key: "dateString", Value: "$" + field}}}}},
+		}}}}
+		result, err := repository.GetConnection().UpdateMany(ctx, filter, update)
+		if err != nil {
+			log.Error("Error while converting the ", field, " of the users ", err)
+			return err
+		}
+		log.Info("Converted the ", field, " of ", result.ModifiedCount, " users to dates")
+	}
+	return nil
+}
+
+// indexUsersCreatedAt creates the index used to sort and filter the users by creation time
+func (repository *Repository) indexUsersCreatedAt(ctx context.Context) error {
+	_, err := repository.GetConnection().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "created_at", Value: 1}}})
+	if err != nil {
+		log.Error("Error while creating the users created_at index ", err)
+	}
+	return err
+}
+
+// indexUsersSort replaces the created_at index with the ones used to list the users by creation time then id
+func (repository *Repository) indexUsersSort(ctx context.Context) error {
+	_, err := repository.GetConnection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "country", Value: 1}, {Key: "created_at", Value: 1}, {Key: "id", Value: 1}}},
+	})
+	if err != nil {
+		log.Error("Error while creating the users sort indexes ", err)
+		return err
+	}
+	// the index is missing if the migration is applied again after an interruption
+	_, err = repository.GetConnection().Indexes().DropOne(ctx, "created_at_1")
+	var commandError mongo.CommandError
+	if err != nil && !(errors.As(err, &commandError) && commandError.Name == "IndexNotFound") {
+		log.Error("Error while dropping the users created_at index ", err)
+		return err
+	}
+	return nil
+}
+
+// indexUsersSearch sets the search words and prefixes of the users indexed before the search, then creates the text index
+// of the prefixes. The index ignores the language, so that the words are neither stemmed nor dropped as stop words
+func (repository *Repository) indexUsersSearch(ctx context.Context) error {
+	usersCollection := repository.GetConnection()
+	cursor, err := usersCollection.Find(ctx, bson.D{{Key: "search_prefixes", Value: bson.D{{Key: "$exists", Value: false}}}})
+	if err != nil {
+		log.Error("Error while reading the users to index ", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+	indexed := 0
+	for cursor.Next(ctx) {
+		var user model.User
+		if err = cursor.Decode(&user); err != nil {
+			log.Error("Error while reading the users to index ", err)
+			return err
+		}
+		user.IndexSearch()
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "search_words", Value: user.SearchWords}, {Key: "search_prefixes", Value: user.SearchPrefixes}}}}
+		if _, err = usersCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: user.ID}}, update); err != nil {
+			log.Error("Error while indexing the search of user ", user.ID, " ", err)
+			return err
+		}
+		indexed++
+	}
+	if err = cursor.Err(); err != nil {
+		log.Error("Error while reading the users to index ", err)
+		return err
+	}
+	log.Info("Indexed the search of ", indexed, " users")
+	_, err = usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "search_prefixes", Value: "text"}},
+		Options: options.Index().SetName(userSearchIndex).SetDefaultLanguage("none"),
+	})
+	if err != nil {
+		log.Error("Error while creating the users search index ", err)
+	}
+	return err
+}
+
+// setUserVersions sets the first version of the users created before the versions
+func (repository *Repository) setUserVersions(ctx context.Context) error {
+	filter := bson.D{{Key: "version", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "version", Value: int64(1)}}}}
+	result, err := repository.GetConnection().UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error("Error while setting the versions of the users ", err)
+		return err
+	}
+	log.Info("Set the version of ", result.ModifiedCount, " users")
+	return nil
+}
+
+// indexUsersDeletedAt creates the index of the deleted users used by the purge, the users not deleted are not indexed
+func (repository *Repository) indexUsersDeletedAt(ctx context.Context) error {
+	_, err := repository.GetConnection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: true}}}}),
+	})
+	if err != nil {
+		log.Error("Error while creating the users deleted_at index ", err)
+	}
+	return err
+}
+
+// appliedMigrations returns the migrations recorded in schema_migrations by version
+func (repository *Repository) appliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	cursor, err := repository.GetMigrationsConnection().Find(ctx, bson.D{})
+	if err != nil {
+		log.Error("Error while reading the applied migrations ", err)
+		return nil, err
+	}
+	var records []appliedMigration
+	if err = cursor.All(ctx, &records); err != nil {
+		log.Error("Error while reading the applied migrations ", err)
+		return nil, err
+	}
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		record.AppliedAt = record.AppliedAt.UTC()
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// waitMigrationLock acquires the migration lock for owner, waiting until it is released or expired
+func (repository *Repository) waitMigrationLock(ctx context.Context, owner string) error {
+	for {
+		acquired, err := repository.acquireMigrationLock(ctx, owner, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		log.Info("Waiting for another replica to complete the migrations")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// acquireMigrationLock takes the migration lock for owner if it is free or expired at now, it reports whether it was acquired
+func (repository *Repository) acquireMigrationLock(ctx context.Context, owner string, now time.Time) (bool, error) {
+	filter := bson.D{{Key: "_id", Value: migrationLockID}, {Key: "locked_until", Value: bson.D{{Key: "$lte", Value: now}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "owner", Value: owner}, {Key: "locked_until", Value: now.Add(migrationLockLease)}}}}
+	// the upsert conflicts with the lock document if it is held by another replica
+	_, err := repository.GetMigrationLockConnection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Error("Error while acquiring the migration lock ", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseMigrationLock releases the lock if it is still held by owner
+func (repository *Repository) releaseMigrationLock(owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.D{{Key: "_id", Value: migrationLockID}, {Key: "owner", Value: owner}}
+	if _, err := repository.GetMigrationLockConnection().DeleteOne(ctx, filter); err != nil {
+		log.Error("Error while releasing the migration lock ", err)
+	}
+}
+
+func (repository *Repository) GetMigrationsConnection() *mongo.Collection {
+	return repository.client.Database("users_collection").Collection("schema_migrations")
+}
+
+func (repository *Repository) GetMigrationLockConnection() *mongo.Collection {
+	return repository.client.Database("users_collection").Collection("migration_lock")
+}
@@ -0,0 +1,58 @@
+-- Users are returned in creation order, like MongoDB returns them without a sort
+CREATE TABLE users (
+    seq                 BIGSERIAL PRIMARY KEY,
+    id                  TEXT   NOT NULL CONSTRAINT unique_id UNIQUE,
+    first_name          TEXT   NOT NULL DEFAULT '',
+    last_name           TEXT   NOT NULL DEFAULT '',
+    nickname            TEXT   NOT NULL,
+    password            TEXT   NOT NULL,
+    email               TEXT   NOT NULL,
+    country             TEXT   NOT NULL DEFAULT '',
+    created_at          TEXT   NOT NULL,
+    updated_at          TEXT   NOT NULL,
+    roles               TEXT[] NOT NULL DEFAULT '{}',
+    -- normalized values are unique, so that e.g. User@Email.com and user@email.com collide
+    email_normalized    TEXT   NOT NULL CONSTRAINT unique_email UNIQUE,
+    nickname_normalized TEXT   NOT NULL CONSTRAINT unique_nickname UNIQUE
+);
+
+CREATE INDEX users_country ON users (country, seq);
+CREATE INDEX users_roles ON users USING GIN (roles);
+
+CREATE TABLE refresh_tokens (
+    hash        TEXT        PRIMARY KEY,
+    user_id     TEXT        NOT NULL,
+    family_id   TEXT        NOT NULL,
+    replaced_by TEXT        NOT NULL DEFAULT '',
+    revoked     BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at ON refresh_tokens (expires_at);
+
+CREATE TABLE roles (
+    name        TEXT   PRIMARY KEY,
+    description TEXT   NOT NULL DEFAULT '',
+    permissions TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE outbox (
+    id              TEXT        PRIMARY KEY,
+    user_id         TEXT        NOT NULL,
+    message_type    TEXT        NOT NULL,
+    payload         BYTEA       NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    published_at    TIMESTAMPTZ,
+    lease_owner     TEXT        NOT NULL DEFAULT '',
+    lease_until     TIMESTAMPTZ,
+    parked_at       TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_pending ON outbox (created_at, id) WHERE published_at IS NULL AND parked_at IS NULL;
+CREATE INDEX outbox_user_pending ON outbox (user_id, created_at, id) WHERE published_at IS NULL;
+CREATE INDEX outbox_published_at ON outbox (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,6 @@
+-- The creation and update times were stored as RFC 3339 strings
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at::TIMESTAMPTZ,
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at::TIMESTAMPTZ;
+
+CREATE INDEX users_created_at ON users (created_at);
@@ -0,0 +1,5 @@
+-- Users are listed by creation time then id, compared byte by byte
+DROP INDEX users_created_at;
+CREATE INDEX users_created_at_id ON users (created_at, id COLLATE "C");
+DROP INDEX users_country;
+CREATE INDEX users_country_created_at_id ON users (country, created_at, id COLLATE "C");
@@ -0,0 +1,3 @@
+-- Users can be ordered by email or nickname, compared byte by byte like the ids
+CREATE INDEX users_email_normalized_order ON users (email_normalized COLLATE "C");
+CREATE INDEX users_nickname_normalized_order ON users (nickname_normalized COLLATE "C");
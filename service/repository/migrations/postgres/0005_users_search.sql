@@ -0,0 +1,4 @@
+-- Users are searched by the prefixes of the words of their names, nickname and email, computed by the service
+ALTER TABLE users ADD COLUMN search_words TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN search_prefixes TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX users_search_prefixes ON users USING GIN (search_prefixes);
@@ -0,0 +1,2 @@
+-- Users are updated only at the version the update was computed from
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- Deleted users are kept until they are purged, the index of the deleted ones is used by the purge
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
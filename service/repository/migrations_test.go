@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMongoMigrationsOrdered(t *testing.T) {
+	for i, migration := range mongoMigrations {
+		assert.NotEmpty(t, migration.Name)
+		assert.NotNil(t, migration.Up)
+		if i > 0 {
+			assert.Less(t, mongoMigrations[i-1].Version, migration.Version)
+		}
+	}
+}
+
+func TestMongoMigrationStatus(t *testing.T) {
+	repo := newTestMongoRepository(t).(*Repository)
+	statuses, err := repo.MigrationStatus(ctx)
+	if assert.NoError(t, err) && assert.Len(t, statuses, len(mongoMigrations)) {
+		for i, status := range statuses {
+			assert.Equal(t, mongoMigrations[i].Version, status.Version)
+			assert.False(t, status.Pending())
+		}
+	}
+}
+
+func TestMongoMigrationLock(t *testing.T) {
+	repo := newTestMongoRepository(t).(*Repository)
+	now := time.Now().UTC()
+	acquired, err := repo.acquireMigrationLock(ctx, "replica-1", now)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = repo.acquireMigrationLock(ctx, "replica-2", now)
+	assert.NoError(t, err)
+	assert.False(t, acquired, "the lock is held by replica-1")
+	acquired, err = repo.acquireMigrationLock(ctx, "replica-2", now.Add(migrationLockLease))
+	assert.NoError(t, err)
+	assert.True(t, acquired, "the lock of replica-1 is expired")
+	repo.releaseMigrationLock("replica-1")
+	acquired, err = repo.acquireMigrationLock(ctx, "replica-3", now.Add(migrationLockLease))
+	assert.NoError(t, err)
+	assert.False(t, acquired, "replica-1 cannot release the lock of replica-2")
+	repo.releaseMigrationLock("replica-2")
+	acquired, err = repo.acquireMigrationLock(ctx, "replica-3", now.Add(migrationLockLease))
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
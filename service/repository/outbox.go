@@ -0,0 +1,249 @@
+package repository
+
+// This file implements the transactional outbox, the events are stored with the user changes and published by the relay
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/proto"
+	"time"
+	"user/service/model"
+)
+
+// RunInTransaction runs fn in a MongoDB transaction, the operations using the context given to fn are committed
+// together if fn succeeds and rolled back otherwise. fn can be retried on transient errors so it must be idempotent
+func (repository *Repository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := repository.client.StartSession()
+	if err != nil {
+		log.Error("Error while starting the transaction session ", err)
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+// EnqueueEvent stores the event in the outbox, it is published once the surrounding transaction is committed.
+// If a previous event of the user is waiting for a retry, the event waits too so that the events of a user stay ordered
+func (repository *Repository) EnqueueEvent(ctx context.Context, event model.Event) error {
+	payload, err := proto.Marshal(event)
+	if err != nil {
+		log.Error("Error while serializing the event ", err)
+		return err
+	}
+	outboxCollection := repository.GetOutboxConnection()
+	createdAt := repository.now().UTC()
+	entry := model.OutboxEntry{
+		ID:            event.GetMetadata().GetEventId(),
+		UserID:        event.GetUserId(),
+		MessageType:   string(event.ProtoReflect().Descriptor().FullName()),
+		Payload:       payload,
+		CreatedAt:     createdAt,
+		NextAttemptAt: createdAt,
+	}
+	var waiting model.OutboxEntry
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "next_attempt_at", Value: -1}})
+	err = outboxCollection.FindOne(ctx, pendingEventsFilter(entry.UserID), findOptions).Decode(&waiting)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error("Error while getting the pending events of user ", entry.UserID, " ", err)
+		return err
+	}
+	if err == nil && waiting.NextAttemptAt.After(entry.NextAttemptAt) {
+		entry.NextAttemptAt = waiting.NextAttemptAt
+	}
+	if _, err = outboxCollection.InsertOne(ctx, entry); err != nil {
+		log.Error("Error while storing the event ", entry.ID, " in the outbox ", err)
+		return err
+	}
+	log.Debug("Stored event ", entry.ID, " in the outbox")
+	return nil
+}
+
+// ClaimPendingEvents leases to owner the oldest entries that can be published at now, in creation order.
+// The entries of a user are claimed only starting from the oldest pending one, so that a relay never publishes
+// an event while a previous event of the same user is claimed by another relay or waiting for a retry
+func (repository *Repository) ClaimPendingEvents(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.OutboxEntry, error) {
+	outboxCollection := repository.GetOutboxConnection()
+	filter := bson.D{
+		{Key: "published_at", Value: nil},
+		{Key: "parked_at", Value: nil},
+		{Key: "next_attempt_at", Value: bson.D{{Key: "$lte", Value: now}}},
+		leaseExpiredFilter(now),
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	candidates, err := repository.findOutboxEntries(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	leaseUntil := now.Add(lease)
+	claimedUsers := make(map[string]bool)
+	skippedUsers := make(map[string]bool)
+	var claimed []model.OutboxEntry
+	for _, entry := range candidates {
+		if skippedUsers[entry.UserID] {
+			continue
+		}
+		if !claimedUsers[entry.UserID] {
+			var head model.OutboxEntry
+			headOptions := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+			if err = outboxCollection.FindOne(ctx, pendingEventsFilter(entry.UserID), headOptions).Decode(&head); err != nil {
+				log.Error("Error while getting the oldest pending event of user ", entry.UserID, " ", err)
+				return claimed, err
+			}
+			if head.ID != entry.ID {
+				skippedUsers[entry.UserID] = true
+				continue
+			}
+		}
+		claimFilter := bson.D{{Key: "id", Value: entry.ID}, {Key: "published_at", Value: nil}, leaseExpiredFilter(now)}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "lease_owner", Value: owner}, {Key: "lease_until", Value: leaseUntil}}}}
+		result, err := outboxCollection.UpdateOne(ctx, claimFilter, update)
+		if err != nil {
+			log.Error("Error while claiming event ", entry.ID, " ", err)
+			return claimed, err
+		}
+		if result.ModifiedCount == 0 {
+			// claimed by another relay meanwhile
+			skippedUsers[entry.UserID] = true
+			continue
+		}
+		entry.LeaseOwner = owner
+		entry.LeaseUntil = &leaseUntil
+		claimedUsers[entry.UserID] = true
+		claimed = append(claimed, entry)
+	}
+	return claimed, nil
+}
+
+// MarkEventPublished records the publication of the entry
+func (repository *Repository) MarkEventPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	filter := bson.D{{Key: "id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "published_at", Value: publishedAt}}}}
+	if _, err := repository.GetOutboxConnection().UpdateOne(ctx, filter, update); err != nil {
+		log.Error("Error while marking event ", id, " as published ", err)
+		return err
+	}
+	return nil
+}
+
+// MarkEventFailed records a failed publication of the entry, that will be retried at nextAttemptAt.
+// The following entries of the user are released and wait until then too
+func (repository *Repository) MarkEventFailed(ctx context.Context, id string, userID string, nextAttemptAt time.Time, lastError string) error {
+	outboxCollection := repository.GetOutboxConnection()
+	filter := bson.D{{Key: "id", Value: id}}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		{Key: "$set", Value: bson.D{
+			{Key: "next_attempt_at", Value: nextAttemptAt},
+			{Key: "last_error", Value: lastError},
+			{Key: "lease_until", Value: nil},
+		}},
+	}
+	if _, err := outboxCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Error("Error while marking event ", id, " as failed ", err)
+		return err
+	}
+	userFilter := append(pendingEventsFilter(userID), bson.E{Key: "next_attempt_at", Value: bson.D{{Key: "$lt", Value: nextAttemptAt}}})
+	userUpdate := bson.D{{Key: "$set", Value: bson.D{{Key: "next_attempt_at", Value: nextAttemptAt}, {Key: "lease_until", Value: nil}}}}
+	if _, err := outboxCollection.UpdateMany(ctx, userFilter, userUpdate); err != nil {
+		log.Error("Error while delaying the events of user ", userID, " ", err)
+		return err
+	}
+	return nil
+}
+
+// ParkEvent records that the entry cannot be published, it is not retried anymore and does not block the following entries of the user
+func (repository *Repository) ParkEvent(ctx context.Context, id string, parkedAt time.Time, lastError string) error {
+	filter := bson.D{{Key: "id", Value: id}}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		{Key: "$set", Value: bson.D{
+			{Key: "parked_at", Value: parkedAt},
+			{Key: "last_error", Value: lastError},
+			{Key: "lease_until", Value: nil},
+		}},
+	}
+	if _, err := repository.GetOutboxConnection().UpdateOne(ctx, filter, update); err != nil {
+		log.Error("Error while parking event ", id, " ", err)
+		return err
+	}
+	return nil
+}
+
+// GetOutboxStats returns the number of pending and parked entries and the creation time of the oldest pending one
+func (repository *Repository) GetOutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	outboxCollection := repository.GetOutboxConnection()
+	filter := bson.D{{Key: "published_at", Value: nil}, {Key: "parked_at", Value: nil}}
+	pending, err := outboxCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Error("Error while counting the pending events ", err)
+		return nil, err
+	}
+	parkedFilter := bson.D{{Key: "published_at", Value: nil}, {Key: "parked_at", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	parked, err := outboxCollection.CountDocuments(ctx, parkedFilter)
+	if err != nil {
+		log.Error("Error while counting the parked events ", err)
+		return nil, err
+	}
+	stats := &model.OutboxStats{Pending: pending, Parked: parked}
+	if pending == 0 {
+		return stats, nil
+	}
+	var oldest model.OutboxEntry
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	err = outboxCollection.FindOne(ctx, filter, findOptions).Decode(&oldest)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error("Error while getting the oldest pending event ", err)
+		return nil, err
+	}
+	stats.OldestCreatedAt = oldest.CreatedAt
+	return stats, nil
+}
+
+// ListStuckEvents returns the entries not published that failed at least minAttempts times, parked ones included, in creation order
+func (repository *Repository) ListStuckEvents(ctx context.Context, minAttempts int, limit int) ([]model.OutboxEntry, error) {
+	filter := bson.D{
+		{Key: "published_at", Value: nil},
+		{Key: "attempts", Value: bson.D{{Key: "$gte", Value: minAttempts}}},
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(limit))
+	return repository.findOutboxEntries(ctx, filter, findOptions)
+}
+
+// pendingEventsFilter matches the entries of the user that are neither published nor parked
+func pendingEventsFilter(userID string) bson.D {
+	return bson.D{{Key: "user_id", Value: userID}, {Key: "published_at", Value: nil}, {Key: "parked_at", Value: nil}}
+}
+
+// leaseExpiredFilter matches the entries not claimed by any relay at now
+func leaseExpiredFilter(now time.Time) bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: "lease_until", Value: nil}},
+		bson.D{{Key: "lease_until", Value: bson.D{{Key: "$lte", Value: now}}}},
+	}}
+}
+
+func (repository *Repository) findOutboxEntries(ctx context.Context, filter bson.D, findOptions *options.FindOptions) ([]model.OutboxEntry, error) {
+	cursor, err := repository.GetOutboxConnection().Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Error("Error while getting the outbox entries ", err)
+		return nil, err
+	}
+	var entries []model.OutboxEntry
+	if err = cursor.All(ctx, &entries); err != nil {
+		log.Error("Error while unmarshalling the outbox entries ", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetOutboxConnection is used to establish the connection to the outbox collection in the service's database
+func (repository *Repository) GetOutboxConnection() *mongo.Collection {
+	return repository.client.Database("users_collection").Collection("outbox")
+}
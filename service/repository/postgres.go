@@ -0,0 +1,716 @@
+package repository
+
+// This file implements the data layer with PostgreSQL, the schema is created by the migrations of migrations/postgres.
+// It follows the semantics of the MongoDB implementation, the conformance tests run against both
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+	"user/service/api"
+	"user/service/model"
+	"user/service/utility"
+)
+
+// uniqueViolation is the PostgreSQL error code raised when a unique constraint is violated
+const uniqueViolation = "23505"
+
+// userColumns are the columns of the users table read into a model.User by scanUser
+const userColumns = "id, first_name, last_name, nickname, password, email, country, created_at, updated_at, roles, email_normalized, nickname_normalized, search_words, search_prefixes, version, deleted_at"
+
+// postgresTransactionKey marks the contexts of the operations run by RunInTransaction, its value is the *sql.Tx
+type postgresTransactionKey struct{}
+
+// sqlExecutor runs the statements either on the database or on the transaction of the context
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// PostgresRepository stores the data in PostgreSQL
+type PostgresRepository struct {
+	db                *sql.DB
+	PasswordHasher    utility.PasswordHasher
+	dummyPasswordHash string
+	// now is the clock of the stored dates, replaced by the tests
+	now func() time.Time
+}
+
+// NewPostgresRepository returns a repository using the given database, Migrate creates its schema
+func NewPostgresRepository(db *sql.DB, hasher utility.PasswordHasher) *PostgresRepository {
+	dummyPasswordHash, err := hasher.Hash(uuid.New().String())
+	if err != nil {
+		log.Error("Error while computing the dummy password hash ", err)
+	}
+	return &PostgresRepository{db: db, PasswordHasher: hasher, dummyPasswordHash: dummyPasswordHash, now: time.Now}
+}
+
+// executor returns the transaction of the context if any, the database otherwise
+func (repository *PostgresRepository) executor(ctx context.Context) sqlExecutor {
+	if tx, ok := ctx.Value(postgresTransactionKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return repository.db
+}
+
+// Ping checks that the database is reachable
+func (repository *PostgresRepository) Ping(ctx context.Context) error {
+	return repository.db.PingContext(ctx)
+}
+
+// Close closes the connection pool, waiting the running queries
+func (repository *PostgresRepository) Close(ctx context.Context) error {
+	return repository.db.Close()
+}
+
+// RunInTransaction commits the operations made with the context given to fn only if fn succeeds
+func (repository *PostgresRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(postgresTransactionKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := repository.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error("Error while starting a transaction ", err)
+		return err
+	}
+	// rolls back if fn fails or panics, it has no effect after the commit
+	defer func() { _ = tx.Rollback() }()
+	if err = fn(context.WithValue(ctx, postgresTransactionKey{}, tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error("Error while committing a transaction ", err)
+		return translatePostgresError(err)
+	}
+	return nil
+}
+
+// CreateUser returns the created User
+func (repository *PostgresRepository) CreateUser(ctx context.Context, request *api.CreateUserRequest) (*model.User, error) {
+	log.Debug("Creating new user entity")
+	user, err := newUser(repository.PasswordHasher, request, repository.now())
+	if err != nil {
+		return nil, err
+	}
+	if err = repository.insertUser(ctx, user); err != nil {
+		log.Error("Error while creating the user", err)
+		return nil, err
+	}
+	log.Debug("Generated user with id ", user.ID)
+	return user, nil
+}
+
+// GetUsersPaginated returns a page of the users selected by the query
+func (repository *PostgresRepository) GetUsersPaginated(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
+	log.Debug("Starting paginated retrieval of users")
+	arguments := &postgresQuery{}
+	var conditions []string
+	if !query.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if query.Filter != nil {
+		conditions = append(conditions, arguments.userCondition(query.Filter))
+	}
+	page := &model.UserPage{}
+	if query.CountTotal {
+		err := repository.executor(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+whereClause(conditions), arguments.args...).Scan(&page.TotalCount)
+		if err != nil {
+			log.Error("Error while counting the users ", err)
+			return nil, err
+		}
+	}
+	if query.After != nil {
+		conditions = append(conditions, arguments.afterCondition(query.Order(), query.After))
+	}
+	statement := "SELECT " + userColumns + " FROM users" + whereClause(conditions) + postgresOrderBy(query.Order())
+	if query.Limit > 0 {
+		statement += " LIMIT " + arguments.arg(query.Limit)
+	}
+	statement += " OFFSET " + arguments.arg(query.Offset)
+	users, err := repository.queryUsers(ctx, statement, arguments.args...)
+	if err != nil {
+		log.Error("Error while getting the users ", err)
+		return nil, err
+	}
+	page.Users = users
+	log.Debug("Retrieved paged users successfully")
+	return page, nil
+}
+
+// SearchUsers returns a page of the users matching the search, ranked by relevance.
+// The GIN index of the prefixes finds the users having all the terms
+func (repository *PostgresRepository) SearchUsers(ctx context.Context, search model.UserSearch) ([]model.UserSearchResult, error) {
+	log.Debug("Starting search of users")
+	prefixes := make([]string, len(search.Terms))
+	for i, term := range search.Terms {
+		prefixes[i] = model.SearchPrefix(term)
+	}
+	arguments := &postgresQuery{}
+	// a point for each term, one more for the terms that are whole words
+	statement := "SELECT * FROM (SELECT " + userColumns + ", " + arguments.arg(len(search.Terms)) +
+		"::BIGINT + (SELECT COUNT(*) FROM unnest(" + arguments.arg(pq.Array(search.Terms)) + "::TEXT[]) AS term WHERE term = ANY(search_words)) AS search_score" +
+		" FROM users WHERE deleted_at IS NULL AND search_prefixes @> " + arguments.arg(pq.Array(prefixes)) + "::TEXT[]) AS results"
+	if search.After != nil {
+		score := arguments.arg(search.After.Score)
+		createdAt := arguments.arg(search.After.User.CreatedAt)
+		statement += fmt.Sprintf(` WHERE search_score < %s OR (search_score = %s AND (created_at > %s OR (created_at = %s AND id COLLATE "C" > %s)))`,
+			score, score, createdAt, createdAt, arguments.arg(search.After.User.ID))
+	}
+	statement += ` ORDER BY search_score DESC, created_at, id COLLATE "C"`
+	if search.Limit > 0 {
+		statement += " LIMIT " + arguments.arg(search.Limit)
+	}
+	rows, err := repository.executor(ctx).QueryContext(ctx, statement, arguments.args...)
+	if err != nil {
+		log.Error("Error while searching the users ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var results []model.UserSearchResult
+	for rows.Next() {
+		var score int64
+		user, err := scanUser(rows, &score)
+		if err != nil {
+			log.Error("Error while searching the users ", err)
+			return nil, err
+		}
+		results = append(results, model.UserSearchResult{User: *user, Score: score})
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("Error while searching the users ", err)
+		return nil, err
+	}
+	log.Debug("Searched users successfully")
+	return results, nil
+}
+
+// UpdateUser returns the User before and after the update
+func (repository *PostgresRepository) UpdateUser(ctx context.Context, request *api.UpdateUserRequest, version int64) (*model.User, *model.User, error) {
+	log.Debug("Starting update user for user ", request.Id)
+	hashedPassword, err := hashUpdatedPassword(repository.PasswordHasher, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	var previousUser, updatedUser *model.User
+	err = repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		existingUser, err := repository.findUser(ctx, "id = $1 FOR UPDATE", request.Id)
+		if err != nil {
+			log.Error("Error while getting the use with id ", request.Id, " ", err)
+			return err
+		}
+		// the row is locked, so it cannot change before the update
+		if err = checkVersion(existingUser, version); err != nil {
+			return err
+		}
+		previousUser = existingUser
+		user := *existingUser
+		updatedUser = &user
+		applyUserUpdate(updatedUser, request, hashedPassword, repository.now())
+		_, err = repository.executor(ctx).ExecContext(ctx, `UPDATE users SET first_name = $2, last_name = $3, nickname = $4,
+			password = $5, email = $6, country = $7, updated_at = $8, email_normalized = $9, nickname_normalized = $10,
+			search_words = $11, search_prefixes = $12, version = $13 WHERE id = $1`,
+			updatedUser.ID, updatedUser.Firstname, updatedUser.Lastname, updatedUser.Nickname, updatedUser.Password, updatedUser.Email,
+			updatedUser.Country, updatedUser.UpdatedAt, updatedUser.EmailNormalized, updatedUser.NicknameNormalized,
+			pq.Array(nonNilStrings(updatedUser.SearchWords)), pq.Array(nonNilStrings(updatedUser.SearchPrefixes)), updatedUser.Version)
+		return translatePostgresError(err)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Debug("Updated users ", updatedUser.ID)
+	return previousUser, updatedUser, nil
+}
+
+// DeleteUser marks the user deleted and returns it, model.ErrUserNotFound if it does not exist or is already deleted
+func (repository *PostgresRepository) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) (*model.User, error) {
+	log.Debug("Starting deletion func for user ", request.Id)
+	row := repository.executor(ctx).QueryRowContext(ctx, "UPDATE users SET deleted_at = $2, version = version + 1 WHERE id = $1 AND deleted_at IS NULL RETURNING "+userColumns,
+		request.Id, storedTime(repository.now()))
+	deletedUser, err := scanUser(row)
+	if errors.Is(err, model.ErrUserNotFound) {
+		log.Error("User ", request.Id, " is not present in the database")
+		return nil, err
+	}
+	if err != nil {
+		log.Error("Cannot delete user ", request.Id, " ", err)
+		return nil, err
+	}
+	log.Debug("Correctly deleted user ", request.Id)
+	return deletedUser, nil
+}
+
+// RestoreUser undoes the deletion of the user and returns it, model.ErrUserNotDeleted if it is not deleted and
+// model.ErrRestorePeriodExpired if it was deleted before deletedAfter
+func (repository *PostgresRepository) RestoreUser(ctx context.Context, userID string, deletedAfter time.Time) (*model.User, error) {
+	log.Debug("Starting restore of user ", userID)
+	var restoredUser *model.User
+	err := repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := repository.findStoredUser(ctx, "id = $1 FOR UPDATE", userID)
+		if err != nil {
+			return err
+		}
+		if err = checkRestorable(user, deletedAfter); err != nil {
+			return err
+		}
+		user.DeletedAt = nil
+		user.Version++
+		restoredUser = user
+		_, err = repository.executor(ctx).ExecContext(ctx, "UPDATE users SET deleted_at = NULL, version = $2 WHERE id = $1", userID, user.Version)
+		return err
+	})
+	if err != nil {
+		log.Info("Cannot restore user ", userID, " ", err)
+		return nil, err
+	}
+	log.Debug("Restored user ", userID)
+	return restoredUser, nil
+}
+
+// PurgeUser removes the user permanently, whether it is deleted or not, and returns it. model.ErrUserNotFound if it does not exist
+func (repository *PostgresRepository) PurgeUser(ctx context.Context, userID string) (*model.User, error) {
+	log.Debug("Starting purge of user ", userID)
+	row := repository.executor(ctx).QueryRowContext(ctx, "DELETE FROM users WHERE id = $1 RETURNING "+userColumns, userID)
+	purgedUser, err := scanUser(row)
+	if errors.Is(err, model.ErrUserNotFound) {
+		log.Error("User ", userID, " is not present in the database")
+		return nil, err
+	}
+	if err != nil {
+		log.Error("Cannot purge user ", userID, " ", err)
+		return nil, err
+	}
+	log.Debug("Purged user ", userID)
+	return purgedUser, nil
+}
+
+// PurgeDeletedUsers removes permanently at most limit users deleted before deletedBefore, the oldest deletions first, and
+// returns them. The users locked by another purge or by a restore are skipped
+func (repository *PostgresRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	users, err := repository.queryUsers(ctx, `DELETE FROM users WHERE id IN (SELECT id FROM users WHERE deleted_at < $1
+		ORDER BY deleted_at LIMIT $2 FOR UPDATE SKIP LOCKED) RETURNING `+userColumns, deletedBefore, limit)
+	if err != nil {
+		log.Error("Error while purging the deleted users ", err)
+		return nil, err
+	}
+	log.Debug("Purged ", len(users), " deleted users")
+	return users, nil
+}
+
+// GetUser returns the user with the given id, model.ErrUserNotFound if it does not exist
+func (repository *PostgresRepository) GetUser(ctx context.Context, request *api.GetUserRequest) (*model.User, error) {
+	log.Debug("Starting get user ", request.Id)
+	return repository.findUser(ctx, "id = $1", request.Id)
+}
+
+// LookupUser returns the user with the given email or nickname, model.ErrUserNotFound if it does not exist
+func (repository *PostgresRepository) LookupUser(ctx context.Context, request *api.LookupUserRequest) (*model.User, error) {
+	log.Debug("Starting lookup user")
+	switch key := request.Key.(type) {
+	case *api.LookupUserRequest_Email:
+		return repository.findUser(ctx, "email_normalized = $1", model.Normalize(key.Email))
+	case *api.LookupUserRequest_Nickname:
+		return repository.findUser(ctx, "nickname_normalized = $1", model.Normalize(key.Nickname))
+	default:
+		return nil, model.ErrUserNotFound
+	}
+}
+
+// AuthenticateUser returns the User matching the given login if the password is correct, model.ErrInvalidCredentials otherwise
+func (repository *PostgresRepository) AuthenticateUser(ctx context.Context, request *api.AuthenticateRequest) (*model.User, error) {
+	log.Debug("Starting authentication of user")
+	var condition, login string
+	switch requestLogin := request.Login.(type) {
+	case *api.AuthenticateRequest_Email:
+		condition, login = "email_normalized = $1", requestLogin.Email
+	case *api.AuthenticateRequest_Nickname:
+		condition, login = "nickname_normalized = $1", requestLogin.Nickname
+	default:
+		return nil, model.ErrInvalidCredentials
+	}
+	existingUser, err := repository.findUser(ctx, condition, model.Normalize(login))
+	if errors.Is(err, model.ErrUserNotFound) {
+		// Verify anyway so that response time does not reveal whether the account exists
+		_, _, _ = repository.PasswordHasher.Verify(request.Password, repository.dummyPasswordHash)
+		log.Debug("No user found for the given login")
+		return nil, model.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	match, needsRehash, err := repository.PasswordHasher.Verify(request.Password, existingUser.Password)
+	if err != nil {
+		log.Error("Error while verifying the password of user ", existingUser.ID, " ", err)
+		return nil, model.ErrInvalidCredentials
+	}
+	if !match {
+		log.Debug("Wrong password for user ", existingUser.ID)
+		return nil, model.ErrInvalidCredentials
+	}
+	if needsRehash {
+		repository.rehashPassword(ctx, existingUser, request.Password)
+	}
+	log.Debug("Authenticated user ", existingUser.ID)
+	return existingUser, nil
+}
+
+// rehashPassword replaces the stored hash of the user with one computed with the current hashing settings, unless it changed meanwhile.
+// Failures are only logged, the old hash is still valid and the upgrade will be retried on next login
+func (repository *PostgresRepository) rehashPassword(ctx context.Context, user *model.User, password string) {
+	hashedPassword, err := repository.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Error("Error while rehashing the password of user ", user.ID, " ", err)
+		return
+	}
+	_, err = repository.executor(ctx).ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2 AND password = $3",
+		hashedPassword, user.ID, user.Password)
+	if err != nil {
+		log.Error("Error while storing the rehashed password of user ", user.ID, " ", err)
+		return
+	}
+	user.Password = hashedPassword
+	log.Debug("Upgraded password hash of user ", user.ID)
+}
+
+// IsTaken reports whether a user already uses the value of the given unique field, model.FieldEmail or model.FieldNickname
+func (repository *PostgresRepository) IsTaken(ctx context.Context, field string, value string) (bool, error) {
+	log.Debug("Checking availability of ", field)
+	if field != model.FieldEmail && field != model.FieldNickname {
+		return false, nil
+	}
+	var taken bool
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM users WHERE %s_normalized = $1)", field)
+	if err := repository.executor(ctx).QueryRowContext(ctx, query, model.Normalize(value)).Scan(&taken); err != nil {
+		log.Error("Error while checking availability of ", field, " ", err)
+		return false, err
+	}
+	return taken, nil
+}
+
+// AssignRole adds the role to the user and returns the User before and after the change, assigning a role twice has no effect
+func (repository *PostgresRepository) AssignRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	log.Debug("Assigning role ", role, " to user ", userID)
+	return repository.updateRoles(ctx, userID, func(roles []string) []string { return addRole(roles, role) })
+}
+
+// RevokeRole removes the role from the user and returns the User before and after the change
+func (repository *PostgresRepository) RevokeRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	log.Debug("Revoking role ", role, " from user ", userID)
+	return repository.updateRoles(ctx, userID, func(roles []string) []string { return removeRole(roles, role) })
+}
+
+// updateRoles replaces the roles of the user with the result of update, model.ErrUserNotFound if it does not exist
+func (repository *PostgresRepository) updateRoles(ctx context.Context, userID string, update func(roles []string) []string) (*model.User, *model.User, error) {
+	var previousUser, updatedUser *model.User
+	err := repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		existingUser, err := repository.findUser(ctx, "id = $1 FOR UPDATE", userID)
+		if err != nil {
+			return err
+		}
+		previousUser = existingUser
+		user := *existingUser
+		user.Roles = update(append([]string(nil), existingUser.Roles...))
+		updatedUser = &user
+		if sameRoles(existingUser.Roles, user.Roles) {
+			// nothing to store, the version is kept so that the etag of the user stays valid
+			return nil
+		}
+		user.Version++
+		_, err = repository.executor(ctx).ExecContext(ctx, "UPDATE users SET roles = $1, version = $2 WHERE id = $3", pq.Array(user.Roles), user.Version, userID)
+		return err
+	})
+	if errors.Is(err, model.ErrUserNotFound) {
+		log.Error("User ", userID, " is not present in the database")
+		return nil, nil, err
+	}
+	if err != nil {
+		log.Error("Error while updating roles of user ", userID, " ", err)
+		return nil, nil, err
+	}
+	return previousUser, updatedUser, nil
+}
+
+// GetUserRoles returns the roles of the user, model.ErrUserNotFound if it does not exist
+func (repository *PostgresRepository) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	roles := []string{}
+	err := repository.executor(ctx).QueryRowContext(ctx, "SELECT roles FROM users WHERE id = $1 AND deleted_at IS NULL", userID).Scan(pq.Array(&roles))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error("Error while getting roles of user ", userID, " ", err)
+		return nil, err
+	}
+	return roles, nil
+}
+
+// EnsureAdminUser creates the administrator with the given credentials if no user has the admin role yet, and returns it.
+// Existing accounts are never promoted, nil is returned if an administrator already exists
+func (repository *PostgresRepository) EnsureAdminUser(ctx context.Context, email string, nickname string, password string) (*model.User, error) {
+	var exists bool
+	err := repository.executor(ctx).QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE $1 = ANY (roles))", model.AdminRole).Scan(&exists)
+	if err != nil {
+		log.Error("Error while looking for an admin user ", err)
+		return nil, err
+	}
+	if exists {
+		log.Info("An admin user already exists, skipping the admin bootstrap")
+		return nil, nil
+	}
+	admin, err := newAdminUser(repository.PasswordHasher, email, nickname, password, repository.now())
+	if err != nil {
+		return nil, err
+	}
+	if err = repository.insertUser(ctx, admin); err != nil {
+		log.Error("Error while creating admin user ", err)
+		return nil, err
+	}
+	log.Info("Created admin user ", admin.ID)
+	return admin, nil
+}
+
+// SeedRoles creates the given roles if they do not exist yet, existing roles are left untouched
+func (repository *PostgresRepository) SeedRoles(ctx context.Context, roles []model.Role) error {
+	for _, role := range roles {
+		_, err := repository.executor(ctx).ExecContext(ctx,
+			"INSERT INTO roles (name, description, permissions) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING",
+			role.Name, role.Description, pq.Array(nonNilStrings(role.Permissions)))
+		if err != nil {
+			log.Error("Error while seeding role ", role.Name, " ", err)
+			return err
+		}
+	}
+	log.Debug("Seeded default roles")
+	return nil
+}
+
+// ListRoles returns all the defined roles
+func (repository *PostgresRepository) ListRoles(ctx context.Context) ([]model.Role, error) {
+	rows, err := repository.executor(ctx).QueryContext(ctx, "SELECT name, description, permissions FROM roles ORDER BY name")
+	if err != nil {
+		log.Error("Error while listing roles ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []model.Role
+	for rows.Next() {
+		var role model.Role
+		if err = rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			log.Error("Error while reading roles data ", err)
+			return nil, err
+		}
+		role.Permissions = nonNilStrings(role.Permissions)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GetRole returns the role with the given name, model.ErrRoleNotFound if it does not exist
+func (repository *PostgresRepository) GetRole(ctx context.Context, name string) (*model.Role, error) {
+	var role model.Role
+	err := repository.executor(ctx).QueryRowContext(ctx, "SELECT name, description, permissions FROM roles WHERE name = $1", name).
+		Scan(&role.Name, &role.Description, pq.Array(&role.Permissions))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.ErrRoleNotFound
+	}
+	if err != nil {
+		log.Error("Error while getting role ", name, " ", err)
+		return nil, err
+	}
+	role.Permissions = nonNilStrings(role.Permissions)
+	return &role, nil
+}
+
+// PutRole creates the role or replaces its definition if it already exists
+func (repository *PostgresRepository) PutRole(ctx context.Context, role *model.Role) error {
+	log.Debug("Storing role ", role.Name)
+	_, err := repository.executor(ctx).ExecContext(ctx, `INSERT INTO roles (name, description, permissions) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET description = excluded.description, permissions = excluded.permissions`,
+		role.Name, role.Description, pq.Array(nonNilStrings(role.Permissions)))
+	if err != nil {
+		log.Error("Error while storing role ", role.Name, " ", err)
+	}
+	return err
+}
+
+// CreateRefreshToken stores a new refresh token, the expired ones are removed
+func (repository *PostgresRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	log.Debug("Storing new refresh token for user ", token.UserID)
+	executor := repository.executor(ctx)
+	if _, err := executor.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < $1", repository.now().UTC()); err != nil {
+		log.Error("Error while removing the expired refresh tokens ", err)
+		return err
+	}
+	_, err := executor.ExecContext(ctx, `INSERT INTO refresh_tokens (hash, user_id, family_id, replaced_by, revoked, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		token.Hash, token.UserID, token.FamilyID, token.ReplacedBy, token.Revoked, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		log.Error("Error while storing the refresh token ", err)
+	}
+	return err
+}
+
+// UseRefreshToken atomically marks the refresh token as replaced by a new one and returns it.
+// It returns model.ErrRefreshTokenReused if the token was already replaced or revoked, model.ErrRefreshTokenNotFound if it does not exist
+func (repository *PostgresRepository) UseRefreshToken(ctx context.Context, tokenHash string, replacedBy string) (*model.RefreshToken, error) {
+	// the token is returned as it was before the update, like MongoDB FindOneAndUpdate
+	row := repository.executor(ctx).QueryRowContext(ctx, `UPDATE refresh_tokens SET replaced_by = $2
+		WHERE hash = $1 AND replaced_by = '' AND NOT revoked
+		RETURNING hash, user_id, family_id, '', revoked, created_at, expires_at`, tokenHash, replacedBy)
+	token, err := scanRefreshToken(row)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, model.ErrRefreshTokenNotFound) {
+		log.Error("Error while using the refresh token ", err)
+		return nil, err
+	}
+	token, err = repository.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	log.Warn("Refresh token of family ", token.FamilyID, " has been reused")
+	return token, model.ErrRefreshTokenReused
+}
+
+// GetRefreshToken returns the refresh token with the given hash, model.ErrRefreshTokenNotFound if it does not exist
+func (repository *PostgresRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	row := repository.executor(ctx).QueryRowContext(ctx, `SELECT hash, user_id, family_id, replaced_by, revoked, created_at, expires_at
+		FROM refresh_tokens WHERE hash = $1`, tokenHash)
+	token, err := scanRefreshToken(row)
+	if err != nil && !errors.Is(err, model.ErrRefreshTokenNotFound) {
+		log.Error("Error while getting the refresh token ", err)
+	}
+	return token, err
+}
+
+// RevokeRefreshTokenFamily revokes all the refresh tokens issued from the same login
+func (repository *PostgresRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	log.Debug("Revoking refresh tokens of family ", familyID)
+	_, err := repository.executor(ctx).ExecContext(ctx, "UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1", familyID)
+	if err != nil {
+		log.Error("Error while revoking refresh tokens of family ", familyID, " ", err)
+	}
+	return err
+}
+
+// insertUser stores a new user, model.DuplicateError if another user has the same id, email or nickname
+func (repository *PostgresRepository) insertUser(ctx context.Context, user *model.User) error {
+	_, err := repository.executor(ctx).ExecContext(ctx, "INSERT INTO users ("+userColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)",
+		user.ID, user.Firstname, user.Lastname, user.Nickname, user.Password, user.Email, user.Country, user.CreatedAt, user.UpdatedAt,
+		pq.Array(nonNilStrings(user.Roles)), user.EmailNormalized, user.NicknameNormalized,
+		pq.Array(nonNilStrings(user.SearchWords)), pq.Array(nonNilStrings(user.SearchPrefixes)), user.Version, user.DeletedAt)
+	return translatePostgresError(err)
+}
+
+// findUser returns the single user not deleted matching the condition, model.ErrUserNotFound if there is none
+func (repository *PostgresRepository) findUser(ctx context.Context, condition string, args ...interface{}) (*model.User, error) {
+	return repository.findStoredUser(ctx, "deleted_at IS NULL AND "+condition, args...)
+}
+
+// findStoredUser returns the single user matching the condition, deleted or not, model.ErrUserNotFound if there is none
+func (repository *PostgresRepository) findStoredUser(ctx context.Context, condition string, args ...interface{}) (*model.User, error) {
+	row := repository.executor(ctx).QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE "+condition, args...)
+	user, err := scanUser(row)
+	if err != nil && !errors.Is(err, model.ErrUserNotFound) {
+		log.Error("Error while getting the user ", err)
+	}
+	return user, err
+}
+
+// queryUsers returns the users selected by the query, that must select the userColumns
+func (repository *PostgresRepository) queryUsers(ctx context.Context, query string, args ...interface{}) ([]model.User, error) {
+	rows, err := repository.executor(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []model.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// whereClause returns the WHERE clause matching all the conditions, empty if there are none
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// scanUser reads the userColumns of the row, model.ErrUserNotFound if there is no row.
+// The extra destinations read the columns selected after the userColumns
+func scanUser(row rowScanner, extra ...interface{}) (*model.User, error) {
+	var user model.User
+	destinations := append([]interface{}{&user.ID, &user.Firstname, &user.Lastname, &user.Nickname, &user.Password, &user.Email,
+		&user.Country, &user.CreatedAt, &user.UpdatedAt, pq.Array(&user.Roles), &user.EmailNormalized, &user.NicknameNormalized,
+		pq.Array(&user.SearchWords), pq.Array(&user.SearchPrefixes), &user.Version, &user.DeletedAt}, extra...)
+	err := row.Scan(destinations...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = user.CreatedAt.UTC()
+	user.UpdatedAt = user.UpdatedAt.UTC()
+	if user.DeletedAt != nil {
+		deletedAt := user.DeletedAt.UTC()
+		user.DeletedAt = &deletedAt
+	}
+	user.Roles = nonNilStrings(user.Roles)
+	return &user, nil
+}
+
+// scanRefreshToken reads a refresh token row, model.ErrRefreshTokenNotFound if there is no row
+func scanRefreshToken(row rowScanner) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	err := row.Scan(&token.Hash, &token.UserID, &token.FamilyID, &token.ReplacedBy, &token.Revoked, &token.CreatedAt, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	return &token, nil
+}
+
+// nonNilStrings returns an empty slice instead of nil, empty arrays are stored and read as such like in MongoDB
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// translatePostgresError returns a model.DuplicateError with the conflicting field if the error is a unique violation
+func translatePostgresError(err error) error {
+	var pqError *pq.Error
+	if !errors.As(err, &pqError) || pqError.Code != uniqueViolation {
+		return err
+	}
+	if field, ok := uniqueIndexFields[pqError.Constraint]; ok {
+		return &model.DuplicateError{Field: field}
+	}
+	log.Warn("Cannot find the conflicting field of unique violation ", err)
+	return err
+}
@@ -0,0 +1,247 @@
+package repository
+
+// This file implements the versioned schema migrations of the PostgreSQL data layer, the SQL files are embedded in the binary
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"user/service/model"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrationFiles embed.FS
+
+// postgresMigrationLock is the key of the advisory lock held while migrating, so that only one replica migrates
+const postgresMigrationLock = 7317001
+
+// postgresMigration is a file of migrations/postgres named <version>_<name>.sql, applied versions are never changed
+type postgresMigration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// postgresMigrationSteps complete the SQL of the migrations with the changes computed by the service, they run in the
+// transaction of the migration with the given version
+var postgresMigrationSteps = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	5: indexPostgresUserSearch,
+}
+
+// loadPostgresMigrations returns the embedded migrations sorted by version
+func loadPostgresMigrations() ([]postgresMigration, error) {
+	files, err := fs.ReadDir(postgresMigrationFiles, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []postgresMigration
+	versions := make(map[int]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".sql")
+		separator := strings.Index(name, "_")
+		if separator < 0 {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", file.Name())
+		}
+		version, err := strconv.Atoi(name[:separator])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", file.Name())
+		}
+		if existing, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", existing, file.Name())
+		}
+		versions[version] = file.Name()
+		content, err := postgresMigrationFiles.ReadFile(path.Join("migrations/postgres", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, postgresMigration{Version: version, Name: name[separator+1:], SQL: string(content)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies the pending migrations in version order, each one in its own transaction.
+// The replicas starting together wait for the one holding the migration lock
+func (repository *PostgresRepository) Migrate(ctx context.Context) error {
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		return err
+	}
+	// the advisory lock belongs to the session, so all the statements run on the same connection
+	conn, err := repository.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLock); err != nil {
+		log.Error("Error while acquiring the migration lock ", err)
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", postgresMigrationLock); err != nil {
+			log.Error("Error while releasing the migration lock ", err)
+		}
+	}()
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER     PRIMARY KEY,
+		name       TEXT        NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		log.Error("Error while creating the migrations table ", err)
+		return err
+	}
+	applied, err := appliedPostgresMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		if err = applyPostgresMigration(ctx, conn, migration); err != nil {
+			log.Error("Error while applying migration ", migration.Version, " ", migration.Name, " ", err)
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		log.Info("Applied migration ", migration.Version, " ", migration.Name)
+	}
+	return nil
+}
+
+// MigrationStatus returns the known migrations and the applied ones unknown to this version of the service, in version order
+func (repository *PostgresRepository) MigrationStatus(ctx context.Context) ([]model.MigrationStatus, error) {
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]model.MigrationStatus)
+	var exists bool
+	if err = repository.db.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		log.Error("Error while looking for the migrations table ", err)
+		return nil, err
+	}
+	// the table is created by the first migration run
+	if exists {
+		rows, err := repository.db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+		if err != nil {
+			log.Error("Error while reading the applied migrations ", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var status model.MigrationStatus
+			var appliedAt time.Time
+			if err = rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+				return nil, err
+			}
+			appliedAt = appliedAt.UTC()
+			status.AppliedAt = &appliedAt
+			applied[status.Version] = status
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var statuses []model.MigrationStatus
+	for _, migration := range migrations {
+		status := model.MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, record)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// appliedPostgresMigrations returns the versions recorded in schema_migrations
+func appliedPostgresMigrations(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// applyPostgresMigration runs the migration and records it in the same transaction
+func applyPostgresMigration(ctx context.Context, conn *sql.Conn, migration postgresMigration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err = tx.ExecContext(ctx, migration.SQL); err != nil {
+		return err
+	}
+	if step, ok := postgresMigrationSteps[migration.Version]; ok {
+		if err = step(ctx, tx); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexPostgresUserSearch sets the search words and prefixes of the existing users, in batches ordered by id
+func indexPostgresUserSearch(ctx context.Context, tx *sql.Tx) error {
+	last := ""
+	for {
+		rows, err := tx.QueryContext(ctx, `SELECT id, first_name, last_name, nickname, email FROM users
+			WHERE id COLLATE "C" > $1 ORDER BY id COLLATE "C" LIMIT 1000`, last)
+		if err != nil {
+			return err
+		}
+		var users []model.User
+		for rows.Next() {
+			var user model.User
+			if err = rows.Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Nickname, &user.Email); err != nil {
+				rows.Close()
+				return err
+			}
+			users = append(users, user)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+		for i := range users {
+			users[i].IndexSearch()
+			_, err = tx.ExecContext(ctx, "UPDATE users SET search_words = $2, search_prefixes = $3 WHERE id = $1",
+				users[i].ID, pq.Array(nonNilStrings(users[i].SearchWords)), pq.Array(nonNilStrings(users[i].SearchPrefixes)))
+			if err != nil {
+				return err
+			}
+		}
+		log.Info("Indexed the search of ", len(users), " users")
+		last = users[len(users)-1].ID
+	}
+}
@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoadPostgresMigrations(t *testing.T) {
+	migrations, err := loadPostgresMigrations()
+	if assert.NoError(t, err) && assert.NotEmpty(t, migrations) {
+		assert.Equal(t, 1, migrations[0].Version)
+		assert.Equal(t, "create_tables", migrations[0].Name)
+		for i := 1; i < len(migrations); i++ {
+			assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+		}
+	}
+}
+
+func TestPostgresMigrationStatus(t *testing.T) {
+	repo := newTestPostgresRepository(t).(*PostgresRepository)
+	migrations, err := loadPostgresMigrations()
+	assert.NoError(t, err)
+	statuses, err := repo.MigrationStatus(ctx)
+	if assert.NoError(t, err) && assert.Len(t, statuses, len(migrations)) {
+		for i, status := range statuses {
+			assert.Equal(t, migrations[i].Version, status.Version)
+			assert.False(t, status.Pending())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package repository
+
+// This file implements the events outbox with PostgreSQL, see outbox.go for the semantics shared with MongoDB
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"sort"
+	"time"
+	"user/service/model"
+)
+
+// outboxClaimLock is the key of the advisory lock serializing the claims, so that two relays never claim entries of the same user
+const outboxClaimLock = 7317002
+
+// outboxRetention is how long the published entries are kept to investigate deliveries, like the TTL index of MongoDB
+const outboxRetention = 7 * 24 * time.Hour
+
+// outboxColumns are the columns of the outbox table read into a model.OutboxEntry by scanOutboxEntries
+const outboxColumns = "id, user_id, message_type, payload, created_at, attempts, next_attempt_at, last_error, published_at, lease_owner, lease_until, parked_at"
+
+// EnqueueEvent stores the event in the outbox, it is published once the surrounding transaction is committed.
+// If a previous event of the user is waiting for a retry, the event waits too so that the events of a user stay ordered
+func (repository *PostgresRepository) EnqueueEvent(ctx context.Context, event model.Event) error {
+	payload, err := proto.Marshal(event)
+	if err != nil {
+		log.Error("Error while serializing the event ", err)
+		return err
+	}
+	createdAt := repository.now().UTC()
+	_, err = repository.executor(ctx).ExecContext(ctx, `INSERT INTO outbox (id, user_id, message_type, payload, created_at, next_attempt_at)
+		SELECT $1::TEXT, $2::TEXT, $3::TEXT, $4::BYTEA, $5::TIMESTAMPTZ, GREATEST($5::TIMESTAMPTZ, MAX(next_attempt_at))
+		FROM outbox WHERE user_id = $2::TEXT AND published_at IS NULL AND parked_at IS NULL`,
+		event.GetMetadata().GetEventId(), event.GetUserId(), string(event.ProtoReflect().Descriptor().FullName()), payload, createdAt)
+	if err != nil {
+		log.Error("Error while storing the event ", event.GetMetadata().GetEventId(), " in the outbox ", err)
+		return err
+	}
+	log.Debug("Stored event ", event.GetMetadata().GetEventId(), " in the outbox")
+	return nil
+}
+
+// ClaimPendingEvents leases to owner the oldest entries that can be published at now, in creation order.
+// The entries of a user are claimed only starting from the oldest pending one, so that a relay never publishes
+// an event while a previous event of the same user is claimed by another relay or waiting for a retry
+func (repository *PostgresRepository) ClaimPendingEvents(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.OutboxEntry, error) {
+	var entries []model.OutboxEntry
+	err := repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		executor := repository.executor(ctx)
+		if _, err := executor.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", outboxClaimLock); err != nil {
+			return err
+		}
+		if _, err := executor.ExecContext(ctx, "DELETE FROM outbox WHERE published_at < $1", now.Add(-outboxRetention)); err != nil {
+			return err
+		}
+		// an entry is claimed if no previous pending entry of the user is waiting for a retry or claimed
+		rows, err := executor.QueryContext(ctx, `UPDATE outbox SET lease_owner = $1, lease_until = $2 WHERE id IN (
+			SELECT candidate.id FROM outbox candidate
+			WHERE candidate.published_at IS NULL AND candidate.parked_at IS NULL AND candidate.next_attempt_at <= $3
+				AND (candidate.lease_until IS NULL OR candidate.lease_until <= $3)
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox previous
+					WHERE previous.user_id = candidate.user_id AND previous.published_at IS NULL AND previous.parked_at IS NULL
+						AND (previous.created_at, previous.id) < (candidate.created_at, candidate.id)
+						AND (previous.next_attempt_at > $3 OR previous.lease_until > $3))
+			ORDER BY candidate.created_at, candidate.id
+			LIMIT $4)
+		RETURNING `+outboxColumns, owner, now.Add(lease), now, limit)
+		if err != nil {
+			return err
+		}
+		entries, err = scanOutboxEntries(rows)
+		return err
+	})
+	if err != nil {
+		log.Error("Error while claiming the pending events ", err)
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// MarkEventPublished records the publication of the entry
+func (repository *PostgresRepository) MarkEventPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	if _, err := repository.executor(ctx).ExecContext(ctx, "UPDATE outbox SET published_at = $2 WHERE id = $1", id, publishedAt); err != nil {
+		log.Error("Error while marking event ", id, " as published ", err)
+		return err
+	}
+	return nil
+}
+
+// MarkEventFailed records a failed publication of the entry, that will be retried at nextAttemptAt.
+// The following entries of the user are released and wait until then too
+func (repository *PostgresRepository) MarkEventFailed(ctx context.Context, id string, userID string, nextAttemptAt time.Time, lastError string) error {
+	err := repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		executor := repository.executor(ctx)
+		_, err := executor.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3, lease_until = NULL
+			WHERE id = $1`, id, nextAttemptAt, lastError)
+		if err != nil {
+			return err
+		}
+		_, err = executor.ExecContext(ctx, `UPDATE outbox SET next_attempt_at = $2, lease_until = NULL
+			WHERE user_id = $1 AND published_at IS NULL AND parked_at IS NULL AND next_attempt_at < $2`, userID, nextAttemptAt)
+		return err
+	})
+	if err != nil {
+		log.Error("Error while marking event ", id, " as failed ", err)
+	}
+	return err
+}
+
+// ParkEvent records that the entry cannot be published, it is not retried anymore and does not block the following entries of the user
+func (repository *PostgresRepository) ParkEvent(ctx context.Context, id string, parkedAt time.Time, lastError string) error {
+	_, err := repository.executor(ctx).ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, parked_at = $2, last_error = $3, lease_until = NULL
+		WHERE id = $1`, id, parkedAt, lastError)
+	if err != nil {
+		log.Error("Error while parking event ", id, " ", err)
+	}
+	return err
+}
+
+// GetOutboxStats returns the number of pending and parked entries and the creation time of the oldest pending one
+func (repository *PostgresRepository) GetOutboxStats(ctx context.Context) (*model.OutboxStats, error) {
+	var stats model.OutboxStats
+	var oldestCreatedAt sql.NullTime
+	err := repository.executor(ctx).QueryRowContext(ctx, `SELECT
+			COUNT(*) FILTER (WHERE parked_at IS NULL),
+			COUNT(*) FILTER (WHERE parked_at IS NOT NULL),
+			MIN(created_at) FILTER (WHERE parked_at IS NULL)
+		FROM outbox WHERE published_at IS NULL`).Scan(&stats.Pending, &stats.Parked, &oldestCreatedAt)
+	if err != nil {
+		log.Error("Error while counting the pending events ", err)
+		return nil, err
+	}
+	if oldestCreatedAt.Valid {
+		stats.OldestCreatedAt = oldestCreatedAt.Time.UTC()
+	}
+	return &stats, nil
+}
+
+// ListStuckEvents returns the entries not published that failed at least minAttempts times, parked ones included, in creation order
+func (repository *PostgresRepository) ListStuckEvents(ctx context.Context, minAttempts int, limit int) ([]model.OutboxEntry, error) {
+	rows, err := repository.executor(ctx).QueryContext(ctx, "SELECT "+outboxColumns+` FROM outbox
+		WHERE published_at IS NULL AND attempts >= $1 ORDER BY created_at, id LIMIT $2`, minAttempts, limit)
+	if err != nil {
+		log.Error("Error while listing the stuck events ", err)
+		return nil, err
+	}
+	return scanOutboxEntries(rows)
+}
+
+// scanOutboxEntries reads the outboxColumns of the rows and closes them
+func scanOutboxEntries(rows *sql.Rows) ([]model.OutboxEntry, error) {
+	defer rows.Close()
+	var entries []model.OutboxEntry
+	for rows.Next() {
+		var entry model.OutboxEntry
+		var publishedAt, leaseUntil, parkedAt sql.NullTime
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.MessageType, &entry.Payload, &entry.CreatedAt, &entry.Attempts,
+			&entry.NextAttemptAt, &entry.LastError, &publishedAt, &entry.LeaseOwner, &leaseUntil, &parkedAt)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read outbox entry: %w", err)
+		}
+		entry.CreatedAt = entry.CreatedAt.UTC()
+		entry.NextAttemptAt = entry.NextAttemptAt.UTC()
+		entry.PublishedAt = nullTime(publishedAt)
+		entry.LeaseUntil = nullTime(leaseUntil)
+		entry.ParkedAt = nullTime(parkedAt)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// nullTime returns the UTC time, nil if it is NULL
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	utc := value.Time.UTC()
+	return &utc
+}
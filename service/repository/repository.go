@@ -10,104 +10,479 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"strings"
 	"time"
 	"user/service/api"
 	"user/service/model"
+	"user/service/utility"
 )
 
 const (
-	RFC3339 = "2006-01-02T15:04:05Z07:00"
+	// StorageMongo stores the data in MongoDB
+	StorageMongo = "mongo"
+	// StorageMemory stores the data in memory, it is lost on restart
+	StorageMemory = "memory"
+	// StoragePostgres stores the data in PostgreSQL
+	StoragePostgres = "postgres"
 )
 
-type UtilityInterface interface {
-	EncodeInput(input string) string
+func New(client *mongo.Client, hasher utility.PasswordHasher) *Repository {
+	// dummyPasswordHash is verified when no user matches the login, so that unknown accounts take the same time as wrong passwords
+	dummyPasswordHash, err := hasher.Hash(uuid.New().String())
+	if err != nil {
+		log.Error("Error while computing the dummy password hash ", err)
+	}
+	return &Repository{client: client, PasswordHasher: hasher, dummyPasswordHash: dummyPasswordHash, now: time.Now}
+}
+
+type Repository struct {
+	client            *mongo.Client
+	PasswordHasher    utility.PasswordHasher
+	dummyPasswordHash string
+	// now is the clock of the stored dates, replaced by the tests
+	now func() time.Time
 }
 
-func New(client *mongo.Client, utility UtilityInterface) *Repository {
-	return &Repository{client: client, UtilityInterface: utility}
+// Ping checks that the MongoDB primary is reachable, it is required by the transactions
+func (repository *Repository) Ping(ctx context.Context) error {
+	return repository.client.Ping(ctx, readpref.Primary())
 }
 
-type Repository struct {
-	client           *mongo.Client
-	UtilityInterface UtilityInterface
+// Close disconnects the MongoDB client, waiting the in-use connections until the context is done
+func (repository *Repository) Close(ctx context.Context) error {
+	return repository.client.Disconnect(ctx)
 }
 
 // CreateUser returns the created User
 func (repository *Repository) CreateUser(ctx context.Context, request *api.CreateUserRequest) (*model.User, error) {
 	log.Debug("Creating new user entity")
-	currentTime := time.Now()
-	userId := uuid.New().String()
-	log.Debug("New user id is", userId)
-	creationDate := currentTime.Format(RFC3339)
-	hashedPassword := repository.UtilityInterface.EncodeInput(request.Password)
-	user := model.User{
-		ID:        userId,
-		Firstname: request.Firstname,
-		Lastname:  request.Lastname,
-		Nickname:  request.Nickname,
-		Password:  hashedPassword,
-		Email:     request.Email,
-		Country:   request.Country.String(),
-		CreatedAt: creationDate,
-		UpdatedAt: creationDate,
+	user, err := newUser(repository.PasswordHasher, request, repository.now())
+	if err != nil {
+		return nil, err
 	}
 	usersCollection := repository.GetConnection()
-	_, error := usersCollection.InsertOne(context.TODO(), user)
+	_, error := usersCollection.InsertOne(ctx, user)
 	if error != nil {
 		log.Error("Error while creating the user", error)
-		return nil, error
+		return nil, translateWriteError(error)
 	}
-	log.Debug("Generated user with id ", userId)
-	return &user, nil
+	log.Debug("Generated user with id ", user.ID)
+	return user, nil
 }
 
-// GetUsersPaginated returns a list of users according to given search filters
-func (repository *Repository) GetUsersPaginated(ctx context.Context, request *api.GetUsersRequest) ([]model.User, error) {
+// GetUsersPaginated returns a page of the users selected by the query
+func (repository *Repository) GetUsersPaginated(ctx context.Context, query model.UserQuery) (*model.UserPage, error) {
 	log.Debug("Starting paginated retrieval of users")
 	usersCollection := repository.GetConnection()
-	var decodedUsers []model.User
-	var filters []string
-	filter := bson.D{}
-	// If specific language are passed, use them as filter
-	if request.FilterCountry != nil {
-		filters = append(filters, request.FilterCountry.String())
-		filter = bson.D{{
-			Key:   "country",
-			Value: bson.D{{Key: "$in", Value: filters}},
-		}}
-	}
-	// Paging options
-	pageOptions := options.Find()
-	pageOptions.SetSkip(request.Page)      //0-i
-	pageOptions.SetLimit(request.PageSize) // number of records to return
-	users, err := usersCollection.Find(context.TODO(), filter, pageOptions)
+	filter := mongoUserFilter(query.Filter)
+	if !query.IncludeDeleted {
+		filter = activeUser(filter)
+	}
+	page := &model.UserPage{}
+	if query.CountTotal {
+		count, err := usersCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			log.Error("Error while counting the users ", err)
+			return nil, err
+		}
+		page.TotalCount = count
+	}
+	if query.After != nil {
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, mongoAfterFilter(query.Order(), query.After)}}}
+	}
+	pageOptions := options.Find().
+		SetSort(mongoUserSort(query.Order())).
+		SetSkip(query.Offset).
+		SetLimit(query.Limit)
+	users, err := usersCollection.Find(ctx, filter, pageOptions)
+	if err != nil {
+		log.Error("Error while getting the users ", err)
+		return nil, err
+	}
 	defer func(users *mongo.Cursor, ctx context.Context) {
 		err := users.Close(ctx)
 		if err != nil {
 			log.Error("Error in close db stream func ", err)
 		}
 	}(users, ctx)
-	// var results []bson.D
-	if err = users.All(ctx, &decodedUsers); err != nil {
+	if err = users.All(ctx, &page.Users); err != nil {
 		log.Error("Error while unmarshalling users data ", err)
 		return nil, err
 	}
 	log.Debug("Retrieved paged users successfully")
-	return decodedUsers, nil
+	return page, nil
+}
+
+// SearchUsers returns a page of the users matching the search, ranked by relevance.
+// The text index finds the users with any of the terms, then only those with all of them are kept
+func (repository *Repository) SearchUsers(ctx context.Context, search model.UserSearch) ([]model.UserSearchResult, error) {
+	log.Debug("Starting search of users")
+	prefixes := make([]string, len(search.Terms))
+	for i, term := range search.Terms {
+		prefixes[i] = model.SearchPrefix(term)
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "$text", Value: bson.D{{Key: "$search", Value: strings.Join(prefixes, " ")}}},
+			{Key: "search_prefixes", Value: bson.D{{Key: "$all", Value: prefixes}}},
+			{Key: "deleted_at", Value: nil},
+		}}},
+		// a point for each term, one more for the terms that are whole words
+		{{Key: "$addFields", Value: bson.D{{Key: "search_score", Value: bson.D{{Key: "$add", Value: bson.A{
+			len(search.Terms),
+			bson.D{{Key: "$size", Value: bson.D{{Key: "$setIntersection", Value: bson.A{"$search_words", search.Terms}}}}},
+		}}}}}}},
+	}
+	if search.After != nil {
+		after := search.After
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "search_score", Value: bson.D{{Key: "$lt", Value: after.Score}}}},
+			bson.D{{Key: "search_score", Value: after.Score}, {Key: "created_at", Value: bson.D{{Key: "$gt", Value: after.User.CreatedAt}}}},
+			bson.D{{Key: "search_score", Value: after.Score}, {Key: "created_at", Value: after.User.CreatedAt}, {Key: "id", Value: bson.D{{Key: "$gt", Value: after.User.ID}}}},
+		}}}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "search_score", Value: -1}, {Key: "created_at", Value: 1}, {Key: "id", Value: 1}}}})
+	if search.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: search.Limit}})
+	}
+	cursor, err := repository.GetConnection().Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Error("Error while searching the users ", err)
+		return nil, err
+	}
+	var documents []struct {
+		User  model.User `bson:",inline"`
+		Score int64      `bson:"search_score"`
+	}
+	if err = cursor.All(ctx, &documents); err != nil {
+		log.Error("Error while unmarshalling users data ", err)
+		return nil, err
+	}
+	results := make([]model.UserSearchResult, len(documents))
+	for i, document := range documents {
+		results[i] = model.UserSearchResult{User: document.User, Score: document.Score}
+	}
+	log.Debug("Searched users successfully")
+	return results, nil
 }
 
-// UpdateUser returns the updated User
-func (repository *Repository) UpdateUser(ctx context.Context, request *api.UpdateUserRequest) (*model.User, error) {
+// UpdateUser returns the User before and after the update, model.ErrVersionConflict if the user is not at the expected
+// version or is changed meanwhile. The expected version is 0 to update any version
+func (repository *Repository) UpdateUser(ctx context.Context, request *api.UpdateUserRequest, version int64) (*model.User, *model.User, error) {
 	log.Debug("Starting update user for user ", request.Id)
-	usersCollection := repository.GetConnection()
-	var existingUser *model.User
-	filter := bson.D{{"id", request.Id}}
-	err := usersCollection.FindOne(context.TODO(), filter).Decode(&existingUser)
+	hashedPassword, err := hashUpdatedPassword(repository.PasswordHasher, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	existingUser, err := repository.findUser(ctx, bson.D{{Key: "id", Value: request.Id}})
+	if err != nil {
+		log.Error("Error while getting the use with id ", request.Id)
+		log.Error(err)
+		return nil, nil, err
+	}
+	if err = checkVersion(existingUser, version); err != nil {
+		return nil, nil, err
+	}
+	previousUser := *existingUser
+	applyUserUpdate(existingUser, request, hashedPassword, repository.now())
+	// only the updated fields are set, on the version that was read, so that concurrent changes are never overwritten
+	filter := bson.D{{Key: "id", Value: request.Id}, {Key: "version", Value: previousUser.Version}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "first_name", Value: existingUser.Firstname},
+		{Key: "last_name", Value: existingUser.Lastname},
+		{Key: "nickname", Value: existingUser.Nickname},
+		{Key: "password", Value: existingUser.Password},
+		{Key: "email", Value: existingUser.Email},
+		{Key: "country", Value: existingUser.Country},
+		{Key: "updated_at", Value: existingUser.UpdatedAt},
+		{Key: "version", Value: existingUser.Version},
+		{Key: "email_normalized", Value: existingUser.EmailNormalized},
+		{Key: "nickname_normalized", Value: existingUser.NicknameNormalized},
+		{Key: "search_words", Value: existingUser.SearchWords},
+		{Key: "search_prefixes", Value: existingUser.SearchPrefixes},
+	}}}
+	result, err := repository.GetConnection().UpdateOne(ctx, filter, update)
 	if err != nil {
 		log.Error("Error while getting the use with id ", request.Id)
 		log.Error(err)
+		return nil, nil, translateWriteError(err)
+	}
+	if result.MatchedCount == 0 {
+		log.Info("User ", request.Id, " was changed during the update")
+		return nil, nil, model.ErrVersionConflict
+	}
+	log.Debug("Updated users ", existingUser.ID)
+	return &previousUser, existingUser, nil
+}
+
+// DeleteUser marks the user deleted and returns it, model.ErrUserNotFound if it does not exist or is already deleted
+func (repository *Repository) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) (*model.User, error) {
+	log.Debug("Starting deletion func for user ", request.Id)
+	deletedAt := storedTime(repository.now())
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: deletedAt}}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	var deletedUser *model.User
+	err := repository.GetConnection().FindOneAndUpdate(ctx, activeUser(bson.D{{Key: "id", Value: request.Id}}), update).Decode(&deletedUser)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error("User ", request.Id, " is not present in the database")
+		return nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error("Cannot delete user ", request.Id)
+		log.Error(err)
+		return nil, err
+	}
+	// the user is returned as it was before the update
+	deletedUser.DeletedAt = &deletedAt
+	deletedUser.Version++
+	log.Debug("Correctly deleted user ", request.Id)
+	return deletedUser, nil
+}
+
+// RestoreUser undoes the deletion of the user and returns it, model.ErrUserNotDeleted if it is not deleted and
+// model.ErrRestorePeriodExpired if it was deleted before deletedAfter
+func (repository *Repository) RestoreUser(ctx context.Context, userID string, deletedAfter time.Time) (*model.User, error) {
+	log.Debug("Starting restore of user ", userID)
+	user, err := repository.findStoredUser(ctx, bson.D{{Key: "id", Value: userID}})
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRestorable(user, deletedAfter); err != nil {
+		return nil, err
+	}
+	filter := bson.D{{Key: "id", Value: userID}, {Key: "version", Value: user.Version}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "deleted_at", Value: ""}}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	result, err := repository.GetConnection().UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error("Cannot restore user ", userID, " ", err)
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		log.Info("User ", userID, " was changed during the restore")
+		return nil, model.ErrVersionConflict
+	}
+	user.DeletedAt = nil
+	user.Version++
+	log.Debug("Restored user ", userID)
+	return user, nil
+}
+
+// PurgeUser removes the user permanently, whether it is deleted or not, and returns it. model.ErrUserNotFound if it does not exist
+func (repository *Repository) PurgeUser(ctx context.Context, userID string) (*model.User, error) {
+	log.Debug("Starting purge of user ", userID)
+	var purgedUser *model.User
+	err := repository.GetConnection().FindOneAndDelete(ctx, bson.D{{Key: "id", Value: userID}}).Decode(&purgedUser)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error("User ", userID, " is not present in the database")
+		return nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error("Cannot purge user ", userID, " ", err)
+		return nil, err
+	}
+	log.Debug("Purged user ", userID)
+	return purgedUser, nil
+}
+
+// PurgeDeletedUsers removes permanently at most limit users deleted before deletedBefore, the oldest deletions first, and
+// returns them. It must run in a transaction, so that the users restored meanwhile are not returned
+func (repository *Repository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	usersCollection := repository.GetConnection()
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$lt", Value: deletedBefore}}}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := usersCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Error("Error while getting the users to purge ", err)
+		return nil, err
+	}
+	var users []model.User
+	if err = cursor.All(ctx, &users); err != nil {
+		log.Error("Error while unmarshalling users data ", err)
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	ids := make(bson.A, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+	filter = append(filter, bson.E{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}})
+	if _, err = usersCollection.DeleteMany(ctx, filter); err != nil {
+		log.Error("Error while purging the deleted users ", err)
+		return nil, err
+	}
+	log.Debug("Purged ", len(users), " deleted users")
+	return users, nil
+}
+
+// GetUser returns the user with the given id, model.ErrUserNotFound if it does not exist
+func (repository *Repository) GetUser(ctx context.Context, request *api.GetUserRequest) (*model.User, error) {
+	log.Debug("Starting get user ", request.Id)
+	return repository.findUser(ctx, bson.D{{Key: "id", Value: request.Id}})
+}
+
+// LookupUser returns the user with the given email or nickname, model.ErrUserNotFound if it does not exist
+func (repository *Repository) LookupUser(ctx context.Context, request *api.LookupUserRequest) (*model.User, error) {
+	log.Debug("Starting lookup user")
+	switch key := request.Key.(type) {
+	case *api.LookupUserRequest_Email:
+		return repository.findUser(ctx, bson.D{{Key: "email_normalized", Value: model.Normalize(key.Email)}})
+	case *api.LookupUserRequest_Nickname:
+		return repository.findUser(ctx, bson.D{{Key: "nickname_normalized", Value: model.Normalize(key.Nickname)}})
+	default:
+		return nil, model.ErrUserNotFound
+	}
+}
+
+// AuthenticateUser returns the User matching the given login if the password is correct, model.ErrInvalidCredentials otherwise
+func (repository *Repository) AuthenticateUser(ctx context.Context, request *api.AuthenticateRequest) (*model.User, error) {
+	log.Debug("Starting authentication of user")
+	var filter bson.D
+	switch login := request.Login.(type) {
+	case *api.AuthenticateRequest_Email:
+		filter = bson.D{{Key: "email_normalized", Value: model.Normalize(login.Email)}}
+	case *api.AuthenticateRequest_Nickname:
+		filter = bson.D{{Key: "nickname_normalized", Value: model.Normalize(login.Nickname)}}
+	default:
+		return nil, model.ErrInvalidCredentials
+	}
+	existingUser, err := repository.findUser(ctx, filter)
+	if errors.Is(err, model.ErrUserNotFound) {
+		// Verify anyway so that response time does not reveal whether the account exists
+		_, _, _ = repository.PasswordHasher.Verify(request.Password, repository.dummyPasswordHash)
+		log.Debug("No user found for the given login")
+		return nil, model.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	match, needsRehash, err := repository.PasswordHasher.Verify(request.Password, existingUser.Password)
+	if err != nil {
+		log.Error("Error while verifying the password of user ", existingUser.ID, " ", err)
+		return nil, model.ErrInvalidCredentials
+	}
+	if !match {
+		log.Debug("Wrong password for user ", existingUser.ID)
+		return nil, model.ErrInvalidCredentials
+	}
+	if needsRehash {
+		repository.rehashPassword(ctx, existingUser, request.Password)
+	}
+	log.Debug("Authenticated user ", existingUser.ID)
+	return existingUser, nil
+}
+
+// IsTaken reports whether a user already uses the value of the given unique field, model.FieldEmail or model.FieldNickname
+func (repository *Repository) IsTaken(ctx context.Context, field string, value string) (bool, error) {
+	log.Debug("Checking availability of ", field)
+	filter := bson.D{{Key: field + "_normalized", Value: model.Normalize(value)}}
+	count, err := repository.GetConnection().CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		log.Error("Error while checking availability of ", field, " ", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// findUser returns the single user not deleted matching the filter, model.ErrUserNotFound if there is none
+func (repository *Repository) findUser(ctx context.Context, filter bson.D) (*model.User, error) {
+	return repository.findStoredUser(ctx, activeUser(filter))
+}
+
+// findStoredUser returns the single user matching the filter, deleted or not, model.ErrUserNotFound if there is none
+func (repository *Repository) findStoredUser(ctx context.Context, filter bson.D) (*model.User, error) {
+	var user *model.User
+	err := repository.GetConnection().FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error("Error while getting the user ", err)
 		return nil, err
 	}
+	return user, nil
+}
+
+// rehashPassword replaces the stored hash of the user with one computed with the current hashing settings.
+// Failures are only logged, the old hash is still valid and the upgrade will be retried on next login
+func (repository *Repository) rehashPassword(ctx context.Context, user *model.User, password string) {
+	hashedPassword, err := repository.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Error("Error while rehashing the password of user ", user.ID, " ", err)
+		return
+	}
+	filter := bson.D{{Key: "id", Value: user.ID}, {Key: "password", Value: user.Password}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}}
+	if _, err = repository.GetConnection().UpdateOne(ctx, filter, update); err != nil {
+		log.Error("Error while storing the rehashed password of user ", user.ID, " ", err)
+		return
+	}
+	user.Password = hashedPassword
+	log.Debug("Upgraded password hash of user ", user.ID)
+}
+
+// GetConnection is used to establish the connection to the users collection in the service's database
+func (repository *Repository) GetConnection() *mongo.Collection {
+	return repository.client.Database("users_collection").Collection("users")
+}
+
+// activeUser returns the filter restricted to the users not deleted
+func activeUser(filter bson.D) bson.D {
+	return append(bson.D{{Key: "deleted_at", Value: nil}}, filter...)
+}
+
+// storedTime returns the date as stored by all the data layers, in UTC and with the millisecond precision of BSON dates
+func storedTime(date time.Time) time.Time {
+	return date.UTC().Truncate(time.Millisecond)
+}
+
+// newUser returns the user to store for the creation request created at now, with the hashed password
+func newUser(hasher utility.PasswordHasher, request *api.CreateUserRequest, now time.Time) (*model.User, error) {
+	userId := uuid.New().String()
+	log.Debug("New user id is", userId)
+	creationDate := storedTime(now)
+	hashedPassword, err := hasher.Hash(request.Password)
+	if err != nil {
+		log.Error("Error while hashing the password ", err)
+		return nil, err
+	}
+	user := &model.User{
+		ID:        userId,
+		Firstname: request.Firstname,
+		Lastname:  request.Lastname,
+		Nickname:  request.Nickname,
+		Password:  hashedPassword,
+		Email:     request.Email,
+		Country:   request.Country.String(),
+		CreatedAt: creationDate,
+		UpdatedAt: creationDate,
+		Roles:     []string{model.UserRole},
+		Version:   1,
+		// normalized values are checked by the unique indexes
+		EmailNormalized:    model.Normalize(request.Email),
+		NicknameNormalized: model.Normalize(request.Nickname),
+	}
+	user.IndexSearch()
+	return user, nil
+}
+
+// hashUpdatedPassword returns the hash of the password set by the update request, empty if the password is not updated.
+// It is computed before reading the user, so that the slow hashing does not run inside a transaction
+func hashUpdatedPassword(hasher utility.PasswordHasher, request *api.UpdateUserRequest) (string, error) {
+	if request.Password == nil {
+		return "", nil
+	}
+	hashedPassword, err := hasher.Hash(request.GetPassword())
+	if err != nil {
+		log.Error("Error while hashing the password ", err)
+		return "", err
+	}
+	return hashedPassword, nil
+}
+
+// applyUserUpdate sets the fields present in the update request on the user updated at now, hashedPassword is the hash of the new password if any
+func applyUserUpdate(existingUser *model.User, request *api.UpdateUserRequest, hashedPassword string, now time.Time) {
 	if request.Firstname != nil {
 		log.Debug("Firstname is update from ", existingUser.Firstname, " to ", request.Firstname)
 		existingUser.Firstname = request.GetFirstname()
@@ -119,13 +494,14 @@ func (repository *Repository) UpdateUser(ctx context.Context, request *api.Updat
 	if request.Nickname != nil {
 		log.Debug("Nickname is update from ", existingUser.Nickname, " to ", request.Nickname)
 		existingUser.Nickname = request.GetNickname()
+		existingUser.NicknameNormalized = model.Normalize(existingUser.Nickname)
 	}
 	if request.Email != nil {
 		log.Debug("Email is update from ", existingUser.Email, " to ", request.Email)
 		existingUser.Email = request.GetEmail()
+		existingUser.EmailNormalized = model.Normalize(existingUser.Email)
 	}
 	if request.Password != nil {
-		hashedPassword := repository.UtilityInterface.EncodeInput(request.GetPassword())
 		log.Debug("Password is updated")
 		existingUser.Password = hashedPassword
 	}
@@ -133,40 +509,86 @@ func (repository *Repository) UpdateUser(ctx context.Context, request *api.Updat
 		log.Debug("Country is update from ", existingUser.Country, " to ", request.Country)
 		existingUser.Country = request.GetCountry().String()
 	}
-	currentTime := time.Now()
-	updatedAtDate := currentTime.Format(RFC3339)
-	existingUser.UpdatedAt = updatedAtDate
-	updateFilter := bson.D{{"$set", existingUser}}
-	_, err = usersCollection.UpdateOne(ctx, bson.M{}, updateFilter)
+	existingUser.UpdatedAt = storedTime(now)
+	existingUser.Version++
+	existingUser.IndexSearch()
+}
+
+// checkRestorable returns model.ErrUserNotDeleted if the user is not deleted, model.ErrRestorePeriodExpired if it was
+// deleted before deletedAfter
+func checkRestorable(user *model.User, deletedAfter time.Time) error {
+	if user.DeletedAt == nil {
+		return model.ErrUserNotDeleted
+	}
+	if user.DeletedAt.Before(deletedAfter) {
+		log.Info("User ", user.ID, " was deleted at ", user.DeletedAt, ", it can no longer be restored")
+		return model.ErrRestorePeriodExpired
+	}
+	return nil
+}
+
+// checkVersion returns model.ErrVersionConflict if the user is not at the expected version, 0 expects any version
+func checkVersion(user *model.User, version int64) error {
+	if version != 0 && user.Version != version {
+		log.Info("User ", user.ID, " is at version ", user.Version, " instead of ", version)
+		return model.ErrVersionConflict
+	}
+	return nil
+}
+
+// newAdminUser returns the administrator created at now at startup when no user has the admin email
+func newAdminUser(hasher utility.PasswordHasher, email string, nickname string, password string, now time.Time) (*model.User, error) {
+	hashedPassword, err := hasher.Hash(password)
 	if err != nil {
-		log.Error("Error while getting the use with id ", request.Id)
-		log.Error(err)
 		return nil, err
 	}
-	log.Debug("Updated users ", existingUser.ID)
-	return existingUser, nil
+	creationDate := storedTime(now)
+	admin := &model.User{
+		ID:                 uuid.New().String(),
+		Nickname:           nickname,
+		Password:           hashedPassword,
+		Email:              email,
+		CreatedAt:          creationDate,
+		UpdatedAt:          creationDate,
+		Roles:              []string{model.UserRole, model.AdminRole},
+		Version:            1,
+		EmailNormalized:    model.Normalize(email),
+		NicknameNormalized: model.Normalize(nickname),
+	}
+	admin.IndexSearch()
+	return admin, nil
 }
 
-// DeleteUser delete a user, returns an error if operation fails
-func (repository *Repository) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) error {
-	log.Debug("Starting deletion func for user ", request.Id)
-	usersCollection := repository.GetConnection()
-	filter := bson.M{"id": request.Id}
-	deleteResult, err := usersCollection.DeleteOne(context.TODO(), filter)
-	if err != nil {
-		log.Error("Cannot delete user ", request.Id)
-		log.Error(err)
-		return err
+// addRole adds the role if it is not present yet
+func addRole(roles []string, role string) []string {
+	for _, current := range roles {
+		if current == role {
+			return roles
+		}
 	}
-	if deleteResult.DeletedCount == 0 {
-		log.Error("User ", request.Id, " is not present in the database")
-		return errors.New("user is not present in the database")
+	return append(roles, role)
+}
+
+// sameRoles reports whether a role change left the roles unchanged
+func sameRoles(previous []string, updated []string) bool {
+	if len(previous) != len(updated) {
+		return false
 	}
-	log.Debug("Correctly deleted user ", request.Id)
-	return nil
+	for i := range previous {
+		if previous[i] != updated[i] {
+			return false
+		}
+	}
+	return true
 }
 
-// GetConnection is used to establish the connection to the users collection in the service's database
-func (repository *Repository) GetConnection() *mongo.Collection {
-	return repository.client.Database("users_collection").Collection("users")
+// removeRole removes all the occurrences of the role
+func removeRole(roles []string, role string) []string {
+	remaining := []string{}
+	for _, current := range roles {
+		if current != role {
+			remaining = append(remaining, current)
+		}
+	}
+	return remaining
 }
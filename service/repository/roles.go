@@ -0,0 +1,170 @@
+package repository
+
+// This file implements the logic to store the roles and to assign them to the users
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"user/service/model"
+)
+
+// SeedRoles creates the given roles if they do not exist yet, existing roles are left untouched
+func (repository *Repository) SeedRoles(ctx context.Context, roles []model.Role) error {
+	rolesCollection := repository.GetRolesConnection()
+	for _, role := range roles {
+		filter := bson.D{{Key: "name", Value: role.Name}}
+		update := bson.D{{Key: "$setOnInsert", Value: role}}
+		_, err := rolesCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			log.Error("Error while seeding role ", role.Name, " ", err)
+			return err
+		}
+	}
+	log.Debug("Seeded default roles")
+	return nil
+}
+
+// ListRoles returns all the defined roles
+func (repository *Repository) ListRoles(ctx context.Context) ([]model.Role, error) {
+	var roles []model.Role
+	cursor, err := repository.GetRolesConnection().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		log.Error("Error while listing roles ", err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &roles); err != nil {
+		log.Error("Error while unmarshalling roles data ", err)
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetRole returns the role with the given name, model.ErrRoleNotFound if it does not exist
+func (repository *Repository) GetRole(ctx context.Context, name string) (*model.Role, error) {
+	var role *model.Role
+	err := repository.GetRolesConnection().FindOne(ctx, bson.D{{Key: "name", Value: name}}).Decode(&role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, model.ErrRoleNotFound
+	}
+	if err != nil {
+		log.Error("Error while getting role ", name, " ", err)
+		return nil, err
+	}
+	return role, nil
+}
+
+// PutRole creates the role or replaces its definition if it already exists
+func (repository *Repository) PutRole(ctx context.Context, role *model.Role) error {
+	log.Debug("Storing role ", role.Name)
+	filter := bson.D{{Key: "name", Value: role.Name}}
+	_, err := repository.GetRolesConnection().ReplaceOne(ctx, filter, role, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Error("Error while storing role ", role.Name, " ", err)
+	}
+	return err
+}
+
+// AssignRole adds the role to the user and returns the User before and after the change, assigning a role twice has no
+// effect and keeps the version
+func (repository *Repository) AssignRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	log.Debug("Assigning role ", role, " to user ", userID)
+	filter := bson.D{{Key: "roles", Value: bson.D{{Key: "$ne", Value: role}}}}
+	update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "roles", Value: role}}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	return repository.updateRoles(ctx, userID, filter, update, func(roles []string) []string { return addRole(roles, role) })
+}
+
+// RevokeRole removes the role from the user and returns the User before and after the change, revoking a role the user
+// does not have has no effect and keeps the version
+func (repository *Repository) RevokeRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error) {
+	log.Debug("Revoking role ", role, " from user ", userID)
+	filter := bson.D{{Key: "roles", Value: role}}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "roles", Value: role}}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	return repository.updateRoles(ctx, userID, filter, update, func(roles []string) []string { return removeRole(roles, role) })
+}
+
+// updateRoles applies the update to the stored user matching the filter and increments its version, apply computes the
+// same change on the roles of the returned user. If the filter does not match the roles are left unchanged
+func (repository *Repository) updateRoles(ctx context.Context, userID string, filter bson.D, update bson.D, apply func(roles []string) []string) (*model.User, *model.User, error) {
+	var previousUser *model.User
+	userFilter := append(bson.D{{Key: "id", Value: userID}}, filter...)
+	err := repository.GetConnection().FindOneAndUpdate(ctx, activeUser(userFilter), update).Decode(&previousUser)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return repository.unchangedRoles(ctx, userID)
+	}
+	if err != nil {
+		log.Error("Error while updating roles of user ", userID, " ", err)
+		return nil, nil, err
+	}
+	updatedUser := *previousUser
+	updatedUser.Roles = apply(append([]string(nil), previousUser.Roles...))
+	updatedUser.Version++
+	return previousUser, &updatedUser, nil
+}
+
+// unchangedRoles returns the user as both the User before and after a role change that has no effect, model.ErrUserNotFound
+// if it does not exist
+func (repository *Repository) unchangedRoles(ctx context.Context, userID string) (*model.User, *model.User, error) {
+	var user *model.User
+	err := repository.GetConnection().FindOne(ctx, activeUser(bson.D{{Key: "id", Value: userID}})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error("User ", userID, " is not present in the database")
+		return nil, nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error("Error while updating roles of user ", userID, " ", err)
+		return nil, nil, err
+	}
+	unchangedUser := *user
+	return user, &unchangedUser, nil
+}
+
+// GetUserRoles returns the roles of the user, model.ErrUserNotFound if it does not exist
+func (repository *Repository) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	var user *model.User
+	projection := options.FindOne().SetProjection(bson.D{{Key: "roles", Value: 1}})
+	err := repository.GetConnection().FindOne(ctx, activeUser(bson.D{{Key: "id", Value: userID}}), projection).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, model.ErrUserNotFound
+	}
+	if err != nil {
+		log.Error("Error while getting roles of user ", userID, " ", err)
+		return nil, err
+	}
+	return user.Roles, nil
+}
+
+// EnsureAdminUser creates the administrator with the given credentials if no user has the admin role yet, and returns it.
+// It is used to bootstrap the first administrator of the service: existing accounts are never promoted, since anybody can
+// sign up with the admin email, and nil is returned if an administrator already exists
+func (repository *Repository) EnsureAdminUser(ctx context.Context, email string, nickname string, password string) (*model.User, error) {
+	usersCollection := repository.GetConnection()
+	filter := bson.D{{Key: "roles", Value: model.AdminRole}}
+	count, err := usersCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		log.Error("Error while looking for an admin user ", err)
+		return nil, err
+	}
+	if count > 0 {
+		log.Info("An admin user already exists, skipping the admin bootstrap")
+		return nil, nil
+	}
+	admin, err := newAdminUser(repository.PasswordHasher, email, nickname, password, repository.now())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = usersCollection.InsertOne(ctx, admin); err != nil {
+		log.Error("Error while creating admin user ", err)
+		return nil, translateWriteError(err)
+	}
+	log.Info("Created admin user ", admin.ID)
+	return admin, nil
+}
+
+// GetRolesConnection is used to establish the connection to the roles collection in the service's database
+func (repository *Repository) GetRolesConnection() *mongo.Collection {
+	return repository.client.Database("users_collection").Collection("roles")
+}
@@ -0,0 +1,80 @@
+package repository
+
+// This file implements the logic to store the refresh tokens issued by the service
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"user/service/model"
+)
+
+// CreateRefreshToken stores a new refresh token
+func (repository *Repository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	log.Debug("Storing new refresh token for user ", token.UserID)
+	_, err := repository.GetTokensConnection().InsertOne(ctx, token)
+	if err != nil {
+		log.Error("Error while storing the refresh token ", err)
+	}
+	return err
+}
+
+// UseRefreshToken atomically marks the refresh token as replaced by a new one and returns it.
+// It returns model.ErrRefreshTokenReused if the token was already replaced or revoked, model.ErrRefreshTokenNotFound if it does not exist
+func (repository *Repository) UseRefreshToken(ctx context.Context, tokenHash string, replacedBy string) (*model.RefreshToken, error) {
+	tokensCollection := repository.GetTokensConnection()
+	filter := bson.D{
+		{Key: "hash", Value: tokenHash},
+		{Key: "replaced_by", Value: ""},
+		{Key: "revoked", Value: false},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "replaced_by", Value: replacedBy}}}}
+	var token *model.RefreshToken
+	err := tokensCollection.FindOneAndUpdate(ctx, filter, update).Decode(&token)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error("Error while using the refresh token ", err)
+		return nil, err
+	}
+	token, err = repository.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	log.Warn("Refresh token of family ", token.FamilyID, " has been reused")
+	return token, model.ErrRefreshTokenReused
+}
+
+// GetRefreshToken returns the refresh token with the given hash, model.ErrRefreshTokenNotFound if it does not exist
+func (repository *Repository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	var token *model.RefreshToken
+	err := repository.GetTokensConnection().FindOne(ctx, bson.D{{Key: "hash", Value: tokenHash}}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, model.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		log.Error("Error while getting the refresh token ", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+// RevokeRefreshTokenFamily revokes all the refresh tokens issued from the same login
+func (repository *Repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	log.Debug("Revoking refresh tokens of family ", familyID)
+	filter := bson.D{{Key: "family_id", Value: familyID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revoked", Value: true}}}}
+	_, err := repository.GetTokensConnection().UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error("Error while revoking refresh tokens of family ", familyID, " ", err)
+	}
+	return err
+}
+
+// GetTokensConnection is used to establish the connection to the refresh tokens collection in the service's database
+func (repository *Repository) GetTokensConnection() *mongo.Collection {
+	return repository.client.Database("users_collection").Collection("refresh_tokens")
+}
@@ -0,0 +1,253 @@
+package repository
+
+// This file translates the filters and the orders of model.UserQuery for each data layer
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"regexp"
+	"strings"
+	"user/service/model"
+)
+
+// userFieldColumns are the MongoDB fields and the PostgreSQL columns of the user fields, email and nickname are compared normalized
+var userFieldColumns = map[string]string{
+	model.UserFieldID:        "id",
+	model.UserFieldFirstname: "first_name",
+	model.UserFieldLastname:  "last_name",
+	model.UserFieldNickname:  "nickname_normalized",
+	model.UserFieldEmail:     "email_normalized",
+	model.UserFieldCountry:   "country",
+	model.UserFieldCreatedAt: "created_at",
+	model.UserFieldUpdatedAt: "updated_at",
+	model.UserFieldRoles:     "roles",
+}
+
+// mongoComparisons are the MongoDB operators of the comparisons
+var mongoComparisons = map[model.FilterOperator]string{
+	model.FilterEqual:          "$eq",
+	model.FilterNotEqual:       "$ne",
+	model.FilterLess:           "$lt",
+	model.FilterLessOrEqual:    "$lte",
+	model.FilterGreater:        "$gt",
+	model.FilterGreaterOrEqual: "$gte",
+}
+
+// selectsDeleted reports whether the comparison of the status selects the deleted users, otherwise it selects the others
+func selectsDeleted(filter *model.UserFilter) bool {
+	return (filter.Value == model.UserStatusDeleted) != (filter.Operator == model.FilterNotEqual)
+}
+
+// matchUser reports whether the user is selected by the filter, all users are selected by a nil filter
+func matchUser(user *model.User, filter *model.UserFilter) bool {
+	if filter == nil {
+		return true
+	}
+	switch filter.Operator {
+	case model.FilterAnd:
+		for i := range filter.Filters {
+			if !matchUser(user, &filter.Filters[i]) {
+				return false
+			}
+		}
+		return true
+	case model.FilterOr:
+		for i := range filter.Filters {
+			if matchUser(user, &filter.Filters[i]) {
+				return true
+			}
+		}
+		return false
+	case model.FilterNot:
+		return !matchUser(user, &filter.Filters[0])
+	case model.FilterHas:
+		roles, _ := user.FieldValue(filter.Field).([]string)
+		for _, role := range roles {
+			if role == filter.Value {
+				return true
+			}
+		}
+		return false
+	}
+	value := user.FieldValue(filter.Field)
+	if filter.Operator == model.FilterPrefix || filter.Operator == model.FilterSuffix {
+		text, _ := value.(string)
+		pattern, _ := filter.Value.(string)
+		if filter.Operator == model.FilterPrefix {
+			return strings.HasPrefix(text, pattern)
+		}
+		return strings.HasSuffix(text, pattern)
+	}
+	comparison := model.CompareValues(value, filter.Value)
+	switch filter.Operator {
+	case model.FilterEqual:
+		return comparison == 0
+	case model.FilterNotEqual:
+		return comparison != 0
+	case model.FilterLess:
+		return comparison < 0
+	case model.FilterLessOrEqual:
+		return comparison <= 0
+	case model.FilterGreater:
+		return comparison > 0
+	case model.FilterGreaterOrEqual:
+		return comparison >= 0
+	default:
+		return false
+	}
+}
+
+// mongoUserFilter returns the MongoDB filter of the users selected by the filter
+func mongoUserFilter(filter *model.UserFilter) bson.D {
+	if filter == nil {
+		return bson.D{}
+	}
+	if filter.Field == model.UserFieldStatus {
+		// the deleted users have a deletion date, it is unset when they are restored
+		if selectsDeleted(filter) {
+			return bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}}
+		}
+		return bson.D{{Key: "deleted_at", Value: nil}}
+	}
+	switch filter.Operator {
+	case model.FilterAnd, model.FilterOr, model.FilterNot:
+		operators := map[model.FilterOperator]string{model.FilterAnd: "$and", model.FilterOr: "$or", model.FilterNot: "$nor"}
+		filters := bson.A{}
+		for i := range filter.Filters {
+			filters = append(filters, mongoUserFilter(&filter.Filters[i]))
+		}
+		return bson.D{{Key: operators[filter.Operator], Value: filters}}
+	case model.FilterHas:
+		// a query on an array field matches the documents containing the value
+		return bson.D{{Key: userFieldColumns[filter.Field], Value: filter.Value}}
+	case model.FilterPrefix:
+		pattern := "^" + regexp.QuoteMeta(filter.Value.(string))
+		return bson.D{{Key: userFieldColumns[filter.Field], Value: bson.D{{Key: "$regex", Value: pattern}}}}
+	case model.FilterSuffix:
+		pattern := regexp.QuoteMeta(filter.Value.(string)) + "$"
+		return bson.D{{Key: userFieldColumns[filter.Field], Value: bson.D{{Key: "$regex", Value: pattern}}}}
+	default:
+		return bson.D{{Key: userFieldColumns[filter.Field], Value: bson.D{{Key: mongoComparisons[filter.Operator], Value: filter.Value}}}}
+	}
+}
+
+// mongoUserSort returns the MongoDB sort of the order
+func mongoUserSort(order []model.UserOrder) bson.D {
+	sort := bson.D{}
+	for _, field := range order {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: userFieldColumns[field.Field], Value: direction})
+	}
+	return sort
+}
+
+// mongoAfterFilter returns the MongoDB filter of the users sorted after the given one in the order
+func mongoAfterFilter(order []model.UserOrder, after *model.User) bson.D {
+	alternatives := bson.A{}
+	for i, field := range order {
+		alternative := bson.D{}
+		for _, previous := range order[:i] {
+			alternative = append(alternative, bson.E{Key: userFieldColumns[previous.Field], Value: after.FieldValue(previous.Field)})
+		}
+		operator := "$gt"
+		if field.Descending {
+			operator = "$lt"
+		}
+		alternative = append(alternative, bson.E{Key: userFieldColumns[field.Field], Value: bson.D{{Key: operator, Value: after.FieldValue(field.Field)}}})
+		alternatives = append(alternatives, alternative)
+	}
+	return bson.D{{Key: "$or", Value: alternatives}}
+}
+
+// postgresQuery collects the arguments of a PostgreSQL statement while its conditions are written
+type postgresQuery struct {
+	args []interface{}
+}
+
+// arg adds the argument and returns its placeholder
+func (query *postgresQuery) arg(value interface{}) string {
+	query.args = append(query.args, value)
+	return fmt.Sprintf("$%d", len(query.args))
+}
+
+// userCondition returns the PostgreSQL condition of the users selected by the filter, which must not be nil
+func (query *postgresQuery) userCondition(filter *model.UserFilter) string {
+	if filter.Field == model.UserFieldStatus {
+		if selectsDeleted(filter) {
+			return "deleted_at IS NOT NULL"
+		}
+		return "deleted_at IS NULL"
+	}
+	column := userFieldColumns[filter.Field]
+	switch filter.Operator {
+	case model.FilterAnd, model.FilterOr:
+		conditions := make([]string, len(filter.Filters))
+		for i := range filter.Filters {
+			conditions[i] = query.userCondition(&filter.Filters[i])
+		}
+		return "(" + strings.Join(conditions, " "+string(filter.Operator)+" ") + ")"
+	case model.FilterNot:
+		return "NOT " + query.userCondition(&filter.Filters[0])
+	case model.FilterHas:
+		// the containment operator uses the GIN index of the roles
+		return fmt.Sprintf("%s @> ARRAY[%s::TEXT]", column, query.arg(filter.Value))
+	case model.FilterPrefix:
+		return fmt.Sprintf("%s LIKE %s", column, query.arg(escapeLike(filter.Value.(string))+"%"))
+	case model.FilterSuffix:
+		return fmt.Sprintf("%s LIKE %s", column, query.arg("%"+escapeLike(filter.Value.(string))))
+	case model.FilterNotEqual:
+		return fmt.Sprintf("%s <> %s", column, query.arg(filter.Value))
+	default:
+		return fmt.Sprintf("%s %s %s", postgresSortColumn(filter.Field), filter.Operator, query.arg(filter.Value))
+	}
+}
+
+// afterCondition returns the PostgreSQL condition of the users sorted after the given one in the order
+func (query *postgresQuery) afterCondition(order []model.UserOrder, after *model.User) string {
+	alternatives := make([]string, len(order))
+	for i, field := range order {
+		var conditions []string
+		for _, previous := range order[:i] {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", postgresSortColumn(previous.Field), query.arg(after.FieldValue(previous.Field))))
+		}
+		operator := ">"
+		if field.Descending {
+			operator = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", postgresSortColumn(field.Field), operator, query.arg(after.FieldValue(field.Field))))
+		alternatives[i] = "(" + strings.Join(conditions, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// postgresOrderBy returns the PostgreSQL ORDER BY clause of the order
+func postgresOrderBy(order []model.UserOrder) string {
+	columns := make([]string, len(order))
+	for i, field := range order {
+		columns[i] = postgresSortColumn(field.Field)
+		if field.Descending {
+			columns[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(columns, ", ")
+}
+
+// postgresSortColumn returns the column compared by the sort and the range filters. The ids, emails and nicknames are
+// compared byte by byte like MongoDB does, whatever the collation of the database; the country codes are upper case
+// letters ordered alike by every collation, so that the index on the country is used
+func postgresSortColumn(field string) string {
+	switch field {
+	case model.UserFieldID, model.UserFieldEmail, model.UserFieldNickname:
+		return userFieldColumns[field] + ` COLLATE "C"`
+	default:
+		return userFieldColumns[field]
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
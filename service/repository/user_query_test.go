@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+	"user/service/model"
+)
+
+// statusFilters are the comparisons of the status with the deleted_at conditions they are compiled to
+var statusFilters = []struct {
+	filter   model.UserFilter
+	mongo    bson.D
+	postgres string
+}{
+	{model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldStatus, Value: model.UserStatusActive},
+		bson.D{{Key: "deleted_at", Value: nil}}, "deleted_at IS NULL"},
+	{model.UserFilter{Operator: model.FilterNotEqual, Field: model.UserFieldStatus, Value: model.UserStatusActive},
+		bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}}, "deleted_at IS NOT NULL"},
+	{model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldStatus, Value: model.UserStatusDeleted},
+		bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$ne", Value: nil}}}}, "deleted_at IS NOT NULL"},
+	{model.UserFilter{Operator: model.FilterNotEqual, Field: model.UserFieldStatus, Value: model.UserStatusDeleted},
+		bson.D{{Key: "deleted_at", Value: nil}}, "deleted_at IS NULL"},
+}
+
+func TestMongoUserFilterStatusOk(t *testing.T) {
+	for _, status := range statusFilters {
+		// run test and validate
+		assert.Equal(t, status.mongo, mongoUserFilter(&status.filter), status.filter)
+	}
+	// the status is combined like the other fields
+	filter := model.UserFilter{Operator: model.FilterNot, Filters: []model.UserFilter{statusFilters[0].filter}}
+	assert.Equal(t, bson.D{{Key: "$nor", Value: bson.A{statusFilters[0].mongo}}}, mongoUserFilter(&filter))
+}
+
+func TestPostgresUserConditionStatusOk(t *testing.T) {
+	for _, status := range statusFilters {
+		query := &postgresQuery{}
+		// run test and validate, the status needs no argument
+		assert.Equal(t, status.postgres, query.userCondition(&status.filter), status.filter)
+		assert.Empty(t, query.args)
+	}
+	query := &postgresQuery{}
+	filter := model.UserFilter{Operator: model.FilterAnd, Filters: []model.UserFilter{
+		statusFilters[2].filter,
+		{Operator: model.FilterEqual, Field: model.UserFieldCountry, Value: "IT"},
+	}}
+	assert.Equal(t, "(deleted_at IS NOT NULL AND country = $1)", query.userCondition(&filter))
+	assert.Equal(t, []interface{}{"IT"}, query.args)
+}
+
+func TestMatchUserStatusOk(t *testing.T) {
+	deletedAt := time.Now()
+	active, deleted := &model.User{}, &model.User{DeletedAt: &deletedAt}
+	for _, status := range statusFilters {
+		// run test and validate, the memory data layer selects the same users as the deleted_at conditions
+		deletedSelected := status.postgres == "deleted_at IS NOT NULL"
+		assert.Equal(t, !deletedSelected, matchUser(active, &status.filter), status.filter)
+		assert.Equal(t, deletedSelected, matchUser(deleted, &status.filter), status.filter)
+	}
+}
@@ -4,43 +4,92 @@ package service
 //go:generate mockery --all --output $PWD/mocks
 import (
 	"context"
+	"errors"
 	"github.com/golang/protobuf/ptypes/empty"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
 	"user/service/api"
 	"user/service/model"
 )
 
-// RepositoryInterface defines the operations exposed by the data layer regarding users
+// RepositoryInterface defines the operations exposed by the data layer regarding users. The deleted users are found
+// only by RestoreUser and the purge, the other operations return model.ErrUserNotFound
 type RepositoryInterface interface {
 	CreateUser(ctx context.Context, request *api.CreateUserRequest) (*model.User, error)
-	GetUsersPaginated(ctx context.Context, request *api.GetUsersRequest) ([]model.User, error)
-	UpdateUser(ctx context.Context, request *api.UpdateUserRequest) (*model.User, error)
-	DeleteUser(ctx context.Context, request *api.DeleteUserRequest) error
+	// GetUsersPaginated returns a page of the users selected by the query
+	GetUsersPaginated(ctx context.Context, query model.UserQuery) (*model.UserPage, error)
+	// SearchUsers returns a page of the users matching the search, ranked by relevance
+	SearchUsers(ctx context.Context, search model.UserSearch) ([]model.UserSearchResult, error)
+	// UpdateUser returns the user before and after the update, model.ErrVersionConflict if the user is not at the
+	// expected version or is changed meanwhile. The expected version is 0 to update any version
+	UpdateUser(ctx context.Context, request *api.UpdateUserRequest, version int64) (*model.User, *model.User, error)
+	// DeleteUser marks the user deleted and returns it
+	DeleteUser(ctx context.Context, request *api.DeleteUserRequest) (*model.User, error)
+	// RestoreUser undoes the deletion of the user, model.ErrRestorePeriodExpired if it was deleted before deletedAfter
+	RestoreUser(ctx context.Context, userID string, deletedAfter time.Time) (*model.User, error)
+	// PurgeUser removes the user permanently, whether it is deleted or not
+	PurgeUser(ctx context.Context, userID string) (*model.User, error)
+	// PurgeDeletedUsers removes permanently at most limit users deleted before deletedBefore and returns them
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error)
+	GetUser(ctx context.Context, request *api.GetUserRequest) (*model.User, error)
+	LookupUser(ctx context.Context, request *api.LookupUserRequest) (*model.User, error)
+	AuthenticateUser(ctx context.Context, request *api.AuthenticateRequest) (*model.User, error)
+	IsTaken(ctx context.Context, field string, value string) (bool, error)
+	AssignRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error)
+	RevokeRole(ctx context.Context, userID string, role string) (*model.User, *model.User, error)
+	EnsureAdminUser(ctx context.Context, email string, nickname string, password string) (*model.User, error)
+	// RunInTransaction commits the operations made with the context given to fn only if fn succeeds
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	// EnqueueEvent stores the event in the outbox, the producer relay publishes it after the commit
+	EnqueueEvent(ctx context.Context, event model.Event) error
 }
 
-type ProducerInterface interface {
-	PublishMessage(message string) error
-}
+// DefaultDeletionGracePeriod is how long the deleted users can be restored unless the Service is configured otherwise
+const DefaultDeletionGracePeriod = 30 * 24 * time.Hour
 
 // Service defines the Service composition
 type Service struct {
 	RepositoryInterface RepositoryInterface
-	ProducerInterface   ProducerInterface
+	TokenService        *TokenService
+	Authorizer          *Authorizer
+	// DeletionGracePeriod is how long the deleted users can be restored before they are purged
+	DeletionGracePeriod time.Duration
+	// HealthChecker reports the status of the dependencies, by default the service has none
+	HealthChecker *HealthChecker
+	// now is the clock of the grace period, replaced by the tests
+	now func() time.Time
 }
 
 // New allows to create a new instance of the Service
-func New(repository RepositoryInterface, producer ProducerInterface) *Service {
-	return &Service{repository, producer}
+func New(repository RepositoryInterface, tokenService *TokenService, authorizer *Authorizer) *Service {
+	// without dependencies to check the service is ready as soon as it is created
+	healthChecker, _ := NewHealthChecker(DefaultHealthConfig)
+	healthChecker.CheckDependencies(context.Background())
+	return &Service{repository, tokenService, authorizer, DefaultDeletionGracePeriod, healthChecker, time.Now}
 }
 
-// GetStatus implements the Service's status endpoint, useful for probes and monitoring
+// GetStatus implements the Service's status endpoint, useful for monitoring. It reports the status of the dependencies
+// as of their last periodic check, the probes use the grpc.health.v1 api. The dependencies are detailed only to the
+// administrators, the other callers get the overall status
 func (s *Service) GetStatus(ctx context.Context, e *empty.Empty) (*api.StatusReply, error) {
-	return &api.StatusReply{
-		Status:  api.ServiceStatus_UP,
-		Message: "Account service up and running",
-	}, nil
+	reply := s.HealthChecker.Status()
+	if identity, ok := IdentityFromContext(ctx); ok {
+		allowed, err := s.Authorizer.HasPermission(ctx, identity, model.PermissionUsersAdmin)
+		if err != nil {
+			log.Error("Cannot check the permissions of user ", identity.UserID, " ", err)
+		} else if allowed {
+			return reply, nil
+		}
+	}
+	if reply.Status != api.ServiceStatus_UP {
+		return &api.StatusReply{Status: reply.Status, Message: "Account service not ready"}, nil
+	}
+	return &api.StatusReply{Status: reply.Status, Message: reply.Message}, nil
 }
 
 // CreateUser allows creation of a new user with given parameters
@@ -51,97 +100,611 @@ func (s *Service) CreateUser(ctx context.Context, request *api.CreateUserRequest
 		log.Info("Received country is not valid ", request.Country)
 		return nil, status.Error(codes.InvalidArgument, "Received country is not valid")
 	}
-	user, err := s.RepositoryInterface.CreateUser(ctx, request)
+	var user *model.User
+	err := s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = s.RepositoryInterface.CreateUser(ctx, request)
+		if err != nil {
+			return err
+		}
+		// Store the event to notify other services, it is published to the topic after the commit
+		return s.RepositoryInterface.EnqueueEvent(ctx, newUserCreated(ctx, user))
+	})
+	var duplicate *model.DuplicateError
+	if errors.As(err, &duplicate) {
+		log.Info("Cannot create user, ", duplicate.Error())
+		return nil, alreadyExistsError(duplicate)
+	}
 	if err != nil {
 		log.Error("Failed to create user ", err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	// Send event to topic to notify other services
-	err = s.ProducerInterface.PublishMessage("Created user " + user.ID)
-	if err != nil {
-		log.Error("Failed to send create message user")
-	}
 	log.Info("User created with id ", user.ID)
 	return &api.CreateUserResponse{
-		User: &api.User{
-			Id:        user.ID,
-			Firstname: user.Firstname,
-			Lastname:  user.Lastname,
-			Nickname:  user.Nickname,
-			Email:     user.Email,
-			Country:   api.Country(api.Country_value[user.Country]),
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-		},
+		User: toGrpcUser(user),
 	}, nil
 }
 
-// GetUsers returns a paginated list of users considering given filters (if any, only country filed is supported for now) and page, pageSize parameters
+// GetUsers returns a page of the users selected by the filters, in the requested order.
+// Pages are selected either by number or by the token returned with the previous page
 func (s *Service) GetUsers(ctx context.Context, request *api.GetUsersRequest) (*api.GetUserResponse, error) {
-	log.Info("Starting get paginated users with parameters ", request.Page, request.PageSize, request.FilterCountry)
-	decodedUsers, err := s.RepositoryInterface.GetUsersPaginated(ctx, request)
+	log.Info("Starting get paginated users with parameters ", request.Page, request.PageSize, request.FilterCountry, request.Filter, request.OrderBy)
+	if request.Page < 0 || request.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Page and page size must not be negative")
+	}
+	filter, err := parseUserFilter(request.Filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid filter: "+err.Error())
+	}
+	orderBy, err := parseUserOrder(request.OrderBy)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid order: "+err.Error())
+	}
+	size := pageSize(request.PageSize)
+	// one more user is read to know whether there is a next page
+	query := model.UserQuery{
+		Filter:     withCountryFilter(filter, request.FilterCountry),
+		OrderBy:    orderBy,
+		Limit:      size + 1,
+		CountTotal: !request.SkipTotalCount,
+	}
+	if filterReferences(filter, model.UserFieldStatus) {
+		// the deleted users are listed only to the callers that can restore them
+		identity, ok := IdentityFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "Permission denied")
+		}
+		allowed, err := s.Authorizer.HasPermission(ctx, identity, model.PermissionUsersDelete)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !allowed {
+			log.Info("User ", identity.UserID, " is not allowed to filter the users by status")
+			return nil, status.Error(codes.PermissionDenied, "Permission denied")
+		}
+		query.IncludeDeleted = true
+	}
+	if request.PageToken != "" {
+		if request.Page != 0 {
+			return nil, status.Error(codes.InvalidArgument, "Page cannot be used with a page token")
+		}
+		after, err := decodePageToken(request)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Page token is not valid for this request")
+		}
+		query.After = after
+	} else {
+		query.Offset = request.Page * size
+	}
+	page, err := s.RepositoryInterface.GetUsersPaginated(ctx, query)
 	if err != nil {
 		log.Error("Failed to retrieve paginated users ", err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	var grpcUsers []*api.User
-	for _, decodedUser := range decodedUsers {
-		currentUser := &api.User{
-			Id:        decodedUser.ID,
-			Firstname: decodedUser.Firstname,
-			Lastname:  decodedUser.Lastname,
-			Nickname:  decodedUser.Nickname,
-			Email:     decodedUser.Email,
-			Country:   api.Country(api.Country_value[decodedUser.Country]),
-			CreatedAt: decodedUser.CreatedAt,
-			UpdatedAt: decodedUser.UpdatedAt,
-		}
-		grpcUsers = append(grpcUsers, currentUser)
+	response := &api.GetUserResponse{
+		Page:       request.Page,
+		PageSize:   size,
+		TotalCount: page.TotalCount,
+	}
+	users := page.Users
+	if int64(len(users)) > size {
+		users = users[:size]
+		response.NextPageToken = encodePageToken(request, query.Order(), &users[size-1])
+	}
+	for i := range users {
+		response.Results = append(response.Results, toGrpcUser(&users[i]))
 	}
 	log.Info("Completed get paginated users")
-	return &api.GetUserResponse{
-		Results:    grpcUsers,
-		Page:       request.Page,
-		PageSize:   request.PageSize,
-		TotalCount: int64(len(decodedUsers)),
-	}, nil
+	return response, nil
+}
+
+// SearchUsers returns a page of the users whose names, nickname or email match the words of the query, ranked by relevance
+func (s *Service) SearchUsers(ctx context.Context, request *api.SearchUsersRequest) (*api.SearchUsersResponse, error) {
+	log.Info("Starting search users with parameters ", request.PageSize)
+	if request.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Page size must not be negative")
+	}
+	if len(request.Query) > MaxSearchQueryLength {
+		return nil, status.Errorf(codes.InvalidArgument, "Search query must not be longer than %d characters", MaxSearchQueryLength)
+	}
+	terms := model.SearchWords(request.Query)
+	if len(terms) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Search query must contain a letter or a digit")
+	}
+	if len(terms) > MaxSearchTerms {
+		return nil, status.Errorf(codes.InvalidArgument, "Search query must not have more than %d words", MaxSearchTerms)
+	}
+	size := pageSize(request.PageSize)
+	// one more user is read to know whether there is a next page
+	search := model.UserSearch{Terms: terms, Limit: size + 1}
+	if request.PageToken != "" {
+		after, err := decodeSearchPageToken(request)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Page token is not valid for this request")
+		}
+		search.After = after
+	}
+	results, err := s.RepositoryInterface.SearchUsers(ctx, search)
+	if err != nil {
+		log.Error("Failed to search users ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response := &api.SearchUsersResponse{}
+	if int64(len(results)) > size {
+		results = results[:size]
+		response.NextPageToken = encodeSearchPageToken(request, &results[size-1])
+	}
+	for i := range results {
+		response.Results = append(response.Results, toGrpcUser(&results[i].User))
+	}
+	log.Info("Completed search users")
+	return response, nil
+}
+
+// GetUser returns the user with the given id
+func (s *Service) GetUser(ctx context.Context, request *api.GetUserRequest) (*api.User, error) {
+	log.Info("Starting get user ", request.Id)
+	user, err := s.RepositoryInterface.GetUser(ctx, request)
+	if err != nil {
+		return nil, userError(request.Id, err)
+	}
+	log.Info("Completed get user ", request.Id)
+	return toGrpcUser(user), nil
 }
 
-// UpdateUser returns and empty body if operation is successful, error otherwise
-func (s *Service) UpdateUser(ctx context.Context, request *api.UpdateUserRequest) (*empty.Empty, error) {
+// LookupUser returns the user with the given email or nickname
+func (s *Service) LookupUser(ctx context.Context, request *api.LookupUserRequest) (*api.User, error) {
+	log.Info("Starting lookup user")
+	if request.GetEmail() == "" && request.GetNickname() == "" {
+		log.Info("Received lookup key is empty")
+		return nil, status.Error(codes.InvalidArgument, "Email or nickname is required")
+	}
+	user, err := s.RepositoryInterface.LookupUser(ctx, request)
+	if errors.Is(err, model.ErrUserNotFound) {
+		log.Info("No user found for the given lookup key")
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Error("Failed to lookup user ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Info("Completed lookup user ", user.ID)
+	return toGrpcUser(user), nil
+}
+
+// UpdateUser updates the fields of the update mask, or the ones present in the request without mask, and returns the
+// updated user. The update is rejected with Aborted if the etag of the request is not the current one
+func (s *Service) UpdateUser(ctx context.Context, request *api.UpdateUserRequest) (*api.User, error) {
 	log.Info("Starting update func for user ", request.Id)
-	if request.Country.Number() == 0 {
+	request, err := applyUpdateMask(request)
+	if err != nil {
+		log.Info("Received update mask is not valid ", err)
+		return nil, err
+	}
+	if request.Country != nil && request.Country.Number() == 0 {
 		// Country value is not valid
 		log.Info("Received country is not valid ", request.Country)
 		return nil, status.Error(codes.InvalidArgument, "Received country is not valid")
 	}
-	existingUser, err := s.RepositoryInterface.UpdateUser(ctx, request)
+	var version int64
+	if request.Etag != "" {
+		if version, err = model.ParseEtag(request.Etag); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Etag is not valid")
+		}
+	}
+	var updatedUser *model.User
+	err = s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		previousUser, existingUser, err := s.RepositoryInterface.UpdateUser(ctx, request, version)
+		if err != nil {
+			return err
+		}
+		updatedUser = existingUser
+		// Store the event to notify other services, it is published to the topic after the commit
+		return s.RepositoryInterface.EnqueueEvent(ctx, newUserUpdated(ctx, previousUser, existingUser))
+	})
 	if err != nil {
 		log.Error("Failed to update user ", request.Id)
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userError(request.Id, err)
 	}
-	// Send event to topic to notify other services
-	err = s.ProducerInterface.PublishMessage("Updated user " + existingUser.ID)
-	if err != nil {
-		log.Error("Failed to send update message user")
+	log.Info("Updated user ", request.Id)
+	return toGrpcUser(updatedUser), nil
+}
+
+// applyUpdateMask returns the request with only the fields of its update mask, the names of the mask missing from the
+// request are cleared. The request is returned unchanged if it has no mask, an InvalidArgument status if the mask is not valid
+func applyUpdateMask(request *api.UpdateUserRequest) (*api.UpdateUserRequest, error) {
+	if len(request.GetUpdateMask().GetPaths()) == 0 {
+		return request, nil
 	}
-	log.Info("Updated user ", existingUser.ID)
-	return &empty.Empty{}, nil
+	masked := &api.UpdateUserRequest{Id: request.Id, Etag: request.Etag}
+	for _, path := range request.UpdateMask.Paths {
+		var missing bool
+		switch path {
+		case "firstname":
+			masked.Firstname = proto.String(request.GetFirstname())
+		case "lastname":
+			masked.Lastname = proto.String(request.GetLastname())
+		case "nickname":
+			masked.Nickname, missing = request.Nickname, request.Nickname == nil
+		case "email":
+			masked.Email, missing = request.Email, request.Email == nil
+		case "password":
+			masked.Password, missing = request.Password, request.Password == nil
+		case "country":
+			masked.Country, missing = request.Country, request.Country == nil
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Field %s of the update mask cannot be updated", path)
+		}
+		if missing {
+			return nil, status.Errorf(codes.InvalidArgument, "Field %s of the update mask is required", path)
+		}
+	}
+	return masked, nil
 }
 
-// DeleteUser returns and empty body if operation is successful, error otherwise
+// DeleteUser hides the user, that can be restored during the grace period and is purged after it. With force the user
+// is purged right away, which requires the users.admin permission. Returns an empty body if operation is successful
 func (s *Service) DeleteUser(ctx context.Context, request *api.DeleteUserRequest) (*empty.Empty, error) {
 	log.Info("Starting deleted func for user", request.Id)
-	err := s.RepositoryInterface.DeleteUser(ctx, request)
+	if request.Force {
+		return s.purgeUser(ctx, request.Id)
+	}
+	err := s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		deletedUser, err := s.RepositoryInterface.DeleteUser(ctx, request)
+		if err != nil {
+			return err
+		}
+		// Store the event to notify other services, it is published to the topic after the commit
+		return s.RepositoryInterface.EnqueueEvent(ctx, newUserDeleted(ctx, deletedUser, deletedUser.DeletedAt.Add(s.DeletionGracePeriod)))
+	})
 	if err != nil {
 		log.Error("Failed to delete user ", request.Id)
-		return nil, status.Error(codes.NotFound, "user "+request.Id+" not found")
+		return nil, userError(request.Id, err)
+	}
+	log.Info("Deleted user ", request.Id)
+	return &empty.Empty{}, nil
+}
+
+// purgeUser removes the user permanently if the caller has the users.admin permission. A user that was not deleted yet
+// gets a UserDeleted event before the UserPurged one, so that the consumers see the same events as for a scheduled purge
+func (s *Service) purgeUser(ctx context.Context, userID string) (*empty.Empty, error) {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "Permission denied")
 	}
-	// Send event to topic to notify other services
-	err = s.ProducerInterface.PublishMessage("Deleted user " + request.Id)
+	allowed, err := s.Authorizer.HasPermission(ctx, identity, model.PermissionUsersAdmin)
 	if err != nil {
-		log.Error("Failed to send create message user")
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-	log.Info("Deleted user ", request.Id)
+	if !allowed {
+		log.Info("User ", identity.UserID, " is not allowed to purge user ", userID)
+		return nil, status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	err = s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		purgedUser, err := s.RepositoryInterface.PurgeUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if purgedUser.DeletedAt == nil {
+			deletedAt := s.now().UTC()
+			purgedUser.DeletedAt = &deletedAt
+			if err = s.RepositoryInterface.EnqueueEvent(ctx, newUserDeleted(ctx, purgedUser, time.Time{})); err != nil {
+				return err
+			}
+		}
+		return s.RepositoryInterface.EnqueueEvent(ctx, newUserPurged(ctx, purgedUser))
+	})
+	if err != nil {
+		log.Error("Failed to purge user ", userID)
+		return nil, userError(userID, err)
+	}
+	log.Info("Purged user ", userID)
 	return &empty.Empty{}, nil
 }
+
+// RestoreUser undoes the deletion of a user during the grace period and returns it
+func (s *Service) RestoreUser(ctx context.Context, request *api.RestoreUserRequest) (*api.User, error) {
+	log.Info("Starting restore of user ", request.Id)
+	var restoredUser *model.User
+	err := s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		restoredUser, err = s.RepositoryInterface.RestoreUser(ctx, request.Id, s.now().Add(-s.DeletionGracePeriod))
+		if err != nil {
+			return err
+		}
+		return s.RepositoryInterface.EnqueueEvent(ctx, newUserRestored(ctx, restoredUser))
+	})
+	if err != nil {
+		log.Error("Failed to restore user ", request.Id)
+		return nil, userError(request.Id, err)
+	}
+	log.Info("Restored user ", request.Id)
+	return toGrpcUser(restoredUser), nil
+}
+
+// CheckAvailability reports whether the given email and nickname can still be used by a new user
+func (s *Service) CheckAvailability(ctx context.Context, request *api.CheckAvailabilityRequest) (*api.CheckAvailabilityResponse, error) {
+	log.Info("Starting check availability")
+	if request.Email == nil && request.Nickname == nil {
+		log.Info("Received availability request is empty")
+		return nil, status.Error(codes.InvalidArgument, "Email or nickname is required")
+	}
+	response := &api.CheckAvailabilityResponse{}
+	if request.Email != nil {
+		taken, err := s.RepositoryInterface.IsTaken(ctx, model.FieldEmail, request.GetEmail())
+		if err != nil {
+			log.Error("Failed to check email availability ", err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		available := !taken
+		response.EmailAvailable = &available
+	}
+	if request.Nickname != nil {
+		taken, err := s.RepositoryInterface.IsTaken(ctx, model.FieldNickname, request.GetNickname())
+		if err != nil {
+			log.Error("Failed to check nickname availability ", err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		available := !taken
+		response.NicknameAvailable = &available
+	}
+	log.Info("Completed check availability")
+	return response, nil
+}
+
+// Authenticate verifies the given credentials and returns the matching user.
+// Unknown logins and wrong passwords both return the same Unauthenticated error
+func (s *Service) Authenticate(ctx context.Context, request *api.AuthenticateRequest) (*api.AuthenticateResponse, error) {
+	log.Info("Starting authentication")
+	if request.GetEmail() == "" && request.GetNickname() == "" {
+		log.Info("Received login is empty")
+		return nil, status.Error(codes.InvalidArgument, "Email or nickname is required")
+	}
+	if request.Password == "" {
+		log.Info("Received password is empty")
+		return nil, status.Error(codes.InvalidArgument, "Password is required")
+	}
+	user, err := s.RepositoryInterface.AuthenticateUser(ctx, request)
+	if errors.Is(err, model.ErrInvalidCredentials) {
+		log.Info("Authentication failed")
+		return nil, status.Error(codes.Unauthenticated, "Invalid credentials")
+	}
+	if err != nil {
+		log.Error("Failed to authenticate user ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	tokens, err := s.TokenService.IssueTokens(ctx, user)
+	if err != nil {
+		log.Error("Failed to issue tokens for user ", user.ID, " ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Info("Authenticated user ", user.ID)
+	return &api.AuthenticateResponse{
+		User:   toGrpcUser(user),
+		Tokens: tokens,
+	}, nil
+}
+
+// RefreshToken exchanges a refresh token for a new pair of tokens, the given refresh token cannot be used again
+func (s *Service) RefreshToken(ctx context.Context, request *api.RefreshTokenRequest) (*api.RefreshTokenResponse, error) {
+	log.Info("Starting refresh token")
+	tokens, err := s.TokenService.RefreshTokens(ctx, request.RefreshToken)
+	if errors.Is(err, model.ErrRefreshTokenNotFound) || errors.Is(err, model.ErrRefreshTokenReused) {
+		log.Info("Refresh token is not valid ", err.Error())
+		return nil, status.Error(codes.Unauthenticated, "Invalid refresh token")
+	}
+	if err != nil {
+		log.Error("Failed to refresh token ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Info("Completed refresh token")
+	return &api.RefreshTokenResponse{
+		Tokens: tokens,
+	}, nil
+}
+
+// RevokeToken revokes a refresh token and all the tokens rotated from the same login, returns an empty body if operation is successful
+func (s *Service) RevokeToken(ctx context.Context, request *api.RevokeTokenRequest) (*empty.Empty, error) {
+	log.Info("Starting revoke token")
+	err := s.TokenService.RevokeTokens(ctx, request.RefreshToken)
+	if errors.Is(err, model.ErrRefreshTokenNotFound) {
+		log.Info("Refresh token to revoke not found")
+		return nil, status.Error(codes.Unauthenticated, "Invalid refresh token")
+	}
+	if err != nil {
+		log.Error("Failed to revoke token ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Info("Completed revoke token")
+	return &empty.Empty{}, nil
+}
+
+// GetJWKS returns the public keys that can be used to verify the access tokens offline
+func (s *Service) GetJWKS(ctx context.Context, e *empty.Empty) (*api.JWKSResponse, error) {
+	return &api.JWKSResponse{
+		Keys: s.TokenService.JWKS(),
+	}, nil
+}
+
+// AssignRole grants an existing role to the user, returns an empty body if operation is successful
+func (s *Service) AssignRole(ctx context.Context, request *api.AssignRoleRequest) (*empty.Empty, error) {
+	log.Info("Starting assign role ", request.Role, " to user ", request.UserId)
+	_, err := s.Authorizer.RoleRepositoryInterface.GetRole(ctx, request.Role)
+	if errors.Is(err, model.ErrRoleNotFound) {
+		log.Info("Role to assign does not exist ", request.Role)
+		return nil, status.Error(codes.NotFound, "role "+request.Role+" not found")
+	}
+	if err != nil {
+		log.Error("Failed to get role ", request.Role, " ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		before, after, err := s.RepositoryInterface.AssignRole(ctx, request.UserId, request.Role)
+		if err != nil {
+			return err
+		}
+		return s.enqueueRolesUpdated(ctx, before, after)
+	})
+	if err != nil {
+		return nil, userError(request.UserId, err)
+	}
+	log.Info("Assigned role ", request.Role, " to user ", request.UserId)
+	return &empty.Empty{}, nil
+}
+
+// RevokeRole removes the role from the user, returns an empty body if operation is successful
+func (s *Service) RevokeRole(ctx context.Context, request *api.RevokeRoleRequest) (*empty.Empty, error) {
+	log.Info("Starting revoke role ", request.Role, " from user ", request.UserId)
+	err := s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		before, after, err := s.RepositoryInterface.RevokeRole(ctx, request.UserId, request.Role)
+		if err != nil {
+			return err
+		}
+		return s.enqueueRolesUpdated(ctx, before, after)
+	})
+	if err != nil {
+		return nil, userError(request.UserId, err)
+	}
+	log.Info("Revoked role ", request.Role, " from user ", request.UserId)
+	return &empty.Empty{}, nil
+}
+
+// enqueueRolesUpdated stores the UserUpdated event of a role change, nothing is stored if the roles did not change
+func (s *Service) enqueueRolesUpdated(ctx context.Context, before *model.User, after *model.User) error {
+	event := newUserUpdated(ctx, before, after)
+	if len(event.ChangedFields) == 0 {
+		return nil
+	}
+	return s.RepositoryInterface.EnqueueEvent(ctx, event)
+}
+
+// BootstrapAdmin creates the first administrator with the given credentials if no user has the admin role yet
+func (s *Service) BootstrapAdmin(ctx context.Context, email string, nickname string, password string) error {
+	return s.RepositoryInterface.RunInTransaction(ctx, func(ctx context.Context) error {
+		admin, err := s.RepositoryInterface.EnsureAdminUser(ctx, email, nickname, password)
+		if err != nil || admin == nil {
+			return err
+		}
+		return s.RepositoryInterface.EnqueueEvent(ctx, newUserCreated(ctx, admin))
+	})
+}
+
+// PutRole creates a role or replaces the permissions it grants, changes are applied to the users having it
+func (s *Service) PutRole(ctx context.Context, request *api.PutRoleRequest) (*api.Role, error) {
+	if request.Role == nil || request.Role.Name == "" {
+		log.Info("Received role has no name")
+		return nil, status.Error(codes.InvalidArgument, "Role name is required")
+	}
+	log.Info("Starting put role ", request.Role.Name)
+	for _, permission := range request.Role.Permissions {
+		if !model.IsValidPermission(permission) {
+			log.Info("Received permission is not valid ", permission)
+			return nil, status.Error(codes.InvalidArgument, "Received permission "+permission+" is not valid")
+		}
+	}
+	role := &model.Role{
+		Name:        request.Role.Name,
+		Description: request.Role.Description,
+		Permissions: request.Role.Permissions,
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	if err := s.Authorizer.RoleRepositoryInterface.PutRole(ctx, role); err != nil {
+		log.Error("Failed to put role ", role.Name, " ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.Authorizer.Invalidate()
+	log.Info("Stored role ", role.Name)
+	return toGrpcRole(role), nil
+}
+
+// ListRoles returns all the defined roles
+func (s *Service) ListRoles(ctx context.Context, e *empty.Empty) (*api.ListRolesResponse, error) {
+	log.Info("Starting list roles")
+	roles, err := s.Authorizer.RoleRepositoryInterface.ListRoles(ctx)
+	if err != nil {
+		log.Error("Failed to list roles ", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var grpcRoles []*api.Role
+	for i := range roles {
+		grpcRoles = append(grpcRoles, toGrpcRole(&roles[i]))
+	}
+	return &api.ListRolesResponse{
+		Roles: grpcRoles,
+	}, nil
+}
+
+// userError maps the errors of the operations on a single user to gRPC errors
+func userError(userID string, err error) error {
+	if errors.Is(err, model.ErrUserNotFound) {
+		log.Info("User ", userID, " not found")
+		return status.Error(codes.NotFound, "user "+userID+" not found")
+	}
+	var duplicate *model.DuplicateError
+	if errors.As(err, &duplicate) {
+		log.Info("Conflict on user ", userID, ", ", duplicate.Error())
+		return alreadyExistsError(duplicate)
+	}
+	if errors.Is(err, model.ErrUserNotDeleted) {
+		log.Info("User ", userID, " to restore is not deleted")
+		return status.Error(codes.FailedPrecondition, "user "+userID+" is not deleted")
+	}
+	if errors.Is(err, model.ErrRestorePeriodExpired) {
+		log.Info("User ", userID, " can no longer be restored")
+		return status.Error(codes.FailedPrecondition, "user "+userID+" was deleted before the grace period and can no longer be restored")
+	}
+	if errors.Is(err, model.ErrVersionConflict) {
+		log.Info("User ", userID, " was modified concurrently")
+		return status.Error(codes.Aborted, "user "+userID+" was modified, read it again and retry")
+	}
+	log.Error("Failed operation on user ", userID, " ", err.Error())
+	return status.Error(codes.Internal, err.Error())
+}
+
+// alreadyExistsError returns an AlreadyExists status carrying the conflicting field as a BadRequest field violation
+func alreadyExistsError(duplicate *model.DuplicateError) error {
+	st := status.New(codes.AlreadyExists, duplicate.Error())
+	detailed, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       duplicate.Field,
+			Description: duplicate.Error(),
+		}},
+	})
+	if err != nil {
+		log.Error("Error while adding details to status ", err)
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func toGrpcRole(role *model.Role) *api.Role {
+	return &api.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+	}
+}
+
+// toGrpcUser maps the stored user to its gRPC representation, the password is never exposed
+func toGrpcUser(user *model.User) *api.User {
+	grpcUser := &api.User{
+		Id:         user.ID,
+		Firstname:  user.Firstname,
+		Lastname:   user.Lastname,
+		Nickname:   user.Nickname,
+		Email:      user.Email,
+		Country:    api.Country(api.Country_value[user.Country]),
+		CreatedAt:  user.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  user.UpdatedAt.Format(time.RFC3339),
+		Roles:      user.Roles,
+		Etag:       user.Etag(),
+		CreateTime: timestamppb.New(user.CreatedAt),
+		UpdateTime: timestamppb.New(user.UpdatedAt),
+	}
+	if user.DeletedAt != nil {
+		grpcUser.DeleteTime = timestamppb.New(*user.DeletedAt)
+	}
+	return grpcUser
+}
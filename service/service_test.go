@@ -2,12 +2,19 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"errors"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"strings"
 	"testing"
+	"time"
 	"user/service/api"
 	"user/service/mocks"
 	"user/service/model"
@@ -15,21 +22,41 @@ import (
 
 // TESTS
 var service *Service
-var ctx context.Context
+var ctx = context.Background()
 
 const notExError = "Not expected error: "
 
+// creationDate is the date of the users returned by the repository mocks
+var creationDate = time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// signingKey is generated once as RSA key generation is slow
+var signingKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
 type serviceMocks struct {
-	RepositoryInterface *mocks.RepositoryInterface
-	ProducerInterface   *mocks.ProducerInterface
+	RepositoryInterface      *mocks.RepositoryInterface
+	TokenRepositoryInterface *mocks.TokenRepositoryInterface
+	RoleRepositoryInterface  *mocks.RoleRepositoryInterface
 }
 
 func setupService() (*serviceMocks, *Service) {
 	serviceMocks := &serviceMocks{
-		RepositoryInterface: new(mocks.RepositoryInterface),
-		ProducerInterface:   new(mocks.ProducerInterface),
+		RepositoryInterface:      new(mocks.RepositoryInterface),
+		TokenRepositoryInterface: new(mocks.TokenRepositoryInterface),
+		RoleRepositoryInterface:  new(mocks.RoleRepositoryInterface),
 	}
-	service = New(serviceMocks.RepositoryInterface, serviceMocks.ProducerInterface)
+	tokenService := NewTokenService(serviceMocks.TokenRepositoryInterface, TokenConfig{
+		Issuer:          "user-service-test",
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: time.Hour,
+		SigningKey:      SigningKey{ID: "test-key", PrivateKey: signingKey},
+	})
+	authorizer := NewAuthorizer(serviceMocks.RoleRepositoryInterface, time.Minute)
+	service = New(serviceMocks.RepositoryInterface, tokenService, authorizer)
+	// transactions run the given function, the operations made inside are asserted on the repository mock
+	serviceMocks.RepositoryInterface.On("RunInTransaction", mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, fn func(context.Context) error) error {
+			return fn(ctx)
+		}).Maybe()
 	return serviceMocks, service
 }
 
@@ -61,13 +88,15 @@ func TestServiceCreateUserOk(t *testing.T) {
 		Nickname:  "nickname",
 		Email:     "test@email.com",
 		Country:   "EN",
-		CreatedAt: "creation_date",
-		UpdatedAt: "creation_date",
+		CreatedAt: creationDate,
+		UpdatedAt: creationDate,
 	}
-	kafkaMessage := "Created user " + createdUserMock.ID
 	mockServices, testingService := setupService()
 	mockServices.RepositoryInterface.On("CreateUser", ctx, request).Return(createdUserMock, nil)
-	mockServices.ProducerInterface.On("PublishMessage", kafkaMessage).Return(nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserCreated) bool {
+		return event.UserId == createdUserMock.ID && event.User.Email == createdUserMock.Email &&
+			event.Metadata.EventId != "" && event.Metadata.SchemaVersion == model.EventSchemaVersion
+	})).Return(nil)
 	// run test and validate
 	reply, err := testingService.CreateUser(ctx, request)
 	if err != nil {
@@ -122,7 +151,25 @@ func TestServiceCreateUserRepositoryErrorKo(t *testing.T) {
 	assertStatusError(t, err, "repository error", codes.Internal)
 }
 
-func TestServiceCreateUserProducerErrorKo(t *testing.T) {
+func TestServiceCreateUserDuplicateKo(t *testing.T) {
+	request := &api.CreateUserRequest{
+		Firstname: "firstname",
+		Lastname:  "lastname",
+		Nickname:  "nickname",
+		Email:     "test@email.com",
+		Password:  "my_test_password",
+		Country:   api.Country_EN,
+	}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("CreateUser", ctx, request).Return(nil, &model.DuplicateError{Field: model.FieldEmail})
+	// run test and validate
+	reply, err := testingService.CreateUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "a user with the same email already exists", codes.AlreadyExists)
+	assertFieldViolation(t, err, model.FieldEmail)
+}
+
+func TestServiceCreateUserOutboxErrorKo(t *testing.T) {
 	request := &api.CreateUserRequest{
 		Firstname: "firstname",
 		Lastname:  "lastname",
@@ -138,25 +185,17 @@ func TestServiceCreateUserProducerErrorKo(t *testing.T) {
 		Nickname:  "nickname",
 		Email:     "test@email.com",
 		Country:   "EN",
-		CreatedAt: "creation_date",
-		UpdatedAt: "creation_date",
+		CreatedAt: creationDate,
+		UpdatedAt: creationDate,
 	}
-	kafkaMessage := "Created user " + createdUserMock.ID
-	error := errors.New("producer error")
+	error := errors.New("outbox error")
 	mockServices, testingService := setupService()
 	mockServices.RepositoryInterface.On("CreateUser", ctx, request).Return(createdUserMock, nil)
-	mockServices.ProducerInterface.On("PublishMessage", kafkaMessage).Return(error)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.AnythingOfType("*api.UserCreated")).Return(error)
 	// run test and validate
 	reply, err := testingService.CreateUser(ctx, request)
-	if err != nil {
-		t.Error(notExError + err.Error())
-	}
-	assert.Equal(t, createdUserMock.ID, reply.User.Id)
-	assert.Equal(t, createdUserMock.Firstname, reply.User.Firstname)
-	assert.Equal(t, createdUserMock.Lastname, reply.User.Lastname)
-	assert.Equal(t, createdUserMock.Nickname, reply.User.Nickname)
-	assert.Equal(t, createdUserMock.Email, reply.User.Email)
-	assert.Equal(t, createdUserMock.Country, reply.User.Country.String())
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "outbox error", codes.Internal)
 }
 
 // GET ENDPOINT TESTS
@@ -169,7 +208,9 @@ func TestGetUsersOk(t *testing.T) {
 	}
 	decodedUsers := createDecodedUsers()
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, request).Return(decodedUsers, nil)
+	countryFilter := &model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldCountry, Value: "IT"}
+	query := model.UserQuery{Filter: countryFilter, Offset: 10, Limit: 11, CountTotal: true}
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, query).Return(&model.UserPage{Users: decodedUsers, TotalCount: 12}, nil)
 	// run test and validate
 	reply, err := testingService.GetUsers(ctx, request)
 	if err != nil {
@@ -178,6 +219,8 @@ func TestGetUsersOk(t *testing.T) {
 	assert.NotNil(t, reply)
 	assert.Equal(t, int64(1), reply.Page)
 	assert.Equal(t, int64(10), reply.PageSize)
+	assert.Equal(t, int64(12), reply.TotalCount)
+	assert.Empty(t, reply.NextPageToken, "the last page has no next page")
 	assert.Equal(t, 2, len(reply.Results))
 	// Analyze first user
 	assert.Equal(t, "1b8b24f8-a56b-4665-88f2-44e144389ce0", reply.Results[0].Id)
@@ -186,8 +229,10 @@ func TestGetUsersOk(t *testing.T) {
 	assert.Equal(t, "User 1 Nickname", reply.Results[0].Nickname)
 	assert.Equal(t, "user1@test.com", reply.Results[0].Email)
 	assert.Equal(t, "IT", reply.Results[0].Country.String())
-	assert.Equal(t, "now", reply.Results[0].CreatedAt)
-	assert.Equal(t, "now", reply.Results[0].UpdatedAt)
+	assert.Equal(t, "2023-01-02T15:04:05Z", reply.Results[0].CreatedAt)
+	assert.Equal(t, "2023-01-02T15:04:05Z", reply.Results[0].UpdatedAt)
+	assert.Equal(t, creationDate, reply.Results[0].CreateTime.AsTime())
+	assert.Equal(t, creationDate, reply.Results[0].UpdateTime.AsTime())
 	// Analyze second user
 	assert.Equal(t, "3bacc2e9-089a-4c27-b662-d3826b68173b", reply.Results[1].Id)
 	assert.Equal(t, "User 2", reply.Results[1].Firstname)
@@ -195,8 +240,208 @@ func TestGetUsersOk(t *testing.T) {
 	assert.Equal(t, "User 2 Nickname", reply.Results[1].Nickname)
 	assert.Equal(t, "user2@test.com", reply.Results[1].Email)
 	assert.Equal(t, "EN", reply.Results[1].Country.String())
-	assert.Equal(t, "now", reply.Results[1].CreatedAt)
-	assert.Equal(t, "now", reply.Results[1].UpdatedAt)
+	assert.Equal(t, "2023-01-02T15:04:05Z", reply.Results[1].CreatedAt)
+	assert.Equal(t, "2023-01-02T15:04:05Z", reply.Results[1].UpdatedAt)
+	assert.Equal(t, creationDate, reply.Results[1].CreateTime.AsTime())
+	assert.Equal(t, creationDate, reply.Results[1].UpdateTime.AsTime())
+}
+
+func TestGetUsersPageTokenOk(t *testing.T) {
+	decodedUsers := createDecodedUsers()
+	mockServices, testingService := setupService()
+	// page size is coerced and one more user is read to know if there is a next page
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, model.UserQuery{Limit: 2}).
+		Return(&model.UserPage{Users: decodedUsers}, nil)
+	reply, err := testingService.GetUsers(ctx, &api.GetUsersRequest{PageSize: 1, SkipTotalCount: true})
+	if err != nil {
+		t.Fatal(notExError + err.Error())
+	}
+	assert.Equal(t, int64(0), reply.TotalCount)
+	if assert.Len(t, reply.Results, 1) {
+		assert.Equal(t, decodedUsers[0].ID, reply.Results[0].Id)
+	}
+	assert.NotEmpty(t, reply.NextPageToken)
+	// the next page starts after the last user of the previous one
+	query := model.UserQuery{After: &model.User{ID: decodedUsers[0].ID, CreatedAt: decodedUsers[0].CreatedAt}, Limit: 2}
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, query).
+		Return(&model.UserPage{Users: decodedUsers[1:]}, nil)
+	reply, err = testingService.GetUsers(ctx, &api.GetUsersRequest{PageSize: 1, PageToken: reply.NextPageToken, SkipTotalCount: true})
+	if err != nil {
+		t.Fatal(notExError + err.Error())
+	}
+	if assert.Len(t, reply.Results, 1) {
+		assert.Equal(t, decodedUsers[1].ID, reply.Results[0].Id)
+	}
+	assert.Empty(t, reply.NextPageToken)
+}
+
+func TestGetUsersPageSizeOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, model.UserQuery{Limit: DefaultPageSize + 1, CountTotal: true}).
+		Return(&model.UserPage{}, nil)
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, model.UserQuery{Offset: 2 * MaxPageSize, Limit: MaxPageSize + 1, CountTotal: true}).
+		Return(&model.UserPage{}, nil)
+	reply, err := testingService.GetUsers(ctx, &api.GetUsersRequest{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(DefaultPageSize), reply.PageSize)
+	}
+	reply, err = testingService.GetUsers(ctx, &api.GetUsersRequest{Page: 2, PageSize: MaxPageSize + 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(MaxPageSize), reply.PageSize)
+	}
+}
+
+func TestGetUsersInvalidPaginationKo(t *testing.T) {
+	country := api.Country_IT
+	otherCountry := api.Country_EN
+	token := encodePageToken(&api.GetUsersRequest{FilterCountry: &country}, model.DefaultUserOrder, &createDecodedUsers()[0])
+	invalidRequests := []struct {
+		request *api.GetUsersRequest
+		message string
+	}{
+		{&api.GetUsersRequest{PageSize: -1}, "Page and page size must not be negative"},
+		{&api.GetUsersRequest{Page: 1, PageToken: token, FilterCountry: &country}, "Page cannot be used with a page token"},
+		{&api.GetUsersRequest{PageToken: token, FilterCountry: &otherCountry}, "Page token is not valid for this request"},
+		{&api.GetUsersRequest{PageToken: "not a token"}, "Page token is not valid for this request"},
+		{&api.GetUsersRequest{PageToken: token, FilterCountry: &country, OrderBy: "email"}, "Page token is not valid for this request"},
+	}
+	_, testingService := setupService()
+	for _, invalid := range invalidRequests {
+		reply, err := testingService.GetUsers(ctx, invalid.request)
+		assert.Nil(t, reply)
+		assertStatusError(t, err, invalid.message, codes.InvalidArgument)
+	}
+}
+
+func TestGetUsersFilterOk(t *testing.T) {
+	country := api.Country_IT
+	request := &api.GetUsersRequest{
+		FilterCountry:  &country,
+		Filter:         `email = "*@Test.com" OR roles:admin`,
+		OrderBy:        "email desc",
+		PageSize:       1,
+		SkipTotalCount: true,
+	}
+	decodedUsers := createDecodedUsers()
+	decodedUsers[0].EmailNormalized = "user1@test.com"
+	mockServices, testingService := setupService()
+	filter := &model.UserFilter{Operator: model.FilterAnd, Filters: []model.UserFilter{
+		{Operator: model.FilterOr, Filters: []model.UserFilter{
+			{Operator: model.FilterSuffix, Field: model.UserFieldEmail, Value: "@test.com"},
+			{Operator: model.FilterHas, Field: model.UserFieldRoles, Value: "admin"},
+		}},
+		{Operator: model.FilterEqual, Field: model.UserFieldCountry, Value: "IT"},
+	}}
+	order := []model.UserOrder{{Field: model.UserFieldEmail, Descending: true}}
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, model.UserQuery{Filter: filter, OrderBy: order, Limit: 2}).
+		Return(&model.UserPage{Users: decodedUsers}, nil)
+	reply, err := testingService.GetUsers(ctx, request)
+	if err != nil {
+		t.Fatal(notExError + err.Error())
+	}
+	assert.Len(t, reply.Results, 1)
+	// the next page starts after the email of the last user
+	after, err := decodePageToken(&api.GetUsersRequest{FilterCountry: &country, Filter: request.Filter, OrderBy: request.OrderBy, PageToken: reply.NextPageToken})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "user1@test.com", after.EmailNormalized)
+		assert.Equal(t, decodedUsers[0].ID, after.ID)
+	}
+}
+
+func TestGetUsersStatusFilterOk(t *testing.T) {
+	callCtx := ContextWithIdentity(ctx, &Identity{UserID: "3bacc2e9-089a-4c27-b662-d3826b68173b", Roles: []string{model.AdminRole}})
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", callCtx).Return(model.DefaultRoles, nil)
+	// the deleted users are selected too, so that the filter can find them
+	filter := &model.UserFilter{Operator: model.FilterEqual, Field: model.UserFieldStatus, Value: model.UserStatusDeleted}
+	mockServices.RepositoryInterface.On("GetUsersPaginated", callCtx, model.UserQuery{Filter: filter, Limit: DefaultPageSize + 1, CountTotal: true, IncludeDeleted: true}).
+		Return(&model.UserPage{Users: createDecodedUsers(), TotalCount: 2}, nil)
+	// run test and validate
+	reply, err := testingService.GetUsers(callCtx, &api.GetUsersRequest{Filter: "status = deleted"})
+	if err != nil {
+		t.Fatal(notExError + err.Error())
+	}
+	assert.Len(t, reply.Results, 2)
+	assert.Equal(t, int64(2), reply.TotalCount)
+}
+
+func TestGetUsersStatusFilterNotAllowedKo(t *testing.T) {
+	callCtx := ContextWithIdentity(ctx, &Identity{UserID: "3bacc2e9-089a-4c27-b662-d3826b68173b", Roles: []string{model.UserRole}})
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", callCtx).Return(model.DefaultRoles, nil)
+	// run test and validate, only the callers allowed to restore the users can list the deleted ones
+	for _, callCtx := range []context.Context{callCtx, ctx} {
+		reply, err := testingService.GetUsers(callCtx, &api.GetUsersRequest{Filter: "roles:admin OR status = active"})
+		assert.Nil(t, reply)
+		assertStatusError(t, err, "Permission denied", codes.PermissionDenied)
+	}
+	mockServices.RepositoryInterface.AssertNotCalled(t, "GetUsersPaginated", mock.Anything, mock.Anything)
+}
+
+func TestGetUsersInvalidFilterKo(t *testing.T) {
+	invalidRequests := []struct {
+		request *api.GetUsersRequest
+		message string
+	}{
+		{&api.GetUsersRequest{Filter: "password = secret"}, "Invalid filter: field password is not supported"},
+		{&api.GetUsersRequest{Filter: "country = XX"}, "Invalid filter: country XX is not valid"},
+		{&api.GetUsersRequest{OrderBy: "firstname"}, "Invalid order: cannot order by firstname"},
+	}
+	_, testingService := setupService()
+	for _, invalid := range invalidRequests {
+		reply, err := testingService.GetUsers(ctx, invalid.request)
+		assert.Nil(t, reply)
+		assertStatusError(t, err, invalid.message, codes.InvalidArgument)
+	}
+}
+
+func TestSearchUsersOk(t *testing.T) {
+	decodedUsers := createDecodedUsers()
+	results := []model.UserSearchResult{{User: decodedUsers[0], Score: 4}, {User: decodedUsers[1], Score: 3}}
+	mockServices, testingService := setupService()
+	// the words are searched lower case and without accents, one more result is read to know if there is a next page
+	search := model.UserSearch{Terms: []string{"lastname", "user"}, Limit: 2}
+	mockServices.RepositoryInterface.On("SearchUsers", ctx, search).Return(results, nil)
+	request := &api.SearchUsersRequest{Query: " Usér LASTNAME", PageSize: 1}
+	reply, err := testingService.SearchUsers(ctx, request)
+	if err != nil {
+		t.Fatal(notExError + err.Error())
+	}
+	if assert.Len(t, reply.Results, 1) {
+		assert.Equal(t, decodedUsers[0].ID, reply.Results[0].Id)
+	}
+	assert.NotEmpty(t, reply.NextPageToken)
+	// the next page starts after the last result of the previous one
+	search.After = &model.UserSearchResult{User: model.User{ID: decodedUsers[0].ID, CreatedAt: decodedUsers[0].CreatedAt}, Score: 4}
+	mockServices.RepositoryInterface.On("SearchUsers", ctx, search).Return(results[1:], nil)
+	reply, err = testingService.SearchUsers(ctx, &api.SearchUsersRequest{Query: request.Query, PageSize: 1, PageToken: reply.NextPageToken})
+	if err != nil {
+		t.Fatal(notExError + err.Error())
+	}
+	if assert.Len(t, reply.Results, 1) {
+		assert.Equal(t, decodedUsers[1].ID, reply.Results[0].Id)
+	}
+	assert.Empty(t, reply.NextPageToken)
+}
+
+func TestSearchUsersInvalidKo(t *testing.T) {
+	token := encodeSearchPageToken(&api.SearchUsersRequest{Query: "user"}, &model.UserSearchResult{User: createDecodedUsers()[0], Score: 1})
+	invalidRequests := []struct {
+		request *api.SearchUsersRequest
+		message string
+	}{
+		{&api.SearchUsersRequest{Query: "user", PageSize: -1}, "Page size must not be negative"},
+		{&api.SearchUsersRequest{Query: " - "}, "Search query must contain a letter or a digit"},
+		{&api.SearchUsersRequest{Query: "a b c d e f g h i j k"}, "Search query must not have more than 10 words"},
+		{&api.SearchUsersRequest{Query: strings.Repeat("a", MaxSearchQueryLength+1)}, "Search query must not be longer than 200 characters"},
+		{&api.SearchUsersRequest{Query: "other", PageToken: token}, "Page token is not valid for this request"},
+	}
+	_, testingService := setupService()
+	for _, invalid := range invalidRequests {
+		reply, err := testingService.SearchUsers(ctx, invalid.request)
+		assert.Nil(t, reply)
+		assertStatusError(t, err, invalid.message, codes.InvalidArgument)
+	}
 }
 
 func TestServiceGetUsersRepositoryErrorKo(t *testing.T) {
@@ -208,7 +453,7 @@ func TestServiceGetUsersRepositoryErrorKo(t *testing.T) {
 	}
 	error := errors.New("repository error")
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, request).Return(nil, error)
+	mockServices.RepositoryInterface.On("GetUsersPaginated", ctx, mock.Anything).Return(nil, error)
 	// run test and validate
 	reply, err := testingService.GetUsers(ctx, request)
 	assert.Nil(t, reply)
@@ -219,6 +464,116 @@ func TestServiceGetUsersRepositoryErrorKo(t *testing.T) {
 	assertStatusError(t, err, "repository error", codes.Internal)
 }
 
+// GET USER ENDPOINT TESTS
+func TestServiceGetUserOk(t *testing.T) {
+	request := &api.GetUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	existingUser := createDecodedUsers()[0]
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("GetUser", ctx, request).Return(&existingUser, nil)
+	// run test and validate
+	reply, err := testingService.GetUser(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, existingUser.ID, reply.Id)
+	assert.Equal(t, existingUser.Firstname, reply.Firstname)
+	assert.Equal(t, existingUser.Email, reply.Email)
+	assert.Equal(t, existingUser.Country, reply.Country.String())
+}
+
+func TestServiceGetUserNotFoundKo(t *testing.T) {
+	request := &api.GetUserRequest{Id: "AnIdThatDoesNotExists"}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("GetUser", ctx, request).Return(nil, model.ErrUserNotFound)
+	// run test and validate
+	reply, err := testingService.GetUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "user AnIdThatDoesNotExists not found", codes.NotFound)
+}
+
+func TestServiceGetUserRepositoryErrorKo(t *testing.T) {
+	request := &api.GetUserRequest{Id: "1b8b24f8-a56b-4665-88f2-44e144389ce0"}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("GetUser", ctx, request).Return(nil, errors.New("repository error"))
+	// run test and validate
+	reply, err := testingService.GetUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "repository error", codes.Internal)
+}
+
+// LOOKUP USER ENDPOINT TESTS
+func TestServiceLookupUserOk(t *testing.T) {
+	request := &api.LookupUserRequest{Key: &api.LookupUserRequest_Nickname{Nickname: "User 2 Nickname"}}
+	existingUser := createDecodedUsers()[1]
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("LookupUser", ctx, request).Return(&existingUser, nil)
+	// run test and validate
+	reply, err := testingService.LookupUser(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, existingUser.ID, reply.Id)
+	assert.Equal(t, existingUser.Nickname, reply.Nickname)
+}
+
+func TestServiceLookupUserNotFoundKo(t *testing.T) {
+	request := &api.LookupUserRequest{Key: &api.LookupUserRequest_Email{Email: "unknown@test.com"}}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("LookupUser", ctx, request).Return(nil, model.ErrUserNotFound)
+	// run test and validate
+	reply, err := testingService.LookupUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "user not found", codes.NotFound)
+}
+
+func TestServiceLookupUserEmptyKeyKo(t *testing.T) {
+	request := &api.LookupUserRequest{}
+	_, testingService := setupService()
+	// run test and validate
+	reply, err := testingService.LookupUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Email or nickname is required", codes.InvalidArgument)
+}
+
+func TestServiceCheckAvailabilityOk(t *testing.T) {
+	email := "test@email.com"
+	nickname := "nickname"
+	request := &api.CheckAvailabilityRequest{Email: &email, Nickname: &nickname}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("IsTaken", ctx, model.FieldEmail, email).Return(true, nil)
+	mockServices.RepositoryInterface.On("IsTaken", ctx, model.FieldNickname, nickname).Return(false, nil)
+	// run test and validate
+	reply, err := testingService.CheckAvailability(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.False(t, reply.GetEmailAvailable())
+	assert.True(t, reply.GetNicknameAvailable())
+}
+
+func TestServiceCheckAvailabilityOnlyRequestedFieldsOk(t *testing.T) {
+	nickname := "nickname"
+	request := &api.CheckAvailabilityRequest{Nickname: &nickname}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("IsTaken", ctx, model.FieldNickname, nickname).Return(false, nil)
+	// run test and validate
+	reply, err := testingService.CheckAvailability(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Nil(t, reply.EmailAvailable)
+	assert.True(t, reply.GetNicknameAvailable())
+	mockServices.RepositoryInterface.AssertNumberOfCalls(t, "IsTaken", 1)
+}
+
+func TestServiceCheckAvailabilityEmptyKo(t *testing.T) {
+	_, testingService := setupService()
+	// run test and validate
+	reply, err := testingService.CheckAvailability(ctx, &api.CheckAvailabilityRequest{})
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Email or nickname is required", codes.InvalidArgument)
+}
+
 // UPDATE ENDPOINT TESTS
 func TestUpdateUserOk(t *testing.T) {
 	existingUser := createDecodedUsers()[0]
@@ -235,17 +590,99 @@ func TestUpdateUserOk(t *testing.T) {
 		Email:     &email,
 		Country:   &country,
 	}
+	previousUser := existingUser
 	updatedUser(&existingUser, request)
-	kafkaMessage := "Updated user " + existingUser.ID
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("UpdateUser", ctx, request).Return(&existingUser, nil)
-	mockServices.ProducerInterface.On("PublishMessage", kafkaMessage).Return(nil)
+	mockServices.RepositoryInterface.On("UpdateUser", ctx, request, int64(0)).Return(&previousUser, &existingUser, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserUpdated) bool {
+		return event.UserId == existingUser.ID && event.Before.Firstname == previousUser.Firstname &&
+			event.After.Firstname == firstname && event.ChangedFields[0] == "firstname"
+	})).Return(nil)
 	// run test and validate
 	reply, err := testingService.UpdateUser(ctx, request)
 	if err != nil {
 		t.Error(notExError + err.Error())
 	}
-	assert.Equal(t, &empty.Empty{}, reply)
+	assert.Equal(t, toGrpcUser(&existingUser), reply)
+}
+
+func TestServiceUpdateUserMaskOk(t *testing.T) {
+	existingUser := createDecodedUsers()[0]
+	nickname := "nickname"
+	email := "test@email.com"
+	request := &api.UpdateUserRequest{
+		Id:         existingUser.ID,
+		Nickname:   &nickname,
+		Email:      &email,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname", "lastname"}},
+		Etag:       "3",
+	}
+	// only the fields of the mask are updated, the lastname missing from the request is cleared
+	lastname := ""
+	masked := &api.UpdateUserRequest{Id: existingUser.ID, Nickname: &nickname, Lastname: &lastname, Etag: "3"}
+	previousUser := existingUser
+	existingUser.Nickname = nickname
+	existingUser.Lastname = lastname
+	existingUser.Version = 4
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("UpdateUser", ctx, masked, int64(3)).Return(&previousUser, &existingUser, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.AnythingOfType("*api.UserUpdated")).Return(nil)
+	// run test and validate
+	reply, err := testingService.UpdateUser(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "4", reply.GetEtag())
+	assert.Equal(t, "", reply.GetLastname())
+}
+
+func TestServiceUpdateUserInvalidMaskKo(t *testing.T) {
+	nickname := "nickname"
+	requests := []struct {
+		paths   []string
+		message string
+	}{
+		{[]string{"email"}, "Field email of the update mask is required"},
+		{[]string{"nickname", "roles"}, "Field roles of the update mask cannot be updated"},
+	}
+	_, testingService := setupService()
+	for _, request := range requests {
+		reply, err := testingService.UpdateUser(ctx, &api.UpdateUserRequest{
+			Id:         "1b8b24f8-a56b-4665-88f2-44e144389ce0",
+			Nickname:   &nickname,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: request.paths},
+		})
+		assert.Nil(t, reply)
+		assertStatusError(t, err, request.message, codes.InvalidArgument)
+	}
+}
+
+func TestServiceUpdateUserInvalidEtagKo(t *testing.T) {
+	nickname := "nickname"
+	_, testingService := setupService()
+	// run test and validate
+	for _, etag := range []string{"abc", "0", "-1"} {
+		reply, err := testingService.UpdateUser(ctx, &api.UpdateUserRequest{
+			Id:       "1b8b24f8-a56b-4665-88f2-44e144389ce0",
+			Nickname: &nickname,
+			Etag:     etag,
+		})
+		assert.Nil(t, reply)
+		assertStatusError(t, err, "Etag is not valid", codes.InvalidArgument)
+	}
+}
+
+func TestServiceUpdateUserVersionConflictKo(t *testing.T) {
+	nickname := "nickname"
+	request := &api.UpdateUserRequest{
+		Id:       "1b8b24f8-a56b-4665-88f2-44e144389ce0",
+		Nickname: &nickname,
+		Etag:     "2",
+	}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("UpdateUser", ctx, request, int64(2)).Return(nil, nil, model.ErrVersionConflict)
+	// run test and validate
+	reply, err := testingService.UpdateUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "user 1b8b24f8-a56b-4665-88f2-44e144389ce0 was modified, read it again and retry", codes.Aborted)
 }
 
 func TestServiceUpdateUserInvalidCountryKo(t *testing.T) {
@@ -288,7 +725,7 @@ func TestServiceUpdateUserRepositoryErrorKo(t *testing.T) {
 	}
 	error := errors.New("repository error")
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("UpdateUser", ctx, request).Return(nil, error)
+	mockServices.RepositoryInterface.On("UpdateUser", ctx, request, int64(0)).Return(nil, nil, error)
 	// run test and validate
 	reply, err := testingService.UpdateUser(ctx, request)
 	assert.Nil(t, reply)
@@ -299,7 +736,22 @@ func TestServiceUpdateUserRepositoryErrorKo(t *testing.T) {
 	assertStatusError(t, err, "repository error", codes.Internal)
 }
 
-func TestServiceUpdateUserProducerErrorKo(t *testing.T) {
+func TestServiceUpdateUserDuplicateKo(t *testing.T) {
+	nickname := "nickname"
+	request := &api.UpdateUserRequest{
+		Id:       "1b8b24f8-a56b-4665-88f2-44e144389ce0",
+		Nickname: &nickname,
+	}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("UpdateUser", ctx, request, int64(0)).Return(nil, nil, &model.DuplicateError{Field: model.FieldNickname})
+	// run test and validate
+	reply, err := testingService.UpdateUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "a user with the same nickname already exists", codes.AlreadyExists)
+	assertFieldViolation(t, err, model.FieldNickname)
+}
+
+func TestServiceUpdateUserOutboxErrorKo(t *testing.T) {
 	existingUser := createDecodedUsers()[0]
 	firstname := "firstname"
 	lastname := "lastname"
@@ -314,18 +766,16 @@ func TestServiceUpdateUserProducerErrorKo(t *testing.T) {
 		Email:     &email,
 		Country:   &country,
 	}
-	error := errors.New("producer error")
+	error := errors.New("outbox error")
+	previousUser := existingUser
 	updatedUser(&existingUser, request)
-	kafkaMessage := "Updated user " + existingUser.ID
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("UpdateUser", ctx, request).Return(&existingUser, nil)
-	mockServices.ProducerInterface.On("PublishMessage", kafkaMessage).Return(error)
+	mockServices.RepositoryInterface.On("UpdateUser", ctx, request, int64(0)).Return(&previousUser, &existingUser, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.AnythingOfType("*api.UserUpdated")).Return(error)
 	// run test and validate
 	reply, err := testingService.UpdateUser(ctx, request)
-	if err != nil {
-		t.Error(notExError + err.Error())
-	}
-	assert.Equal(t, &empty.Empty{}, reply)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "outbox error", codes.Internal)
 }
 
 // DELETE ENDPOINT TESTS
@@ -333,10 +783,14 @@ func TestServiceDeleteUserOk(t *testing.T) {
 	request := &api.DeleteUserRequest{
 		Id: "0c10a807-1d58-426a-899d-9ccf8fe57a63",
 	}
-	kafkaMessage := "Deleted user " + request.Id
+	deletedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	deletedUser := &model.User{ID: request.Id, Email: "test@email.com", DeletedAt: &deletedAt}
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("DeleteUser", ctx, request).Return(nil)
-	mockServices.ProducerInterface.On("PublishMessage", kafkaMessage).Return(nil)
+	mockServices.RepositoryInterface.On("DeleteUser", ctx, request).Return(deletedUser, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserDeleted) bool {
+		return event.UserId == request.Id && event.User.Email == deletedUser.Email &&
+			event.PurgeTime.AsTime().Equal(deletedAt.Add(DefaultDeletionGracePeriod))
+	})).Return(nil)
 	// run test and validate
 	reply, err := testingService.DeleteUser(ctx, request)
 	if err != nil {
@@ -349,9 +803,8 @@ func TestServiceDeleteUserNotFoundKo(t *testing.T) {
 	request := &api.DeleteUserRequest{
 		Id: "AnIdThatDoesNotExists",
 	}
-	error := errors.New("user AnIdThatDoesNotExists not found")
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("DeleteUser", ctx, request).Return(error)
+	mockServices.RepositoryInterface.On("DeleteUser", ctx, request).Return(nil, model.ErrUserNotFound)
 	// run test and validate
 	reply, err := testingService.DeleteUser(ctx, request)
 	if err == nil {
@@ -361,21 +814,348 @@ func TestServiceDeleteUserNotFoundKo(t *testing.T) {
 	assertStatusError(t, err, "user AnIdThatDoesNotExists not found", codes.NotFound)
 }
 
-func TestServiceDeleteUserProducerErrorKo(t *testing.T) {
+func TestServiceDeleteUserOutboxErrorKo(t *testing.T) {
 	request := &api.DeleteUserRequest{
 		Id: "0c10a807-1d58-426a-899d-9ccf8fe57a63",
 	}
-	kafkaMessage := "Deleted user " + request.Id
-	error := errors.New("producer error")
+	error := errors.New("outbox error")
 	mockServices, testingService := setupService()
-	mockServices.RepositoryInterface.On("DeleteUser", ctx, request).Return(nil)
-	mockServices.ProducerInterface.On("PublishMessage", kafkaMessage).Return(error)
+	deletedAt := time.Now()
+	mockServices.RepositoryInterface.On("DeleteUser", ctx, request).Return(&model.User{ID: request.Id, DeletedAt: &deletedAt}, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.AnythingOfType("*api.UserDeleted")).Return(error)
 	// run test and validate
 	reply, err := testingService.DeleteUser(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "outbox error", codes.Internal)
+}
+
+func TestServiceDeleteUserForceOk(t *testing.T) {
+	request := &api.DeleteUserRequest{
+		Id:    "0c10a807-1d58-426a-899d-9ccf8fe57a63",
+		Force: true,
+	}
+	callCtx := ContextWithIdentity(ctx, &Identity{UserID: "3bacc2e9-089a-4c27-b662-d3826b68173b", Roles: []string{model.AdminRole}})
+	now := time.Date(2022, 3, 2, 12, 0, 0, 0, time.UTC)
+	mockServices, testingService := setupService()
+	testingService.now = func() time.Time { return now }
+	mockServices.RoleRepositoryInterface.On("ListRoles", callCtx).Return(model.DefaultRoles, nil)
+	mockServices.RepositoryInterface.On("PurgeUser", callCtx, request.Id).Return(&model.User{ID: request.Id}, nil)
+	// the user was not deleted yet, so it is deleted without grace period then purged
+	mockServices.RepositoryInterface.On("EnqueueEvent", callCtx, mock.MatchedBy(func(event *api.UserDeleted) bool {
+		return event.UserId == request.Id && event.PurgeTime == nil && event.User.DeleteTime.AsTime().Equal(now)
+	})).Return(nil).Once()
+	mockServices.RepositoryInterface.On("EnqueueEvent", callCtx, mock.MatchedBy(func(event *api.UserPurged) bool {
+		return event.UserId == request.Id
+	})).Return(nil).Once()
+	// run test and validate
+	reply, err := testingService.DeleteUser(callCtx, request)
 	if err != nil {
 		t.Error(notExError + err.Error())
 	}
 	assert.Equal(t, &empty.Empty{}, reply)
+	mockServices.RepositoryInterface.AssertExpectations(t)
+}
+
+func TestServiceDeleteUserForceDeletedOk(t *testing.T) {
+	request := &api.DeleteUserRequest{
+		Id:    "0c10a807-1d58-426a-899d-9ccf8fe57a63",
+		Force: true,
+	}
+	callCtx := ContextWithIdentity(ctx, &Identity{UserID: "3bacc2e9-089a-4c27-b662-d3826b68173b", Roles: []string{model.AdminRole}})
+	deletedAt := time.Now()
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", callCtx).Return(model.DefaultRoles, nil)
+	mockServices.RepositoryInterface.On("PurgeUser", callCtx, request.Id).Return(&model.User{ID: request.Id, DeletedAt: &deletedAt}, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", callCtx, mock.AnythingOfType("*api.UserPurged")).Return(nil)
+	// run test and validate
+	reply, err := testingService.DeleteUser(callCtx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+	mockServices.RepositoryInterface.AssertNotCalled(t, "EnqueueEvent", callCtx, mock.AnythingOfType("*api.UserDeleted"))
+}
+
+func TestServiceDeleteUserForceNotAdminKo(t *testing.T) {
+	request := &api.DeleteUserRequest{
+		Id:    "0c10a807-1d58-426a-899d-9ccf8fe57a63",
+		Force: true,
+	}
+	callCtx := ContextWithIdentity(ctx, &Identity{UserID: request.Id, Roles: []string{model.UserRole}})
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", callCtx).Return(model.DefaultRoles, nil)
+	// run test and validate
+	for _, callCtx := range []context.Context{callCtx, ctx} {
+		reply, err := testingService.DeleteUser(callCtx, request)
+		assert.Nil(t, reply)
+		assertStatusError(t, err, "Permission denied", codes.PermissionDenied)
+	}
+	mockServices.RepositoryInterface.AssertNotCalled(t, "PurgeUser", mock.Anything, mock.Anything)
+}
+
+// RESTORE ENDPOINT TESTS
+func TestServiceRestoreUserOk(t *testing.T) {
+	restoredUser := createDecodedUsers()[0]
+	request := &api.RestoreUserRequest{Id: restoredUser.ID}
+	now := time.Date(2022, 3, 2, 12, 0, 0, 0, time.UTC)
+	mockServices, testingService := setupService()
+	testingService.now = func() time.Time { return now }
+	// the users deleted during the grace period can be restored
+	mockServices.RepositoryInterface.On("RestoreUser", ctx, request.Id, now.Add(-DefaultDeletionGracePeriod)).Return(&restoredUser, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserRestored) bool {
+		return event.UserId == request.Id && event.User.Email == restoredUser.Email
+	})).Return(nil)
+	// run test and validate
+	reply, err := testingService.RestoreUser(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, toGrpcUser(&restoredUser), reply)
+}
+
+func TestServiceRestoreUserKo(t *testing.T) {
+	request := &api.RestoreUserRequest{Id: "0c10a807-1d58-426a-899d-9ccf8fe57a63"}
+	for _, restore := range []struct {
+		err     error
+		message string
+		code    codes.Code
+	}{
+		{model.ErrUserNotFound, "user 0c10a807-1d58-426a-899d-9ccf8fe57a63 not found", codes.NotFound},
+		{model.ErrUserNotDeleted, "user 0c10a807-1d58-426a-899d-9ccf8fe57a63 is not deleted", codes.FailedPrecondition},
+		{model.ErrRestorePeriodExpired, "user 0c10a807-1d58-426a-899d-9ccf8fe57a63 was deleted before the grace period and can no longer be restored", codes.FailedPrecondition},
+	} {
+		mockServices, testingService := setupService()
+		mockServices.RepositoryInterface.On("RestoreUser", ctx, request.Id, mock.AnythingOfType("time.Time")).Return(nil, restore.err)
+		// run test and validate
+		reply, err := testingService.RestoreUser(ctx, request)
+		assert.Nil(t, reply)
+		assertStatusError(t, err, restore.message, restore.code)
+	}
+}
+
+func TestServiceRestoreUserGracePeriodBoundaryOk(t *testing.T) {
+	now := time.Date(2022, 3, 2, 12, 0, 0, 0, time.UTC)
+	for _, restore := range []struct {
+		deletedAt time.Time
+		code      codes.Code
+	}{
+		{now.Add(-DefaultDeletionGracePeriod), codes.OK},
+		{now.Add(-DefaultDeletionGracePeriod - time.Nanosecond), codes.FailedPrecondition},
+	} {
+		deletedUser := createDecodedUsers()[0]
+		deletedUser.DeletedAt = &restore.deletedAt
+		mockServices, testingService := setupService()
+		testingService.now = func() time.Time { return now }
+		// the repository restores the users deleted at or after the cutoff
+		mockServices.RepositoryInterface.On("RestoreUser", ctx, deletedUser.ID, mock.AnythingOfType("time.Time")).Return(
+			func(ctx context.Context, userID string, deletedAfter time.Time) *model.User {
+				if deletedUser.DeletedAt.Before(deletedAfter) {
+					return nil
+				}
+				return &deletedUser
+			},
+			func(ctx context.Context, userID string, deletedAfter time.Time) error {
+				if deletedUser.DeletedAt.Before(deletedAfter) {
+					return model.ErrRestorePeriodExpired
+				}
+				return nil
+			})
+		mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.AnythingOfType("*api.UserRestored")).Return(nil)
+		// run test and validate
+		_, err := testingService.RestoreUser(ctx, &api.RestoreUserRequest{Id: deletedUser.ID})
+		assert.Equal(t, restore.code, status.Code(err), restore.deletedAt)
+	}
+}
+
+// AUTHENTICATE ENDPOINT TESTS
+func TestServiceAuthenticateOk(t *testing.T) {
+	request := &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: "user1@test.com"},
+		Password: "password",
+	}
+	existingUser := createDecodedUsers()[0]
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("AuthenticateUser", ctx, request).Return(&existingUser, nil)
+	mockServices.TokenRepositoryInterface.On("CreateRefreshToken", ctx, mock.AnythingOfType("*model.RefreshToken")).Return(nil)
+	// run test and validate
+	reply, err := testingService.Authenticate(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, existingUser.ID, reply.User.Id)
+	assert.Equal(t, existingUser.Email, reply.User.Email)
+	assert.Equal(t, existingUser.Nickname, reply.User.Nickname)
+	assert.Equal(t, "Bearer", reply.Tokens.TokenType)
+	assert.Equal(t, int64(900), reply.Tokens.ExpiresIn)
+	assert.NotEmpty(t, reply.Tokens.RefreshToken)
+	claims, err := testingService.TokenService.ValidateAccessToken(reply.Tokens.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, existingUser.ID, claims.Subject)
+}
+
+func TestServiceAuthenticateInvalidCredentialsKo(t *testing.T) {
+	request := &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Nickname{Nickname: "User 1 Nickname"},
+		Password: "wrong_password",
+	}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("AuthenticateUser", ctx, request).Return(nil, model.ErrInvalidCredentials)
+	// run test and validate
+	reply, err := testingService.Authenticate(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Invalid credentials", codes.Unauthenticated)
+}
+
+func TestServiceAuthenticateMissingLoginKo(t *testing.T) {
+	request := &api.AuthenticateRequest{
+		Password: "password",
+	}
+	_, testingService := setupService()
+	// run test and validate
+	reply, err := testingService.Authenticate(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Email or nickname is required", codes.InvalidArgument)
+}
+
+func TestServiceAuthenticateRepositoryErrorKo(t *testing.T) {
+	request := &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: "user1@test.com"},
+		Password: "password",
+	}
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("AuthenticateUser", ctx, request).Return(nil, errors.New("repository error"))
+	// run test and validate
+	reply, err := testingService.Authenticate(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "repository error", codes.Internal)
+}
+
+// ROLES ENDPOINTS TESTS
+func TestServiceAssignRoleOk(t *testing.T) {
+	request := &api.AssignRoleRequest{UserId: "1b8b24f8-a56b-4665-88f2-44e144389ce0", Role: "admin"}
+	mockServices, testingService := setupService()
+	before := &model.User{ID: request.UserId, Roles: []string{model.UserRole}}
+	after := &model.User{ID: request.UserId, Roles: []string{model.UserRole, model.AdminRole}}
+	mockServices.RoleRepositoryInterface.On("GetRole", ctx, "admin").Return(&model.DefaultRoles[0], nil)
+	mockServices.RepositoryInterface.On("AssignRole", ctx, request.UserId, "admin").Return(before, after, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserUpdated) bool {
+		return event.UserId == request.UserId && event.ChangedFields[0] == "roles" &&
+			assert.ObjectsAreEqual([]string{model.UserRole, model.AdminRole}, event.After.Roles)
+	})).Return(nil)
+	// run test and validate
+	reply, err := testingService.AssignRole(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+}
+
+func TestServiceAssignRoleUnknownRoleKo(t *testing.T) {
+	request := &api.AssignRoleRequest{UserId: "1b8b24f8-a56b-4665-88f2-44e144389ce0", Role: "unknown"}
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("GetRole", ctx, "unknown").Return(nil, model.ErrRoleNotFound)
+	// run test and validate
+	reply, err := testingService.AssignRole(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "role unknown not found", codes.NotFound)
+}
+
+func TestServiceAssignRoleUserNotFoundKo(t *testing.T) {
+	request := &api.AssignRoleRequest{UserId: "AnIdThatDoesNotExists", Role: "admin"}
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("GetRole", ctx, "admin").Return(&model.DefaultRoles[0], nil)
+	mockServices.RepositoryInterface.On("AssignRole", ctx, request.UserId, "admin").Return(nil, nil, model.ErrUserNotFound)
+	// run test and validate
+	reply, err := testingService.AssignRole(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "user AnIdThatDoesNotExists not found", codes.NotFound)
+}
+
+func TestServiceRevokeRoleOk(t *testing.T) {
+	request := &api.RevokeRoleRequest{UserId: "1b8b24f8-a56b-4665-88f2-44e144389ce0", Role: "admin"}
+	mockServices, testingService := setupService()
+	before := &model.User{ID: request.UserId, Roles: []string{model.UserRole, model.AdminRole}}
+	after := &model.User{ID: request.UserId, Roles: []string{model.UserRole}}
+	mockServices.RepositoryInterface.On("RevokeRole", ctx, request.UserId, "admin").Return(before, after, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserUpdated) bool {
+		return event.UserId == request.UserId && event.ChangedFields[0] == "roles"
+	})).Return(nil)
+	// run test and validate
+	reply, err := testingService.RevokeRole(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+}
+
+func TestServiceRevokeMissingRoleNoEventOk(t *testing.T) {
+	request := &api.RevokeRoleRequest{UserId: "1b8b24f8-a56b-4665-88f2-44e144389ce0", Role: "admin"}
+	mockServices, testingService := setupService()
+	user := &model.User{ID: request.UserId, Roles: []string{model.UserRole}}
+	mockServices.RepositoryInterface.On("RevokeRole", ctx, request.UserId, "admin").Return(user, user, nil)
+	// run test and validate
+	reply, err := testingService.RevokeRole(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+	mockServices.RepositoryInterface.AssertNotCalled(t, "EnqueueEvent", mock.Anything, mock.Anything)
+}
+
+func TestServiceBootstrapAdminOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	admin := &model.User{ID: "1b8b24f8-a56b-4665-88f2-44e144389ce0", Email: "admin@email.com", Roles: []string{model.UserRole, model.AdminRole}}
+	mockServices.RepositoryInterface.On("EnsureAdminUser", ctx, "admin@email.com", "admin", "password").Return(admin, nil)
+	mockServices.RepositoryInterface.On("EnqueueEvent", ctx, mock.MatchedBy(func(event *api.UserCreated) bool {
+		return event.UserId == admin.ID && event.User.Email == admin.Email
+	})).Return(nil)
+	// run test and validate
+	err := testingService.BootstrapAdmin(ctx, "admin@email.com", "admin", "password")
+	assert.Nil(t, err)
+}
+
+func TestServiceBootstrapAdminExistingOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RepositoryInterface.On("EnsureAdminUser", ctx, "admin@email.com", "admin", "password").Return(nil, nil)
+	// run test and validate
+	err := testingService.BootstrapAdmin(ctx, "admin@email.com", "admin", "password")
+	assert.Nil(t, err)
+	mockServices.RepositoryInterface.AssertNotCalled(t, "EnqueueEvent", mock.Anything, mock.Anything)
+}
+
+func TestServicePutRoleOk(t *testing.T) {
+	request := &api.PutRoleRequest{Role: &api.Role{Name: "support", Permissions: []string{"users.read"}}}
+	expectedRole := &model.Role{Name: "support", Permissions: []string{"users.read"}}
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("PutRole", ctx, expectedRole).Return(nil)
+	// run test and validate
+	reply, err := testingService.PutRole(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, "support", reply.Name)
+	assert.Equal(t, []string{"users.read"}, reply.Permissions)
+}
+
+func TestServicePutRoleInvalidPermissionKo(t *testing.T) {
+	request := &api.PutRoleRequest{Role: &api.Role{Name: "support", Permissions: []string{"users.everything"}}}
+	_, testingService := setupService()
+	// run test and validate
+	reply, err := testingService.PutRole(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Received permission users.everything is not valid", codes.InvalidArgument)
+}
+
+func TestServiceListRolesOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	mockServices.RoleRepositoryInterface.On("ListRoles", ctx).Return(model.DefaultRoles, nil)
+	// run test and validate
+	reply, err := testingService.ListRoles(ctx, &empty.Empty{})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, 2, len(reply.Roles))
+	assert.Equal(t, "admin", reply.Roles[0].Name)
 }
 
 // Utility
@@ -386,6 +1166,16 @@ func assertStatusError(t *testing.T, err error, expectedErrorMessage string, exp
 	assert.Equal(t, expectedErrorMessage, statusErr.Message())
 }
 
+func assertFieldViolation(t *testing.T, err error, expectedField string) {
+	details := status.Convert(err).Details()
+	if assert.Len(t, details, 1) {
+		badRequest, ok := details[0].(*errdetails.BadRequest)
+		if assert.True(t, ok) && assert.Len(t, badRequest.FieldViolations, 1) {
+			assert.Equal(t, expectedField, badRequest.FieldViolations[0].Field)
+		}
+	}
+}
+
 func createDecodedUsers() []model.User {
 	var decodedUsers []model.User
 	user1 := &model.User{
@@ -396,8 +1186,8 @@ func createDecodedUsers() []model.User {
 		Email:     "user1@test.com",
 		Password:  "password",
 		Country:   "IT",
-		CreatedAt: "now",
-		UpdatedAt: "now",
+		CreatedAt: creationDate,
+		UpdatedAt: creationDate,
 	}
 	user2 := &model.User{
 		ID:        "3bacc2e9-089a-4c27-b662-d3826b68173b",
@@ -407,8 +1197,8 @@ func createDecodedUsers() []model.User {
 		Email:     "user2@test.com",
 		Password:  "password",
 		Country:   "EN",
-		CreatedAt: "now",
-		UpdatedAt: "now",
+		CreatedAt: creationDate,
+		UpdatedAt: creationDate,
 	}
 	decodedUsers = append(decodedUsers, *user1)
 	decodedUsers = append(decodedUsers, *user2)
@@ -421,7 +1211,6 @@ func updatedUser(userToUpdate *model.User, request *api.UpdateUserRequest) *mode
 	userToUpdate.Nickname = request.GetNickname()
 	userToUpdate.Country = request.Country.String()
 	userToUpdate.Email = request.GetEmail()
-	userToUpdate.CreatedAt = "created now"
-	userToUpdate.UpdatedAt = "updated now"
+	userToUpdate.UpdatedAt = creationDate.Add(time.Hour)
 	return userToUpdate
 }
@@ -0,0 +1,28 @@
+package service
+
+// This file implements the stop of the gRPC server on shutdown, draining the in-flight requests for a limited time
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// StopServer stops the gRPC server gracefully, the in-flight requests are awaited until the context is done, then the
+// remaining connections are closed. It returns false if some requests were cancelled
+func StopServer(ctx context.Context, server *grpc.Server) bool {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-ctx.Done():
+		log.Warn("In-flight requests not completed in time, closing the connections")
+		server.Stop()
+		<-stopped
+		return false
+	}
+}
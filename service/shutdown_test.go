@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"testing"
+	"time"
+)
+
+// setupHealthServer serves a HealthChecker on a local gRPC server and returns a client connected to it
+func setupHealthServer(t *testing.T) (*grpc.Server, healthpb.HealthClient) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	checker, _ := NewHealthChecker(DefaultHealthConfig)
+	healthpb.RegisterHealthServer(server, checker)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+	return server, healthpb.NewHealthClient(conn)
+}
+
+// SHUTDOWN TESTS
+func TestStopServerOk(t *testing.T) {
+	server, client := setupHealthServer(t)
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: LivenessService})
+	assert.NoError(t, err)
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	// run test and validate
+	assert.True(t, StopServer(stopCtx, server))
+}
+
+func TestStopServerTimeoutKo(t *testing.T) {
+	server, client := setupHealthServer(t)
+	// a Watch stream is in flight until the client closes it
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: LivenessService})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	// run test and validate
+	assert.False(t, StopServer(stopCtx, server))
+	_, err = stream.Recv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package service
+
+// This file implements the wait for the dependencies at startup, that may start after the service, e.g. with docker compose
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// StartupConfig defines how often the dependencies are tried at startup, the overall deadline is set on the context
+type StartupConfig struct {
+	// AttemptTimeout bounds each connection attempt
+	AttemptTimeout time.Duration
+	// The delay before a retry doubles at every failed attempt, from MinBackoff up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// WaitFor calls connect until it succeeds, waiting the backoff between the attempts. It returns the last error once
+// the context is done
+func WaitFor(ctx context.Context, config StartupConfig, name string, connect func(ctx context.Context) error) error {
+	delay := config.MinBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, config.AttemptTimeout)
+		err := connect(attemptCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Info("Connected to ", name, " after ", attempt, " attempts")
+			}
+			return nil
+		}
+		log.Warn("Cannot connect to ", name, " (attempt ", attempt, "), retrying in ", delay, ": ", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not available after %d attempts: %w", name, attempt, err)
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > config.MaxBackoff {
+			delay = config.MaxBackoff
+		}
+	}
+}
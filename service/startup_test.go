@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var testStartupConfig = StartupConfig{
+	AttemptTimeout: 100 * time.Millisecond,
+	MinBackoff:     time.Millisecond,
+	MaxBackoff:     4 * time.Millisecond,
+}
+
+// STARTUP TESTS
+func TestWaitForOk(t *testing.T) {
+	attempts := 0
+	// run test and validate
+	err := WaitFor(ctx, testStartupConfig, "mongo", func(ctx context.Context) error {
+		attempts++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("the attempt has no deadline")
+		}
+		if attempts < 5 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, attempts)
+}
+
+func TestWaitForDeadlineKo(t *testing.T) {
+	startupCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	connectErr := errors.New("connection refused")
+	// run test and validate
+	start := time.Now()
+	err := WaitFor(startupCtx, testStartupConfig, "kafka", func(ctx context.Context) error {
+		return connectErr
+	})
+	assert.ErrorIs(t, err, connectErr)
+	assert.Contains(t, err.Error(), "kafka not available after")
+	assert.Less(t, time.Since(start), time.Second)
+}
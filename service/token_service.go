@@ -0,0 +1,257 @@
+package service
+
+// This file implements the issuance and rotation of the access and refresh tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"time"
+	"user/service/api"
+	"user/service/model"
+)
+
+const (
+	bearerTokenType    = "Bearer"
+	refreshTokenLength = 32
+)
+
+// ErrInvalidAccessToken is returned when an access token is malformed, expired or not signed by a known key
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// TokenRepositoryInterface defines the operations exposed by the data layer regarding refresh tokens
+type TokenRepositoryInterface interface {
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	UseRefreshToken(ctx context.Context, tokenHash string, replacedBy string) (*model.RefreshToken, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	GetUserRoles(ctx context.Context, userID string) ([]string, error)
+}
+
+// SigningKey is a RSA private key used to sign access tokens, identified by its key id
+type SigningKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+}
+
+// TokenConfig defines the settings of the issued tokens.
+// PreviousKeys are no longer used for signing but tokens signed with them are still valid until they expire
+type TokenConfig struct {
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	SigningKey      SigningKey
+	PreviousKeys    []SigningKey
+}
+
+// AccessClaims are the claims of the access tokens issued by the service
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// TokenService issues signed access tokens and rotates opaque refresh tokens
+type TokenService struct {
+	TokenRepositoryInterface TokenRepositoryInterface
+	config                   TokenConfig
+	publicKeys               map[string]*rsa.PublicKey
+	now                      func() time.Time
+}
+
+// NewTokenService allows to create a new instance of the TokenService
+func NewTokenService(repository TokenRepositoryInterface, config TokenConfig) *TokenService {
+	publicKeys := map[string]*rsa.PublicKey{config.SigningKey.ID: &config.SigningKey.PrivateKey.PublicKey}
+	for _, key := range config.PreviousKeys {
+		publicKeys[key.ID] = &key.PrivateKey.PublicKey
+	}
+	return &TokenService{
+		TokenRepositoryInterface: repository,
+		config:                   config,
+		publicKeys:               publicKeys,
+		now:                      time.Now,
+	}
+}
+
+// IssueTokens returns a new access token and a new refresh token, starting a new refresh token family
+func (tokenService *TokenService) IssueTokens(ctx context.Context, user *model.User) (*api.Tokens, error) {
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	return tokenService.storeTokens(ctx, user.ID, user.Roles, uuid.New().String(), refreshToken)
+}
+
+// RefreshTokens rotates the given refresh token returning a new pair of tokens.
+// Presenting an already rotated token revokes the whole family, as it means the token has been stolen
+func (tokenService *TokenService) RefreshTokens(ctx context.Context, refreshToken string) (*api.Tokens, error) {
+	newRefreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokenService.TokenRepositoryInterface.UseRefreshToken(ctx, hashRefreshToken(refreshToken), hashRefreshToken(newRefreshToken))
+	if errors.Is(err, model.ErrRefreshTokenReused) {
+		log.Warn("Refresh token reuse detected, revoking family ", token.FamilyID)
+		if revokeErr := tokenService.TokenRepositoryInterface.RevokeRefreshTokenFamily(ctx, token.FamilyID); revokeErr != nil {
+			return nil, revokeErr
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.ExpiresAt.After(tokenService.now()) {
+		log.Info("Refresh token of family ", token.FamilyID, " is expired")
+		return nil, model.ErrRefreshTokenNotFound
+	}
+	// Roles are read again so that the new access token reflects the latest assignments
+	roles, err := tokenService.TokenRepositoryInterface.GetUserRoles(ctx, token.UserID)
+	if errors.Is(err, model.ErrUserNotFound) {
+		log.Info("User ", token.UserID, " of refresh token family ", token.FamilyID, " no longer exists")
+		return nil, model.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokenService.storeTokens(ctx, token.UserID, roles, token.FamilyID, newRefreshToken)
+}
+
+// RevokeTokens revokes the given refresh token and all the ones rotated from the same login
+func (tokenService *TokenService) RevokeTokens(ctx context.Context, refreshToken string) error {
+	token, err := tokenService.TokenRepositoryInterface.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	return tokenService.TokenRepositoryInterface.RevokeRefreshTokenFamily(ctx, token.FamilyID)
+}
+
+// ValidateAccessToken verifies the signature and the validity of an access token and returns its claims
+func (tokenService *TokenService) ValidateAccessToken(accessToken string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		publicKey, ok := tokenService.publicKeys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", keyID)
+		}
+		return publicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+	)
+	if err != nil {
+		log.Debug("Access token is not valid ", err)
+		return nil, ErrInvalidAccessToken
+	}
+	if !claims.VerifyIssuer(tokenService.config.Issuer, true) {
+		log.Debug("Access token issuer is not valid ", claims.Issuer)
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys that can be used to verify the access tokens, both the current and the previous ones
+func (tokenService *TokenService) JWKS() []*api.JsonWebKey {
+	keys := []*api.JsonWebKey{toJsonWebKey(tokenService.config.SigningKey)}
+	for _, key := range tokenService.config.PreviousKeys {
+		keys = append(keys, toJsonWebKey(key))
+	}
+	return keys
+}
+
+// storeTokens stores the refresh token and signs a new access token for the user
+func (tokenService *TokenService) storeTokens(ctx context.Context, userID string, roles []string, familyID string, refreshToken string) (*api.Tokens, error) {
+	now := tokenService.now()
+	err := tokenService.TokenRepositoryInterface.CreateRefreshToken(ctx, &model.RefreshToken{
+		Hash:      hashRefreshToken(refreshToken),
+		UserID:    userID,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(tokenService.config.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := tokenService.signAccessToken(userID, roles, now)
+	if err != nil {
+		return nil, err
+	}
+	return &api.Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    bearerTokenType,
+		ExpiresIn:    int64(tokenService.config.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (tokenService *TokenService) signAccessToken(userID string, roles []string, now time.Time) (string, error) {
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    tokenService.config.Issuer,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokenService.config.AccessTokenTTL)),
+		},
+		Roles: roles,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = tokenService.config.SigningKey.ID
+	return token.SignedString(tokenService.config.SigningKey.PrivateKey)
+}
+
+// ParseSigningKey parses a PEM encoded RSA private key, both PKCS1 and PKCS8 formats are supported
+func ParseSigningKey(id string, pemData []byte) (SigningKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("signing key %q is not PEM encoded", id)
+	}
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return SigningKey{ID: id, PrivateKey: privateKey}, nil
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("signing key %q cannot be parsed: %w", id, err)
+	}
+	privateKey, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return SigningKey{}, fmt.Errorf("signing key %q is not a RSA key", id)
+	}
+	return SigningKey{ID: id, PrivateKey: privateKey}, nil
+}
+
+func generateRefreshToken() (string, error) {
+	token := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashRefreshToken returns the hash stored in place of the refresh token, a fast hash is enough as the token is random
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
+
+func toJsonWebKey(key SigningKey) *api.JsonWebKey {
+	publicKey := key.PrivateKey.PublicKey
+	return &api.JsonWebKey{
+		Kty: "RSA",
+		Kid: key.ID,
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"testing"
+	"time"
+	"user/service/api"
+	"user/service/mocks"
+	"user/service/model"
+)
+
+// TOKEN SERVICE TESTS
+func TestTokenServiceIssueTokensOk(t *testing.T) {
+	mockServices, testingService := setupService()
+	var storedToken *model.RefreshToken
+	mockServices.TokenRepositoryInterface.On("CreateRefreshToken", ctx, mock.AnythingOfType("*model.RefreshToken")).
+		Run(func(args mock.Arguments) { storedToken = args.Get(1).(*model.RefreshToken) }).Return(nil)
+	user := createDecodedUsers()[0]
+	// run test and validate
+	tokens, err := testingService.TokenService.IssueTokens(ctx, &user)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, user.ID, storedToken.UserID)
+	assert.NotEmpty(t, storedToken.FamilyID)
+	assert.Equal(t, hashRefreshToken(tokens.RefreshToken), storedToken.Hash)
+	assert.NotEqual(t, tokens.RefreshToken, storedToken.Hash)
+	claims, err := testingService.TokenService.ValidateAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, user.ID, claims.Subject)
+	assert.Equal(t, "user-service-test", claims.Issuer)
+	assert.Equal(t, user.Roles, claims.Roles)
+}
+
+func TestTokenServiceValidateAccessTokenKo(t *testing.T) {
+	_, testingService := setupService()
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherService := NewTokenService(new(mocks.TokenRepositoryInterface), TokenConfig{
+		Issuer:         "user-service-test",
+		AccessTokenTTL: time.Minute,
+		SigningKey:     SigningKey{ID: "test-key", PrivateKey: otherKey},
+	})
+	forgedToken, _ := otherService.signAccessToken("user-id", nil, time.Now())
+	expiredToken, _ := testingService.TokenService.signAccessToken("user-id", nil, time.Now().Add(-time.Hour))
+	// run test and validate
+	_, err := testingService.TokenService.ValidateAccessToken(forgedToken)
+	assert.Equal(t, ErrInvalidAccessToken, err)
+	_, err = testingService.TokenService.ValidateAccessToken(expiredToken)
+	assert.Equal(t, ErrInvalidAccessToken, err)
+	_, err = testingService.TokenService.ValidateAccessToken("not a token")
+	assert.Equal(t, ErrInvalidAccessToken, err)
+}
+
+func TestTokenServiceKeyRotationOk(t *testing.T) {
+	_, oldService := setupService()
+	oldToken, _ := oldService.TokenService.signAccessToken("user-id", []string{"user"}, time.Now())
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rotatedService := NewTokenService(new(mocks.TokenRepositoryInterface), TokenConfig{
+		Issuer:         "user-service-test",
+		AccessTokenTTL: time.Minute,
+		SigningKey:     SigningKey{ID: "new-key", PrivateKey: newKey},
+		PreviousKeys:   []SigningKey{{ID: "test-key", PrivateKey: signingKey}},
+	})
+	// run test and validate
+	claims, err := rotatedService.ValidateAccessToken(oldToken)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, "user-id", claims.Subject)
+	assert.Equal(t, []string{"user"}, claims.Roles)
+	keys := rotatedService.JWKS()
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, "new-key", keys[0].Kid)
+	assert.Equal(t, "test-key", keys[1].Kid)
+	assert.Equal(t, "RS256", keys[1].Alg)
+	assert.Equal(t, "AQAB", keys[1].E)
+}
+
+// REFRESH TOKEN ENDPOINT TESTS
+func TestServiceRefreshTokenOk(t *testing.T) {
+	request := &api.RefreshTokenRequest{RefreshToken: "refresh-token"}
+	existingToken := &model.RefreshToken{
+		Hash:      hashRefreshToken(request.RefreshToken),
+		UserID:    "1b8b24f8-a56b-4665-88f2-44e144389ce0",
+		FamilyID:  "family",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("UseRefreshToken", ctx, existingToken.Hash, mock.AnythingOfType("string")).Return(existingToken, nil)
+	mockServices.TokenRepositoryInterface.On("GetUserRoles", ctx, existingToken.UserID).Return([]string{"user", "admin"}, nil)
+	mockServices.TokenRepositoryInterface.On("CreateRefreshToken", ctx, mock.MatchedBy(func(token *model.RefreshToken) bool {
+		return token.FamilyID == "family" && token.UserID == existingToken.UserID
+	})).Return(nil)
+	// run test and validate
+	reply, err := testingService.RefreshToken(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.NotEqual(t, request.RefreshToken, reply.Tokens.RefreshToken)
+	claims, _ := testingService.TokenService.ValidateAccessToken(reply.Tokens.AccessToken)
+	assert.Equal(t, []string{"user", "admin"}, claims.Roles)
+	mockServices.TokenRepositoryInterface.AssertCalled(t, "UseRefreshToken", ctx, existingToken.Hash, hashRefreshToken(reply.Tokens.RefreshToken))
+}
+
+func TestServiceRefreshTokenReusedKo(t *testing.T) {
+	request := &api.RefreshTokenRequest{RefreshToken: "refresh-token"}
+	usedToken := &model.RefreshToken{
+		Hash:       hashRefreshToken(request.RefreshToken),
+		FamilyID:   "family",
+		ReplacedBy: "another-token-hash",
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("UseRefreshToken", ctx, usedToken.Hash, mock.AnythingOfType("string")).Return(usedToken, model.ErrRefreshTokenReused)
+	mockServices.TokenRepositoryInterface.On("RevokeRefreshTokenFamily", ctx, "family").Return(nil)
+	// run test and validate
+	reply, err := testingService.RefreshToken(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Invalid refresh token", codes.Unauthenticated)
+	mockServices.TokenRepositoryInterface.AssertCalled(t, "RevokeRefreshTokenFamily", ctx, "family")
+}
+
+func TestServiceRefreshTokenExpiredKo(t *testing.T) {
+	request := &api.RefreshTokenRequest{RefreshToken: "refresh-token"}
+	expiredToken := &model.RefreshToken{
+		Hash:      hashRefreshToken(request.RefreshToken),
+		FamilyID:  "family",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("UseRefreshToken", ctx, expiredToken.Hash, mock.AnythingOfType("string")).Return(expiredToken, nil)
+	// run test and validate
+	reply, err := testingService.RefreshToken(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Invalid refresh token", codes.Unauthenticated)
+}
+
+func TestServiceRefreshTokenDeletedUserKo(t *testing.T) {
+	request := &api.RefreshTokenRequest{RefreshToken: "refresh-token"}
+	existingToken := &model.RefreshToken{
+		Hash:      hashRefreshToken(request.RefreshToken),
+		UserID:    "AnIdThatDoesNotExists",
+		FamilyID:  "family",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("UseRefreshToken", ctx, existingToken.Hash, mock.AnythingOfType("string")).Return(existingToken, nil)
+	mockServices.TokenRepositoryInterface.On("GetUserRoles", ctx, existingToken.UserID).Return(nil, model.ErrUserNotFound)
+	// run test and validate
+	reply, err := testingService.RefreshToken(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Invalid refresh token", codes.Unauthenticated)
+}
+
+func TestServiceRefreshTokenRepositoryErrorKo(t *testing.T) {
+	request := &api.RefreshTokenRequest{RefreshToken: "refresh-token"}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("UseRefreshToken", ctx, hashRefreshToken(request.RefreshToken), mock.AnythingOfType("string")).
+		Return(nil, errors.New("repository error"))
+	// run test and validate
+	reply, err := testingService.RefreshToken(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "repository error", codes.Internal)
+}
+
+// REVOKE TOKEN ENDPOINT TESTS
+func TestServiceRevokeTokenOk(t *testing.T) {
+	request := &api.RevokeTokenRequest{RefreshToken: "refresh-token"}
+	existingToken := &model.RefreshToken{Hash: hashRefreshToken(request.RefreshToken), FamilyID: "family"}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("GetRefreshToken", ctx, existingToken.Hash).Return(existingToken, nil)
+	mockServices.TokenRepositoryInterface.On("RevokeRefreshTokenFamily", ctx, "family").Return(nil)
+	// run test and validate
+	reply, err := testingService.RevokeToken(ctx, request)
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, &empty.Empty{}, reply)
+}
+
+func TestServiceRevokeTokenNotFoundKo(t *testing.T) {
+	request := &api.RevokeTokenRequest{RefreshToken: "refresh-token"}
+	mockServices, testingService := setupService()
+	mockServices.TokenRepositoryInterface.On("GetRefreshToken", ctx, hashRefreshToken(request.RefreshToken)).Return(nil, model.ErrRefreshTokenNotFound)
+	// run test and validate
+	reply, err := testingService.RevokeToken(ctx, request)
+	assert.Nil(t, reply)
+	assertStatusError(t, err, "Invalid refresh token", codes.Unauthenticated)
+}
+
+// JWKS ENDPOINT TESTS
+func TestServiceGetJWKSOk(t *testing.T) {
+	_, testingService := setupService()
+	// run test and validate
+	reply, err := testingService.GetJWKS(ctx, &empty.Empty{})
+	if err != nil {
+		t.Error(notExError + err.Error())
+	}
+	assert.Equal(t, 1, len(reply.Keys))
+	assert.Equal(t, "test-key", reply.Keys[0].Kid)
+	assert.Equal(t, "RSA", reply.Keys[0].Kty)
+	assert.Equal(t, "sig", reply.Keys[0].Use)
+}
@@ -0,0 +1,233 @@
+package utility
+
+// This file implements the adaptive password hashing used to store users' passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+	LegacyAlgorithm   = "hmac-sha256"
+
+	argon2idPrefix  = "$argon2id$"
+	argon2idSaltLen = 16
+	argon2idKeyLen  = 32
+
+	// limits of the argon2id parameters, stored hashes outside of them are rejected as malformed
+	// so that a tampered hash cannot exhaust the memory of the service
+	maxArgon2idMemory     = 1024 * 1024 // KiB
+	maxArgon2idIterations = 100
+)
+
+// ErrMalformedHash is returned when a stored hash cannot be decoded
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// PasswordHasher defines the operations to hash passwords and verify them against stored hashes.
+// Verify reports whether the stored hash should be replaced by a new one computed with the current settings
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, encodedHash string) (match bool, needsRehash bool, err error)
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoding them in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// NewArgon2idHasher returns an Argon2idHasher, memory is in KiB and must be at least 8 KiB per thread
+func NewArgon2idHasher(memory int, iterations int, parallelism int) (*Argon2idHasher, error) {
+	if memory < 0 || memory > maxArgon2idMemory || iterations < 0 || iterations > maxArgon2idIterations || parallelism < 0 || parallelism > 255 {
+		return nil, fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", memory, iterations, parallelism)
+	}
+	hasher := &Argon2idHasher{Memory: uint32(memory), Iterations: uint32(iterations), Parallelism: uint8(parallelism)}
+	if err := validateArgon2id(hasher.Memory, hasher.Iterations, hasher.Parallelism); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// validateArgon2id returns an error if argon2id cannot compute a hash with the given parameters
+func validateArgon2id(memory uint32, iterations uint32, parallelism uint8) error {
+	if iterations < 1 || iterations > maxArgon2idIterations || parallelism < 1 ||
+		memory < 8*uint32(parallelism) || memory > maxArgon2idMemory {
+		return fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", memory, iterations, parallelism)
+	}
+	return nil
+}
+
+// Hash returns the encoded argon2id hash of the password with a random salt
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, argon2idKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, hasher.Memory, hasher.Iterations,
+		hasher.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against an encoded argon2id hash, a rehash is needed if the hash parameters differ from the current ones
+func (hasher *Argon2idHasher) Verify(password string, encodedHash string) (bool, bool, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != Argon2idAlgorithm {
+		return false, false, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrMalformedHash
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrMalformedHash
+	}
+	if err := validateArgon2id(memory, iterations, parallelism); err != nil {
+		return false, false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false, ErrMalformedHash
+	}
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false, nil
+	}
+	needsRehash := memory != hasher.Memory || iterations != hasher.Iterations || parallelism != hasher.Parallelism
+	return true, needsRehash, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt, whose encoding already carries version, cost and salt
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher returns a BcryptHasher, bcrypt would silently use its default cost for a cost out of range
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	hasher := &BcryptHasher{Cost: cost}
+	if err := hasher.validate(); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+func (hasher *BcryptHasher) validate() error {
+	if hasher.Cost < bcrypt.MinCost || hasher.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %d, it must be between %d and %d", hasher.Cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+// Hash returns the bcrypt hash of the password
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify checks the password against a bcrypt hash, a rehash is needed if the hash cost differs from the current one
+func (hasher *BcryptHasher) Verify(password string, encodedHash string) (bool, bool, error) {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost != hasher.Cost, nil
+}
+
+// Hash returns the legacy Hmac256 hash of the password, it is kept only to satisfy PasswordHasher
+func (utility *Utility) Hash(password string) (string, error) {
+	return utility.EncodeInput(password), nil
+}
+
+// Verify checks the password against a legacy Hmac256 hash, that always needs to be upgraded
+func (utility *Utility) Verify(password string, encodedHash string) (bool, bool, error) {
+	return utility.VerifyInput(password, encodedHash), true, nil
+}
+
+// Hasher hashes new passwords with the configured algorithm and verifies hashes produced by any supported one.
+// Hashes produced by a different algorithm, or with outdated parameters, are reported as needing a rehash
+type Hasher struct {
+	algorithm string
+	argon2id  *Argon2idHasher
+	bcrypt    *BcryptHasher
+	legacy    *Utility
+}
+
+// NewHasher returns a Hasher using the given algorithm for new hashes
+func NewHasher(algorithm string, argon2id *Argon2idHasher, bcrypt *BcryptHasher, legacy *Utility) (*Hasher, error) {
+	if algorithm != Argon2idAlgorithm && algorithm != BcryptAlgorithm {
+		return nil, fmt.Errorf("unsupported password hashing algorithm %q", algorithm)
+	}
+	if err := validateArgon2id(argon2id.Memory, argon2id.Iterations, argon2id.Parallelism); err != nil {
+		return nil, err
+	}
+	if err := bcrypt.validate(); err != nil {
+		return nil, err
+	}
+	return &Hasher{algorithm: algorithm, argon2id: argon2id, bcrypt: bcrypt, legacy: legacy}, nil
+}
+
+// Hash returns the hash of the password computed with the configured algorithm
+func (hasher *Hasher) Hash(password string) (string, error) {
+	log.Debug("Hashing password with ", hasher.algorithm)
+	if hasher.algorithm == BcryptAlgorithm {
+		return hasher.bcrypt.Hash(password)
+	}
+	return hasher.argon2id.Hash(password)
+}
+
+// Verify checks the password against a hash produced by any of the supported algorithms
+func (hasher *Hasher) Verify(password string, encodedHash string) (bool, bool, error) {
+	algorithm := hashAlgorithm(encodedHash)
+	var scheme PasswordHasher
+	switch algorithm {
+	case Argon2idAlgorithm:
+		scheme = hasher.argon2id
+	case BcryptAlgorithm:
+		scheme = hasher.bcrypt
+	default:
+		scheme = hasher.legacy
+	}
+	match, needsRehash, err := scheme.Verify(password, encodedHash)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, needsRehash || algorithm != hasher.algorithm, nil
+}
+
+// hashAlgorithm returns the algorithm that produced the encoded hash, hashes without prefix are legacy Hmac256 ones
+func hashAlgorithm(encodedHash string) string {
+	switch {
+	case strings.HasPrefix(encodedHash, argon2idPrefix):
+		return Argon2idAlgorithm
+	case strings.HasPrefix(encodedHash, "$2a$"), strings.HasPrefix(encodedHash, "$2b$"), strings.HasPrefix(encodedHash, "$2y$"):
+		return BcryptAlgorithm
+	default:
+		return LegacyAlgorithm
+	}
+}
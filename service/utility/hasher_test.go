@@ -0,0 +1,138 @@
+//go:build unit
+// +build unit
+
+package utility
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func setupHasher(algorithm string) *Hasher {
+	hasher, _ := NewHasher(algorithm,
+		&Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1},
+		&BcryptHasher{Cost: 4},
+		New("TestSecretKey"),
+	)
+	return hasher
+}
+
+func TestArgon2idHashing(t *testing.T) {
+	hasher := setupHasher(Argon2idAlgorithm)
+	// run test and validate
+	encoded, err := hasher.Hash("MyPlaintextPassword")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	match, needsRehash, err := hasher.Verify("MyPlaintextPassword", encoded)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+	match, _, err = hasher.Verify("MyWrongPassword", encoded)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
+
+func TestBcryptHashing(t *testing.T) {
+	hasher := setupHasher(BcryptAlgorithm)
+	// run test and validate
+	encoded, err := hasher.Hash("MyPlaintextPassword")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$2a$04$"))
+	match, needsRehash, err := hasher.Verify("MyPlaintextPassword", encoded)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+	match, _, err = hasher.Verify("MyWrongPassword", encoded)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
+
+func TestSaltIsRandom(t *testing.T) {
+	hasher := setupHasher(Argon2idAlgorithm)
+	// run test and validate
+	first, _ := hasher.Hash("MyPlaintextPassword")
+	second, _ := hasher.Hash("MyPlaintextPassword")
+	assert.NotEqual(t, first, second)
+}
+
+func TestLegacyHashNeedsRehash(t *testing.T) {
+	hasher := setupHasher(Argon2idAlgorithm)
+	legacyHash := "a054183598b2fca6b81324bf6d333955e7cbcfd17331ae65e1f9c2a99b89df37"
+	// run test and validate
+	match, needsRehash, err := hasher.Verify("MyPlaintextPassword", legacyHash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+	match, needsRehash, err = hasher.Verify("MyWrongPassword", legacyHash)
+	assert.Nil(t, err)
+	assert.False(t, match)
+	assert.False(t, needsRehash)
+}
+
+func TestChangedParametersNeedRehash(t *testing.T) {
+	oldHasher := setupHasher(Argon2idAlgorithm)
+	encoded, _ := oldHasher.Hash("MyPlaintextPassword")
+	newHasher, _ := NewHasher(Argon2idAlgorithm,
+		&Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1},
+		&BcryptHasher{Cost: 4},
+		New("TestSecretKey"),
+	)
+	// run test and validate
+	match, needsRehash, err := newHasher.Verify("MyPlaintextPassword", encoded)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+}
+
+func TestChangedAlgorithmNeedsRehash(t *testing.T) {
+	encoded, _ := setupHasher(BcryptAlgorithm).Hash("MyPlaintextPassword")
+	// run test and validate
+	match, needsRehash, err := setupHasher(Argon2idAlgorithm).Verify("MyPlaintextPassword", encoded)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+}
+
+func TestMalformedHash(t *testing.T) {
+	hasher := setupHasher(Argon2idAlgorithm)
+	// run test and validate
+	match, _, err := hasher.Verify("MyPlaintextPassword", "$argon2id$v=19$m=1024$broken")
+	assert.Equal(t, ErrMalformedHash, err)
+	assert.False(t, match)
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	_, err := NewHasher("md5", &Argon2idHasher{}, &BcryptHasher{}, New("TestSecretKey"))
+	// run test and validate
+	assert.NotNil(t, err)
+}
+
+func TestInvalidParameters(t *testing.T) {
+	// run test and validate
+	_, err := NewArgon2idHasher(64*1024, 3, 0)
+	assert.NotNil(t, err)
+	_, err = NewArgon2idHasher(64*1024, 0, 2)
+	assert.NotNil(t, err)
+	_, err = NewArgon2idHasher(64*1024, 3, 256)
+	assert.NotNil(t, err)
+	_, err = NewArgon2idHasher(8, 3, 2)
+	assert.NotNil(t, err)
+	_, err = NewBcryptHasher(2)
+	assert.NotNil(t, err)
+	_, err = NewHasher(Argon2idAlgorithm, &Argon2idHasher{Memory: 1024, Iterations: 1}, &BcryptHasher{Cost: 4}, New("TestSecretKey"))
+	assert.NotNil(t, err)
+	argon2id, err := NewArgon2idHasher(64*1024, 3, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, &Argon2idHasher{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}, argon2id)
+}
+
+func TestOutOfRangeStoredParameters(t *testing.T) {
+	hasher := setupHasher(Argon2idAlgorithm)
+	// run test and validate
+	for _, parameters := range []string{"m=1024,t=1,p=0", "m=1024,t=0,p=1", "m=4294967295,t=1,p=1", "m=1024,t=1,p=300"} {
+		match, _, err := hasher.Verify("MyPlaintextPassword", "$argon2id$v=19$"+parameters+"$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA")
+		assert.Equal(t, ErrMalformedHash, err, parameters)
+		assert.False(t, match)
+	}
+}
@@ -17,6 +17,19 @@ func New(secretKey string) *Utility {
 	return &Utility{secretKey: secretKey}
 }
 
+// VerifyInput returns true if the Hmac256 hash of the input matches the given hex encoded hash.
+// The comparison is done in constant time to avoid leaking information through timing
+func (utility *Utility) VerifyInput(input string, encodedHash string) bool {
+	expected, err := hex.DecodeString(encodedHash)
+	if err != nil {
+		log.Debug("Stored hash is not a valid hex string")
+		return false
+	}
+	hash := hmac.New(sha256.New, []byte(utility.secretKey))
+	hash.Write([]byte(input))
+	return hmac.Equal(hash.Sum(nil), expected)
+}
+
 // EncodeInput returns the resulting Hex string of the Hmac256 hash of the input
 func (utility *Utility) EncodeInput(input string) string {
 	log.Info("Starting hashing procedure.")
@@ -22,3 +22,12 @@ func TestHashing(t *testing.T) {
 	encryptedInput := hashingUtil.EncodeInput(inputToBeEncode)
 	assert.Equal(t, "a054183598b2fca6b81324bf6d333955e7cbcfd17331ae65e1f9c2a99b89df37", encryptedInput)
 }
+
+func TestVerifyInput(t *testing.T) {
+	hashingUtil = setupService()
+	encodedInput := hashingUtil.EncodeInput("MyPlaintextPassword")
+	// run test and validate
+	assert.True(t, hashingUtil.VerifyInput("MyPlaintextPassword", encodedInput))
+	assert.False(t, hashingUtil.VerifyInput("MyWrongPassword", encodedInput))
+	assert.False(t, hashingUtil.VerifyInput("MyPlaintextPassword", "not an hex hash"))
+}